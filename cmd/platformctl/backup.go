@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/apex/log"
+
+	"com.aviebrantz.coap-demo/pkg/config"
+	"com.aviebrantz.coap-demo/pkg/core/store"
+	"com.aviebrantz.coap-demo/pkg/core/store/backup"
+)
+
+// serverFlags are shared by commands that either call the API of a running server
+// or, with -offline, open the storage of the config directly
+type serverFlags struct {
+	url     string
+	token   string
+	offline bool
+}
+
+func (f *serverFlags) register(fs *flag.FlagSet, cfg *config.PlatformConfig) {
+	fs.StringVar(&f.url, "url", "http://localhost:"+strconv.Itoa(cfg.APIServerConfig.Port), "API of the running server")
+	fs.StringVar(&f.token, "token", cfg.APIServerConfig.AdminToken, "admin token of the running server")
+	fs.BoolVar(&f.offline, "offline", false, "open the storage directly, the server must be stopped for local storage")
+}
+
+// request calls the API of the running server with the admin token
+func (f *serverFlags) request(method, path, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, f.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+	return http.DefaultClient.Do(req)
+}
+
+func newFlagSet(name, usage string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: platformctl %s [flags] %s\n", name, usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func backupDB(cfg *config.PlatformConfig, args []string) error {
+	f := &serverFlags{}
+	fs := newFlagSet("backup", "<file>")
+	f.register(fs, cfg)
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing backup file")
+	}
+
+	return writeFileAtomic(fs.Arg(0), func(w io.Writer) error {
+		if !f.offline {
+			return download(f, "/backup/snapshot", w)
+		}
+
+		db, err := openLocalDB(cfg.StorageConfig)
+		if err != nil {
+			return err
+		}
+		defer db.Close()
+
+		n, err := backup.WriteSnapshot(db, w)
+		if err == nil {
+			log.Infof("wrote %d bytes", n)
+		}
+		return err
+	})
+}
+
+func exportData(cfg *config.PlatformConfig, args []string) error {
+	f := &serverFlags{}
+	fs := newFlagSet("export", "<file>")
+	f.register(fs, cfg)
+	format := fs.String("format", backup.FormatNDJSON, "ndjson or json")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing export file")
+	}
+
+	return writeFileAtomic(fs.Arg(0), func(w io.Writer) error {
+		if !f.offline {
+			return download(f, "/backup/export?format="+*format, w)
+		}
+
+		stores, err := openStores(cfg.StorageConfig)
+		if err != nil {
+			return err
+		}
+		defer stores.Close()
+
+		stats, err := backup.Export(context.Background(), stores.Backup(), w, *format)
+		if err == nil {
+			log.Infof("exported %d projects, %d devices and %d points", stats.Projects, stats.Devices, stats.Points)
+		}
+		return err
+	})
+}
+
+// importData loads an export into the storage of the config, which can be another
+// backend than the one it was exported from
+func importData(cfg *config.PlatformConfig, args []string) error {
+	f := &serverFlags{}
+	fs := newFlagSet("import", "<file>")
+	f.register(fs, cfg)
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("missing export file")
+	}
+
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	stats := &backup.Stats{}
+	if f.offline {
+		stores, err := openStores(cfg.StorageConfig)
+		if err != nil {
+			return err
+		}
+		defer stores.Close()
+
+		stats, err = backup.Import(context.Background(), stores.Backup(), file)
+		if err != nil {
+			return err
+		}
+	} else {
+		resp, err := f.request(http.MethodPost, "/backup/import", "application/x-ndjson", file)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return responseError(resp)
+		}
+		err = json.NewDecoder(resp.Body).Decode(stats)
+		if err != nil {
+			return err
+		}
+	}
+
+	log.Infof("imported %d projects, %d devices and %d points", stats.Projects, stats.Devices, stats.Points)
+	if stats.Skipped > 0 {
+		log.Warnf("skipped %d points of series that already had history", stats.Skipped)
+	}
+	return nil
+}
+
+// openStores opens any storage backend. Local databases are probed first,
+// so a running server is reported instead of blocking on its lock
+func openStores(cfg config.StorageConfig) (*store.Stores, error) {
+	if cfg.Type == store.TypeLocal || cfg.Type == "" {
+		db, err := openLocalDB(cfg)
+		if err != nil {
+			return nil, err
+		}
+		db.Close()
+	}
+	return store.Open(context.Background(), cfg)
+}
+
+func download(f *serverFlags, path string, w io.Writer) error {
+	resp, err := f.request(http.MethodGet, path, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return responseError(resp)
+	}
+
+	n, err := io.Copy(w, resp.Body)
+	if err == nil {
+		log.Infof("downloaded %d bytes", n)
+	}
+	return err
+}
+
+func responseError(resp *http.Response) error {
+	body := struct {
+		Message string `json:"message"`
+	}{}
+	err := json.NewDecoder(resp.Body).Decode(&body)
+	if err != nil || body.Message == "" {
+		return fmt.Errorf("server answered %s", resp.Status)
+	}
+	return fmt.Errorf("server answered %s: %s", resp.Status, body.Message)
+}
+
+// writeFileAtomic writes to a temporary file renamed once complete, a failed
+// backup never leaves a truncated file behind
+func writeFileAtomic(path string, write func(w io.Writer) error) error {
+	tmp, err := os.Create(filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp"))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	err = write(tmp)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
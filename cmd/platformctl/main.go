@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/apex/log"
+
+	"com.aviebrantz.coap-demo/pkg/config"
+	"com.aviebrantz.coap-demo/pkg/core/store"
+	bolt "go.etcd.io/bbolt"
+)
+
+type command struct {
+	usage string
+	run   func(cfg *config.PlatformConfig, args []string) error
+}
+
+var commands = map[string]command{
+	"reindex": {
+		usage: "rebuild the device to projects index of a local database",
+		run:   reindex,
+	},
+	"migrate-history": {
+		usage: "rewrite second resolution history keys of a local database to nanosecond keys",
+		run:   migrateHistory,
+	},
+	"migrate-tenancy": {
+		usage: "namespace devices, history and alarms of a local database by project, export and import other backends",
+		run:   migrateTenancy,
+	},
+	"backup": {
+		usage: "write a consistent copy of the local database",
+		run:   backupDB,
+	},
+	"export": {
+		usage: "write projects, devices and history as NDJSON or JSON",
+		run:   exportData,
+	},
+	"import": {
+		usage: "load an export into the configured storage, of any backend",
+		run:   importData,
+	},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: platformctl [-config file] <command> [args]\n\ncommands:\n")
+	for name, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, cmd.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nflags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	configFile := flag.String("config", "./config.yaml", "platform config file")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := config.LoadConfigFromFile(*configFile)
+	if err != nil {
+		log.Fatalf("err loading config file: %v", err)
+	}
+
+	err = cmd.run(cfg, flag.Args()[1:])
+	if err != nil {
+		log.Fatalf("%s: %v", flag.Arg(0), err)
+	}
+}
+
+// openLocalDB opens the bbolt file of a local storage config. The server holds an
+// exclusive lock on it, so commands fail fast instead of waiting while it runs
+func openLocalDB(cfg config.StorageConfig) (*bolt.DB, error) {
+	if cfg.Type != store.TypeLocal && cfg.Type != "" {
+		return nil, fmt.Errorf("command only applies to %s storage, config uses %s", store.TypeLocal, cfg.Type)
+	}
+	db, err := bolt.Open(cfg.URL, 0600, &bolt.Options{Timeout: time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%s is locked, stop the server first", cfg.URL)
+	}
+	return db, err
+}
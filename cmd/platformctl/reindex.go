@@ -0,0 +1,24 @@
+package main
+
+import (
+	"github.com/apex/log"
+
+	"com.aviebrantz.coap-demo/pkg/config"
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+)
+
+func reindex(cfg *config.PlatformConfig, args []string) error {
+	db, err := openLocalDB(cfg.StorageConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	count, err := devices.RebuildDeviceIndex(db)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("indexed %d devices", count)
+	return nil
+}
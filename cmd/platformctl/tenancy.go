@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+
+	"github.com/apex/log"
+
+	"com.aviebrantz.coap-demo/pkg/config"
+	"com.aviebrantz.coap-demo/pkg/core/store/alarms"
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store/historical"
+	bolt "go.etcd.io/bbolt"
+)
+
+// migrateTenancy moves devices first, so history follows the project each device ended up in.
+// History of devices deleted before the migration goes to the unassigned namespace
+func migrateTenancy(cfg *config.PlatformConfig, args []string) error {
+	db, err := openLocalDB(cfg.StorageConfig)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	count, err := devices.NamespaceDevices(db)
+	if err != nil {
+		return err
+	}
+	log.Infof("moved %d devices to their project namespace", count)
+
+	projectOf, err := deviceProjects(db)
+	if err != nil {
+		return err
+	}
+	count, err = historical.NamespaceSeries(db, func(datatype, id string) string {
+		return projectOf[id]
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Infof("moved %d history buckets to their project namespace", count)
+
+	count, err = alarms.NamespaceAlarms(db)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("moved %d alarms to their project namespace", count)
+	return nil
+}
+
+// deviceProjects maps device IDs to their project, IDs found in several projects are left out
+func deviceProjects(db *bolt.DB) (map[string]string, error) {
+	list, err := devices.NewDeviceLocalStore(db).ListDevices(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	projectOf := make(map[string]string, len(list))
+	seen := make(map[string]int, len(list))
+	for _, device := range list {
+		projectOf[device.ID] = device.ProjectID
+		seen[device.ID]++
+	}
+	for id, n := range seen {
+		if n > 1 {
+			delete(projectOf, id)
+		}
+	}
+	return projectOf, nil
+}
@@ -7,6 +7,7 @@ require (
 	github.com/apex/log v1.9.0
 	github.com/fxamacker/cbor/v2 v2.2.0
 	github.com/gofiber/fiber v1.14.4
+	github.com/google/uuid v1.1.1
 	github.com/jeremywohl/flatten v1.0.1
 	github.com/nqd/flat v0.1.0
 	github.com/pion/dtls/v2 v2.0.2
@@ -16,14 +17,14 @@ require (
 	go.opencensus.io v0.22.4
 	gocloud.dev v0.20.0
 	gocloud.dev/docstore/mongodocstore v0.20.0
-	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a // indirect
+	golang.org/x/crypto v0.0.0-20200820211705-5c72a883971a
 	golang.org/x/net v0.0.0-20200822124328-c89045814202 // indirect
 	golang.org/x/sys v0.0.0-20200905004654-be1d3432aa8f // indirect
 	golang.org/x/text v0.3.3 // indirect
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
 	google.golang.org/genproto v0.0.0-20200904004341-0bd0a958aa1d // indirect
 	google.golang.org/grpc v1.31.1 // indirect
-	google.golang.org/protobuf v1.25.0 // indirect
+	google.golang.org/protobuf v1.25.0
 	gopkg.in/yaml.v2 v2.2.4 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20200605160147-a5ece683394c
 )
@@ -10,23 +10,23 @@ import (
 
 	"com.aviebrantz.coap-demo/pkg/api"
 	"com.aviebrantz.coap-demo/pkg/config"
-	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store"
 	"com.aviebrantz.coap-demo/pkg/core/store/historical"
-	"com.aviebrantz.coap-demo/pkg/core/store/projects"
+	"com.aviebrantz.coap-demo/pkg/egress/cloudevents"
+	"com.aviebrantz.coap-demo/pkg/egress/webhooks"
 	"com.aviebrantz.coap-demo/pkg/gateway/coap"
 	"com.aviebrantz.coap-demo/pkg/ingestion/realtime"
 	"com.aviebrantz.coap-demo/pkg/ingestion/timeseries"
+	"com.aviebrantz.coap-demo/pkg/rules/alarming"
+	"com.aviebrantz.coap-demo/pkg/rules/engine"
 	"gocloud.dev/pubsub"
 
-	bolt "go.etcd.io/bbolt"
-
-	_ "gocloud.dev/docstore/memdocstore"
-	_ "gocloud.dev/docstore/mongodocstore"
 	_ "gocloud.dev/pubsub/mempubsub"
 )
 
 var (
-	dataTopic *pubsub.Topic
+	dataTopic   *pubsub.Topic
+	eventsTopic *pubsub.Topic
 )
 
 func setupDataTopic(ctx context.Context) error {
@@ -43,6 +43,20 @@ func setupDataTopic(ctx context.Context) error {
 	return nil
 }
 
+func setupEventsTopic(ctx context.Context) error {
+	if eventsTopic != nil {
+		return nil
+	}
+
+	var err error
+	eventsTopic, err = pubsub.OpenTopic(ctx, "mem://eventsTopic")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
 func setupDataSub(ctx context.Context) (*pubsub.Subscription, error) {
 	dataSub, err := pubsub.OpenSubscription(ctx, "mem://dataTopic")
 	if err != nil {
@@ -51,6 +65,14 @@ func setupDataSub(ctx context.Context) (*pubsub.Subscription, error) {
 	return dataSub, nil
 }
 
+func setupEventsSub(ctx context.Context) (*pubsub.Subscription, error) {
+	eventsSub, err := pubsub.OpenSubscription(ctx, "mem://eventsTopic")
+	if err != nil {
+		return nil, err
+	}
+	return eventsSub, nil
+}
+
 func shutdownTopic(ctx context.Context, topic *pubsub.Topic) {
 	if topic == nil {
 		return
@@ -65,15 +87,11 @@ func shutdownSub(ctx context.Context, sub *pubsub.Subscription) {
 	sub.Shutdown(ctx)
 }
 
-func getDocStoreUrl(coll, id string) string {
-	baseDocStoreURL := "mongo://iot-coap-platform/"
-	url := baseDocStoreURL + coll
-	if id != "" {
-		url += "?id_field=" + id
+func closeStores(stores *store.Stores) {
+	err := stores.Close()
+	if err != nil {
+		log.Errorf("err closing storage: %v", err)
 	}
-	return url
-	//baseDocStoreURL := "mem://devices/deviceID"
-	//return baseDocStoreURL + coll + "/" + id
 }
 
 func main() {
@@ -83,12 +101,24 @@ func main() {
 	}
 
 	ctx := context.Background()
+	stores, err := store.Open(ctx, config.StorageConfig)
+	if err != nil {
+		log.Fatalf("could not open %s storage: %v", config.StorageConfig.Type, err)
+	}
+	defer closeStores(stores)
+
 	err = setupDataTopic(ctx)
 	if err != nil {
 		log.Fatalf("Err creating data topic :%v", err)
 	}
 	defer shutdownTopic(ctx, dataTopic)
 
+	err = setupEventsTopic(ctx)
+	if err != nil {
+		log.Fatalf("Err creating events topic :%v", err)
+	}
+	defer shutdownTopic(ctx, eventsTopic)
+
 	realtimeIngestorSub, err := setupDataSub(ctx)
 	if err != nil {
 		log.Fatalf("could not open data topic subscription :%v", err)
@@ -101,54 +131,91 @@ func main() {
 	}
 	defer shutdownSub(ctx, tsIngestorSub)
 
-	os.Setenv("MONGO_SERVER_URL", "mongodb://localhost:27017")
-	/*deviceCollURL := getDocStoreUrl("devices", "deviceID")
-	devicesColl, err := docstore.OpenCollection(ctx, deviceCollURL)
+	rulesEngineSub, err := setupDataSub(ctx)
 	if err != nil {
-		log.Fatalf("could not open devices collection :%v", err)
+		log.Fatalf("could not open data topic subscription :%v", err)
 	}
-	defer devicesColl.Close()
+	defer shutdownSub(ctx, rulesEngineSub)
 
-	projectsCollURL := getDocStoreUrl("projects", "id")
-	projectsColl, err := docstore.OpenCollection(ctx, projectsCollURL)
+	alarmManagerSub, err := setupEventsSub(ctx)
 	if err != nil {
-		log.Fatalf("could not open project collection :%v", err)
+		log.Fatalf("could not open events topic subscription :%v", err)
 	}
-	defer projectsColl.Close()
+	defer shutdownSub(ctx, alarmManagerSub)
 
-	deviceHistoryCollURL := getDocStoreUrl("device_history", "")
-	deviceHistoryColl, err := docstore.OpenCollection(ctx, deviceHistoryCollURL)
+	webhookDataSub, err := setupDataSub(ctx)
 	if err != nil {
-		log.Fatalf("could not open device history collection :%v", err)
+		log.Fatalf("could not open data topic subscription :%v", err)
 	}
-	defer deviceHistoryColl.Close()
-	*/
-
-	//deviceStore := devices.NewDeviceDocStore(devicesColl)
-	//projectStore := projects.NewProjectDocStore(projectsColl)
+	defer shutdownSub(ctx, webhookDataSub)
 
-	db, err := bolt.Open(config.StorageConfig.URL, 0600, nil)
+	webhookEventsSub, err := setupEventsSub(ctx)
 	if err != nil {
-		log.Fatalf("could not open device local store: %v", err)
+		log.Fatalf("could not open events topic subscription :%v", err)
 	}
-
-	deviceStore := devices.NewDeviceLocalStore(db)
-	projectStore := projects.NewProjectLocalStore(db)
-	timeseriesStore := historical.NewTimeSeriesLocalStore(db)
+	defer shutdownSub(ctx, webhookEventsSub)
 
 	for _, cfg := range config.GatewayConfigs {
 		if cfg.Protocol == "coap" {
-			gateway := coap.NewGateway(dataTopic, &cfg)
+			gateway := coap.NewGateway(dataTopic, stores.Devices, stores.Projects, stores.Templates, &cfg, config.MessagingConfig)
 			go gateway.Start()
 		}
 	}
 
-	realtimeIngestor := realtime.NewIngestor(realtimeIngestorSub, deviceStore)
-	timeseriesIngestor := timeseries.NewIngestor(tsIngestorSub, timeseriesStore)
-	apiServer := api.NewServer(deviceStore, projectStore, timeseriesStore, config.APIServerConfig)
+	for _, cfg := range config.EgressConfigs {
+		if cfg.Type == "cloudevents" {
+			egressSub, err := setupDataSub(ctx)
+			if err != nil {
+				log.Fatalf("could not open data topic subscription :%v", err)
+			}
+			defer shutdownSub(ctx, egressSub)
+
+			publisher, err := cloudevents.NewPublisher(ctx, egressSub, cfg)
+			if err != nil {
+				log.Fatalf("could not create cloudevents egress :%v", err)
+			}
+			defer publisher.Shutdown(ctx)
+			go publisher.Start()
+		}
+	}
+
+	realtimeIngestor := realtime.NewIngestor(realtimeIngestorSub, stores.Devices)
+	timeseriesIngestor := timeseries.NewIngestor(tsIngestorSub, stores.TimeSeries)
+	rulesEngine := engine.NewEngine(rulesEngineSub, eventsTopic, stores.Devices, stores.Rules)
+	alarmManager := alarming.NewManager(alarmManagerSub, eventsTopic, stores.Alarms)
+	historyCompactor := historical.NewCompactor(
+		stores.TimeSeries,
+		stores.Projects,
+		stores.Devices,
+		config.StorageConfig.Compaction.Interval,
+	)
+	webhookDispatcher := webhooks.NewDispatcher(webhookDataSub, webhookEventsSub, stores.Webhooks)
+	apiServer := api.NewServer(
+		stores.Devices,
+		stores.Projects,
+		stores.TimeSeries,
+		stores.Rules,
+		stores.Alarms,
+		stores.Webhooks,
+		stores.Groups,
+		stores.Templates,
+		stores.APIKeys,
+		stores.Users,
+		stores.Snapshotter(),
+		eventsTopic,
+		config.APIServerConfig,
+	)
+	err = apiServer.CheckAuth(ctx)
+	if err != nil {
+		log.Fatalf("could not start the api: %v", err)
+	}
 
 	go realtimeIngestor.Start()
 	go timeseriesIngestor.Start()
+	go rulesEngine.Start()
+	go alarmManager.Start()
+	go historyCompactor.Start()
+	go webhookDispatcher.Start()
 	go apiServer.Start()
 	//go metrics.StartMetricsExporter()
 
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"strconv"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/events"
+	"com.aviebrantz.coap-demo/pkg/core/store/alarms"
+	"github.com/gofiber/fiber"
+)
+
+type alarmActionRequest struct {
+	Comment string `json:"comment" form:"comment"`
+}
+
+func parseAlarmQuery(ctx *fiber.Ctx) (alarms.AlarmQuery, error) {
+	query := alarms.AlarmQuery{
+		DeviceID: ctx.Query("deviceID"),
+		Type:     ctx.Query("type"),
+		Status:   ctx.Query("status"),
+		Severity: ctx.Query("severity"),
+	}
+	if deviceID := ctx.Params("deviceID"); deviceID != "" {
+		query.DeviceID = deviceID
+	}
+	if value := ctx.Query("acknowledged"); value != "" {
+		acknowledged, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, err
+		}
+		query.Acknowledged = &acknowledged
+	}
+	return query, nil
+}
+
+func (as *ApiServer) getAlarms(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+
+	query, err := parseAlarmQuery(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	list, err := as.alarmStore.ListAlarms(ctx.Context(), project, query)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(list)
+}
+
+func (as *ApiServer) getAlarmByProject(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	alarmID := ctx.Params("alarmID")
+
+	alarm, err := as.alarmStore.GetAlarmByID(ctx.Context(), project, alarmID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if alarm == nil {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "not found"})
+		return
+	}
+
+	ctx.JSON(alarm)
+}
+
+func (as *ApiServer) acknowledgeAlarm(ctx *fiber.Ctx) {
+	as.updateAlarm(ctx, events.TypeAlarmAcknowledged, func(alarm *alarms.Alarm, req *alarmActionRequest) error {
+		return alarm.Acknowledge(requestIdentity(ctx), req.Comment, time.Now())
+	})
+}
+
+func (as *ApiServer) clearAlarm(ctx *fiber.Ctx) {
+	as.updateAlarm(ctx, events.TypeAlarmCleared, func(alarm *alarms.Alarm, req *alarmActionRequest) error {
+		return alarm.Clear(requestIdentity(ctx), req.Comment, time.Now())
+	})
+}
+
+// updateAlarm applies an action to the alarm on behalf of the principal of the request
+func (as *ApiServer) updateAlarm(ctx *fiber.Ctx, eventType string, action func(*alarms.Alarm, *alarmActionRequest) error) {
+	project := ctx.Params("project")
+	alarmID := ctx.Params("alarmID")
+
+	req := &alarmActionRequest{}
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(req); err != nil {
+			ctx.
+				Status(fiber.StatusBadRequest).
+				JSON(fiber.Map{"message": "Invalid alarm action"})
+			return
+		}
+	}
+
+	alarm, err := as.alarmStore.GetAlarmByID(ctx.Context(), project, alarmID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if alarm == nil {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "not found"})
+		return
+	}
+
+	err = action(alarm, req)
+	if err != nil {
+		ctx.Status(fiber.StatusConflict)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	err = as.alarmStore.UpdateAlarm(ctx.Context(), alarm)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	as.publishEvent(ctx, eventType, alarm.ProjectID, alarm.DeviceID, alarm)
+	ctx.JSON(alarm)
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/apikeys"
+	"com.aviebrantz.coap-demo/pkg/core/store/users"
+	"github.com/apex/log"
+	"github.com/gofiber/fiber"
+)
+
+// keyUsageResolution limits how often the last use of a key is written, keys are
+// checked on every request
+const keyUsageResolution = time.Minute
+
+// principalLocal keeps the principal of a project request for the permission checks
+const principalLocal = "principal"
+
+// keyScopePermissions are the permissions granted by the scopes of API keys,
+// keys can't manage their project
+var keyScopePermissions = map[string][]string{
+	apikeys.ScopeRead:  {users.PermissionRead, users.PermissionReadHistory},
+	apikeys.ScopeWrite: {users.PermissionWrite, users.PermissionSendCommands},
+}
+
+// principal is who a request authenticates as: the admin, an API key or a user,
+// with the role of the user in the project of the request
+type principal struct {
+	admin bool
+	key   *apikeys.APIKey
+	email string
+	role  string
+}
+
+func (p *principal) has(permission string) bool {
+	switch {
+	case p.admin:
+		return true
+	case p.key != nil:
+		for _, scope := range p.key.Scopes {
+			for _, granted := range keyScopePermissions[scope] {
+				if granted == permission {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return users.Can(p.role, permission)
+}
+
+// identity names the principal in change logs and alarm actions: admin, key:<id> or the user email
+func (p *principal) identity() string {
+	switch {
+	case p.admin:
+		return "admin"
+	case p.key != nil:
+		return "key:" + p.key.ID
+	}
+	return p.email
+}
+
+// requestIdentity names who made a project request, anonymous when the API is not authenticated
+func requestIdentity(ctx *fiber.Ctx) string {
+	if p, _ := ctx.Locals(principalLocal).(*principal); p != nil {
+		return p.identity()
+	}
+	return "anonymous"
+}
+
+// bearerToken reads the token of the Authorization header, empty when missing
+func bearerToken(ctx *fiber.Ctx) string {
+	header := ctx.Get(fiber.HeaderAuthorization)
+	if !strings.HasPrefix(header, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[len("Bearer "):])
+}
+
+// authEnabled is true as soon as a credential source is configured, CheckAuth refuses to
+// start without the admin token in that case
+func (as *ApiServer) authEnabled() bool {
+	return as.config.AdminToken != "" || as.config.JWTKey != ""
+}
+
+// CheckAuth fails when users can sign in or projects have API keys but no admin token is
+// configured, every route would otherwise be open
+func (as *ApiServer) CheckAuth(ctx context.Context) error {
+	if as.config.AdminToken != "" {
+		return nil
+	}
+	if as.config.JWTKey != "" {
+		return errors.New("jwtKey is set without an adminToken")
+	}
+
+	list, err := as.projectStore.ListProjects(ctx)
+	if err != nil {
+		return err
+	}
+	for _, project := range list {
+		keys, err := as.apiKeyStore.ListKeysForProject(ctx, project.ID)
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			return fmt.Errorf("project %s has API keys but no adminToken is set", project.ID)
+		}
+	}
+	return nil
+}
+
+// credentialsAllowed answers 501 when no admin token is configured, API keys and invites
+// issued then would be minted by anyone and keep CheckAuth from passing on restart
+func (as *ApiServer) credentialsAllowed(ctx *fiber.Ctx) bool {
+	if as.config.AdminToken == "" {
+		ctx.Status(fiber.StatusNotImplemented)
+		ctx.JSON(fiber.Map{"message": "credentials can only be issued with an admin token configured"})
+		return false
+	}
+	return true
+}
+
+func (as *ApiServer) isAdmin(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(token), []byte(as.config.AdminToken)) == 1
+}
+
+// authenticate resolves the principal of the bearer token, writing the error response on failure.
+// Tokens are the admin token, user tokens or API keys
+func (as *ApiServer) authenticate(ctx *fiber.Ctx) *principal {
+	token := bearerToken(ctx)
+	if token == "" {
+		ctx.Status(fiber.StatusUnauthorized)
+		ctx.JSON(fiber.Map{"message": "missing credentials"})
+		return nil
+	}
+	if as.isAdmin(token) {
+		return &principal{admin: true}
+	}
+
+	if users.IsToken(token) && as.config.JWTKey != "" {
+		email, err := users.VerifyToken([]byte(as.config.JWTKey), token)
+		if err != nil {
+			ctx.Status(fiber.StatusUnauthorized)
+			ctx.JSON(fiber.Map{"message": err.Error()})
+			return nil
+		}
+		return &principal{email: email}
+	}
+
+	var key *apikeys.APIKey
+	id, secret, ok := apikeys.ParseToken(token)
+	if ok {
+		var err error
+		key, err = as.apiKeyStore.GetKeyByID(ctx.Context(), id)
+		if err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			ctx.JSON(fiber.Map{"message": err.Error()})
+			return nil
+		}
+	}
+	if key == nil || !key.Verify(secret) {
+		ctx.Status(fiber.StatusUnauthorized)
+		ctx.JSON(fiber.Map{"message": "invalid credentials"})
+		return nil
+	}
+
+	as.recordKeyUsage(ctx, key)
+	return &principal{key: key}
+}
+
+// requireAdmin guards platform wide routes and key management with the admin token
+func (as *ApiServer) requireAdmin(ctx *fiber.Ctx) {
+	if !as.authEnabled() {
+		ctx.Next()
+		return
+	}
+
+	p, _ := ctx.Locals(principalLocal).(*principal)
+	if p == nil {
+		p = as.authenticate(ctx)
+		if p == nil {
+			return
+		}
+	}
+	if !p.admin {
+		ctx.Status(fiber.StatusForbidden)
+		ctx.JSON(fiber.Map{"message": "admin token required"})
+		return
+	}
+	ctx.Next()
+}
+
+// authorizeProject lets the admin, the API keys of the project and its members through,
+// the permissions of each route are checked by allow
+func (as *ApiServer) authorizeProject(ctx *fiber.Ctx) {
+	if !as.authEnabled() {
+		ctx.Next()
+		return
+	}
+
+	p := as.authenticate(ctx)
+	if p == nil {
+		return
+	}
+
+	project := ctx.Params("project")
+	switch {
+	case p.key != nil && p.key.ProjectID != project:
+		ctx.Status(fiber.StatusForbidden)
+		ctx.JSON(fiber.Map{"message": "key is not valid for this project"})
+		return
+	case p.email != "":
+		member, err := as.userStore.GetMember(ctx.Context(), project, p.email)
+		if err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			ctx.JSON(fiber.Map{"message": err.Error()})
+			return
+		}
+		if member == nil {
+			ctx.Status(fiber.StatusForbidden)
+			ctx.JSON(fiber.Map{"message": "not a member of this project"})
+			return
+		}
+		p.role = member.Role
+	}
+
+	ctx.Locals(principalLocal, p)
+	ctx.Next()
+}
+
+// allow guards a project route with a permission, project routes are authorized first
+func (as *ApiServer) allow(permission string) fiber.Handler {
+	return func(ctx *fiber.Ctx) {
+		if as.permitted(ctx, permission) {
+			ctx.Next()
+		}
+	}
+}
+
+// permitted checks a permission of the principal in the project of the request,
+// writing the error response when missing
+func (as *ApiServer) permitted(ctx *fiber.Ctx, permission string) bool {
+	if !as.authEnabled() {
+		return true
+	}
+
+	p, _ := ctx.Locals(principalLocal).(*principal)
+	if p == nil || !p.has(permission) {
+		ctx.Status(fiber.StatusForbidden)
+		ctx.JSON(fiber.Map{"message": "missing permission " + permission})
+		return false
+	}
+	return true
+}
+
+// permittedIn checks a permission of the principal in another project than the one of the request,
+// writing the error response when missing
+func (as *ApiServer) permittedIn(ctx *fiber.Ctx, projectID, permission string) bool {
+	if !as.authEnabled() {
+		return true
+	}
+
+	p, _ := ctx.Locals(principalLocal).(*principal)
+	allowed := p != nil && p.admin
+	if p != nil && p.key != nil {
+		allowed = p.key.ProjectID == projectID && p.has(permission)
+	}
+	if p != nil && p.email != "" {
+		member, err := as.userStore.GetMember(ctx.Context(), projectID, p.email)
+		if err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			ctx.JSON(fiber.Map{"message": err.Error()})
+			return false
+		}
+		allowed = member != nil && users.Can(member.Role, permission)
+	}
+
+	if !allowed {
+		ctx.Status(fiber.StatusForbidden)
+		ctx.JSON(fiber.Map{"message": "missing permission " + permission + " in project " + projectID})
+		return false
+	}
+	return true
+}
+
+// recordKeyUsage updates the last use of the key, failures don't fail the request
+func (as *ApiServer) recordKeyUsage(ctx *fiber.Ctx, key *apikeys.APIKey) {
+	now := time.Now()
+	if now.Sub(key.LastUsed) < keyUsageResolution {
+		return
+	}
+	err := as.apiKeyStore.TouchKey(ctx.Context(), key.ID, now)
+	if err != nil {
+		log.WithField("module", "api").Errorf("err recording usage of key %s: %v", key.ID, err)
+	}
+}
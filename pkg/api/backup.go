@@ -0,0 +1,79 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/backup"
+	"github.com/apex/log"
+	"github.com/gofiber/fiber"
+)
+
+const backupTimeFormat = "20060102-150405"
+
+func (as *ApiServer) backupStores() backup.Stores {
+	return backup.Stores{
+		Projects:   as.projectStore,
+		Devices:    as.deviceStore,
+		TimeSeries: as.timeseriesStore,
+	}
+}
+
+// getSnapshot streams a consistent copy of the local database, ready to replace local.db.
+// Errors after the first byte can only be reported by cutting the stream short
+func (as *ApiServer) getSnapshot(ctx *fiber.Ctx) {
+	if as.snapshotter == nil {
+		ctx.Status(fiber.StatusNotImplemented)
+		ctx.JSON(fiber.Map{"message": backup.ErrSnapshotUnsupported.Error()})
+		return
+	}
+
+	ctx.Attachment("local-" + time.Now().UTC().Format(backupTimeFormat) + ".db")
+	ctx.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+	ctx.Fasthttp.SetBodyStreamWriter(func(w *bufio.Writer) {
+		_, err := as.snapshotter.Snapshot(w)
+		if err != nil {
+			log.Errorf("err streaming snapshot: %v", err)
+		}
+	})
+}
+
+// exportData streams projects, devices and history as NDJSON, or a JSON array with format=json
+func (as *ApiServer) exportData(ctx *fiber.Ctx) {
+	format := ctx.Query("format")
+	if format == "" {
+		format = backup.FormatNDJSON
+	}
+	if format != backup.FormatNDJSON && format != backup.FormatJSON {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": "format must be ndjson or json"})
+		return
+	}
+
+	ctx.Attachment("export-" + time.Now().UTC().Format(backupTimeFormat) + "." + format)
+	if format == backup.FormatNDJSON {
+		ctx.Set(fiber.HeaderContentType, "application/x-ndjson")
+	}
+	ctx.Fasthttp.SetBodyStreamWriter(func(w *bufio.Writer) {
+		// The request context is gone once the handler returns
+		_, err := backup.Export(context.Background(), as.backupStores(), w, format)
+		if err != nil {
+			log.Errorf("err streaming export: %v", err)
+		}
+	})
+}
+
+// importData loads an export of either format into the configured storage
+func (as *ApiServer) importData(ctx *fiber.Ctx) {
+	body := bytes.NewReader(ctx.Fasthttp.PostBody())
+	stats, err := backup.Import(ctx.Context(), as.backupStores(), body)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error(), "imported": stats})
+		return
+	}
+
+	ctx.JSON(stats)
+}
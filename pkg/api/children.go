@@ -0,0 +1,142 @@
+package api
+
+import (
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"github.com/gofiber/fiber"
+)
+
+type setParentRequest struct {
+	ParentID string `json:"parentID" form:"parentID"`
+}
+
+// setDeviceParent registers the device as a child of a gateway of the same project, the gateway
+// can then post state on its behalf. Gateways are not nested, a gateway can't be a child
+func (as *ApiServer) setDeviceParent(ctx *fiber.Ctx) {
+	deviceID := ctx.Params("deviceID")
+	project := ctx.Params("project")
+
+	req := &setParentRequest{}
+	if err := ctx.BodyParser(req); err != nil || req.ParentID == "" {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Missing parentID"})
+		return
+	}
+	if req.ParentID == deviceID {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": "a device can't be its own parent"})
+		return
+	}
+
+	revision, err := parseIfMatch(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if as.findProjectDevice(ctx, project, deviceID) == nil {
+		return
+	}
+	parent := as.findProjectDevice(ctx, project, req.ParentID)
+	if parent == nil {
+		return
+	}
+	if parent.ParentID() != "" {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": "parent is itself a child of " + parent.ParentID()})
+		return
+	}
+
+	children := as.deviceChildren(ctx, project, deviceID)
+	if children == nil {
+		return
+	}
+	if len(children) > 0 {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": "device is a gateway with children"})
+		return
+	}
+
+	device, err := as.deviceStore.UpdateDevice(changeContext(ctx), project, deviceID, revision, time.Now(), map[string]interface{}{
+		devices.ParentField: req.ParentID,
+	})
+	if err != nil {
+		writeDeviceError(ctx, err)
+		return
+	}
+
+	ctx.Set(fiber.HeaderETag, deviceETag(device.Revision))
+	ctx.JSON(device)
+}
+
+// removeDeviceParent detaches the device from its gateway, which can no longer post on its behalf
+func (as *ApiServer) removeDeviceParent(ctx *fiber.Ctx) {
+	deviceID := ctx.Params("deviceID")
+	project := ctx.Params("project")
+
+	revision, err := parseIfMatch(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	device := as.findProjectDevice(ctx, project, deviceID)
+	if device == nil {
+		return
+	}
+	if device.ParentID() == "" {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "device has no parent"})
+		return
+	}
+
+	device, err = as.deviceStore.UpdateDevice(changeContext(ctx), project, deviceID, revision, time.Now(), map[string]interface{}{
+		devices.ParentField: "",
+	})
+	if err != nil {
+		writeDeviceError(ctx, err)
+		return
+	}
+
+	ctx.Set(fiber.HeaderETag, deviceETag(device.Revision))
+	ctx.JSON(device)
+}
+
+// getDeviceChildren lists the devices registered as children of a gateway
+func (as *ApiServer) getDeviceChildren(ctx *fiber.Ctx) {
+	deviceID := ctx.Params("deviceID")
+	project := ctx.Params("project")
+
+	if as.findProjectDevice(ctx, project, deviceID) == nil {
+		return
+	}
+
+	children := as.deviceChildren(ctx, project, deviceID)
+	if children == nil {
+		return
+	}
+
+	ctx.JSON(children)
+}
+
+// deviceChildren lists the children of a gateway, writing the error response on failure
+func (as *ApiServer) deviceChildren(ctx *fiber.Ctx, project, gatewayID string) []*devices.Device {
+	list, err := as.deviceStore.ListDevicesForProject(ctx.Context(), project)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return nil
+	}
+
+	children := make([]*devices.Device, 0)
+	for _, device := range list {
+		if device.ParentID() == gatewayID {
+			children = append(children, device)
+		}
+	}
+	return children
+}
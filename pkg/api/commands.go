@@ -0,0 +1,58 @@
+package api
+
+import (
+	"com.aviebrantz.coap-demo/pkg/core/events"
+	"github.com/gofiber/fiber"
+)
+
+// sendCommand publishes a command supported by the template of the device as a platform event,
+// for the integrations delivering commands to devices. The body is the input of the command
+func (as *ApiServer) sendCommand(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	deviceID := ctx.Params("deviceID")
+	name := ctx.Params("command")
+
+	input := make(map[string]interface{})
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(&input); err != nil {
+			ctx.
+				Status(fiber.StatusBadRequest).
+				JSON(fiber.Map{"message": "Invalid command input"})
+			return
+		}
+	}
+
+	device := as.findProjectDevice(ctx, project, deviceID)
+	if device == nil {
+		return
+	}
+	if device.TemplateID() == "" {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": "device has no template defining its commands"})
+		return
+	}
+	template := as.findTemplate(ctx, project, device.TemplateID())
+	if template == nil {
+		return
+	}
+
+	command := template.Command(name)
+	if command == nil {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "command " + name + " not supported by template " + template.Name})
+		return
+	}
+	if err := command.ValidateInput(input); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	as.publishEvent(ctx, events.TypeDeviceCommand, project, deviceID, fiber.Map{
+		"deviceID": deviceID,
+		"command":  command.Name,
+		"input":    input,
+	})
+	ctx.Status(fiber.StatusAccepted)
+	ctx.JSON(fiber.Map{"message": "sent"})
+}
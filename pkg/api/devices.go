@@ -1,25 +1,47 @@
 package api
 
 import (
+	"context"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/alarms"
 	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store/users"
 	"github.com/gofiber/fiber"
 )
 
+type deviceView struct {
+	*devices.Device
+	ActiveAlarms *alarms.Summary `json:"activeAlarms"`
+}
+
+// reservedDeviceFields are maintained by the platform, projectID changes through the move route,
+// metadata, parentID and templateID through their own routes
+var reservedDeviceFields = []string{"deviceID", "projectID", "revision", "created", "updated", "metadata", devices.ParentField, devices.TemplateField}
+
+// nextCursorHeader carries the cursor of the next page of a device listing, absent on the last page
+const nextCursorHeader = "X-Next-Cursor"
+
 func (as *ApiServer) getDevicesByProject(ctx *fiber.Ctx) {
 	project := ctx.Params("project")
-	list, err := as.deviceStore.ListDevicesForProject(ctx.Context(), project)
-
+	query, err := parseDeviceQuery(ctx)
 	if err != nil {
 		ctx.Status(fiber.StatusBadRequest)
 		ctx.JSON(fiber.Map{"message": err.Error()})
 		return
 	}
 
-	if list == nil {
-		list = make([]*devices.Device, 0)
+	page, err := as.deviceStore.QueryDevicesForProject(ctx.Context(), project, query)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
 	}
 
-	ctx.JSON(list)
+	if page.NextCursor != "" {
+		ctx.Set(nextCursorHeader, page.NextCursor)
+	}
+	ctx.JSON(page.Devices)
 }
 
 func (as *ApiServer) getDeviceByProject(ctx *fiber.Ctx) {
@@ -27,7 +49,47 @@ func (as *ApiServer) getDeviceByProject(ctx *fiber.Ctx) {
 	deviceID := ctx.Params("deviceID")
 	project := ctx.Params("project")
 
-	device, err := as.deviceStore.GetDeviceByID(c, deviceID)
+	if value := ctx.Query("asOf"); value != "" {
+		// Past states are rebuilt from the change log, which is history
+		if as.permitted(ctx, users.PermissionReadHistory) {
+			as.getDeviceStateAt(ctx, project, deviceID, value)
+		}
+		return
+	}
+
+	device := as.findProjectDevice(ctx, project, deviceID)
+	if device == nil {
+		return
+	}
+
+	activeAlarms, err := as.alarmStore.ListAlarms(c, project, alarms.AlarmQuery{
+		DeviceID: deviceID,
+		Status:   alarms.StatusActive,
+	})
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.Set(fiber.HeaderETag, deviceETag(device.Revision))
+	ctx.JSON(&deviceView{
+		Device:       device,
+		ActiveAlarms: alarms.Summarize(activeAlarms),
+	})
+}
+
+// getDeviceStateAt answers with the state rebuilt at the RFC3339 asOf time, from the change log
+// of the project. Active alarms are only known for the current state
+func (as *ApiServer) getDeviceStateAt(ctx *fiber.Ctx, project, deviceID, value string) {
+	asOf, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	device, err := as.deviceStore.DeviceStateAt(ctx.Context(), project, deviceID, asOf)
 	if err != nil {
 		ctx.Status(fiber.StatusBadRequest)
 		ctx.JSON(fiber.Map{"message": err.Error()})
@@ -40,11 +102,231 @@ func (as *ApiServer) getDeviceByProject(ctx *fiber.Ctx) {
 		return
 	}
 
-	if device.ProjectID != project {
-		ctx.Status(fiber.StatusNotFound)
-		ctx.JSON(fiber.Map{"message": "not found"})
+	ctx.JSON(device)
+}
+
+// updateDevice merges the body into the device state, only at the If-Match revision when given
+func (as *ApiServer) updateDevice(ctx *fiber.Ctx) {
+	deviceID := ctx.Params("deviceID")
+	project := ctx.Params("project")
+
+	revision, err := parseIfMatch(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	updates := make(map[string]interface{})
+	if err := ctx.BodyParser(&updates); err != nil || len(updates) == 0 {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid device updates"})
+		return
+	}
+	for _, field := range reservedDeviceFields {
+		if _, ok := updates[field]; ok {
+			ctx.Status(fiber.StatusBadRequest)
+			ctx.JSON(fiber.Map{"message": field + " can not be updated"})
+			return
+		}
+	}
+
+	if as.findProjectDevice(ctx, project, deviceID) == nil {
+		return
+	}
+
+	device, err := as.deviceStore.UpdateDevice(changeContext(ctx), project, deviceID, revision, time.Now(), updates)
+	if err != nil {
+		writeDeviceError(ctx, err)
 		return
 	}
 
+	ctx.Set(fiber.HeaderETag, deviceETag(device.Revision))
 	ctx.JSON(device)
 }
+
+// updateDeviceMetadata merge patches the operator managed metadata, only at the If-Match revision when given
+func (as *ApiServer) updateDeviceMetadata(ctx *fiber.Ctx) {
+	deviceID := ctx.Params("deviceID")
+	project := ctx.Params("project")
+
+	revision, err := parseIfMatch(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	patch := &devices.MetadataPatch{}
+	if err := ctx.BodyParser(patch); err != nil {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid device metadata"})
+		return
+	}
+	if err := patch.Validate(); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	device, err := as.deviceStore.UpdateDeviceMetadata(ctx.Context(), project, deviceID, revision, patch)
+	if err != nil {
+		writeDeviceError(ctx, err)
+		return
+	}
+
+	if device == nil {
+		writeDeviceNotFound(ctx)
+		return
+	}
+
+	ctx.Set(fiber.HeaderETag, deviceETag(device.Revision))
+	ctx.JSON(device)
+}
+
+func (as *ApiServer) getDevices(ctx *fiber.Ctx) {
+	list, err := as.deviceStore.ListDevices(ctx.Context())
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if ctx.Query("unassigned") == "true" {
+		unassigned := make([]*devices.Device, 0)
+		for _, device := range list {
+			if device.ProjectID == "" {
+				unassigned = append(unassigned, device)
+			}
+		}
+		list = unassigned
+	}
+
+	ctx.JSON(list)
+}
+
+func (as *ApiServer) deleteDevice(ctx *fiber.Ctx) {
+	deviceID := ctx.Params("deviceID")
+	project := ctx.Params("project")
+
+	device := as.findProjectDevice(ctx, project, deviceID)
+	if device == nil || !matchRevision(ctx, device) {
+		return
+	}
+
+	err := as.deviceStore.DeleteDevice(changeContext(ctx), project, deviceID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(fiber.Map{"message": "deleted"})
+}
+
+// findProjectDevice loads a device of the project, writing the error response when missing
+func (as *ApiServer) findProjectDevice(ctx *fiber.Ctx, project, deviceID string) *devices.Device {
+	device, err := as.deviceStore.GetDeviceByID(ctx.Context(), project, deviceID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return nil
+	}
+
+	if device == nil {
+		writeDeviceNotFound(ctx)
+		return nil
+	}
+
+	return device
+}
+
+// writeDeviceNotFound answers missing devices with 404. Conditional requests get 412 instead,
+// no If-Match matches a missing device, * included
+func writeDeviceNotFound(ctx *fiber.Ctx) {
+	if ctx.Get(fiber.HeaderIfMatch) != "" {
+		ctx.Status(fiber.StatusPreconditionFailed)
+	} else {
+		ctx.Status(fiber.StatusNotFound)
+	}
+	ctx.JSON(fiber.Map{"message": "not found"})
+}
+
+// getDeviceChanges lists the change log of a device within the project, over the last week by default
+func (as *ApiServer) getDeviceChanges(ctx *fiber.Ctx) {
+	deviceID := ctx.Params("deviceID")
+	project := ctx.Params("project")
+
+	start, end, err := parseTimeRange(ctx, defaultHistoryWindow)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	// Entries outlive the device and its moves to other projects
+	changes, err := as.deviceStore.ListDeviceChanges(ctx.Context(), project, deviceID, start, end)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(changes)
+}
+
+// changeContext tags device mutations made by the request with the api source,
+// qualified with the principal like api:admin when authenticated
+func changeContext(ctx *fiber.Ctx) context.Context {
+	source := devices.SourceAPI
+	if p, _ := ctx.Locals(principalLocal).(*principal); p != nil {
+		source += ":" + p.identity()
+	}
+	return devices.WithSource(ctx.Context(), source)
+}
+
+// matchRevision checks If-Match against a device just read, writing the error response on mismatch.
+// Stores without conditional variants of an operation rely on it, which leaves a small race window
+func matchRevision(ctx *fiber.Ctx, device *devices.Device) bool {
+	revision, err := parseIfMatch(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return false
+	}
+
+	if revision != devices.AnyRevision && revision != devices.ExistingRevision && revision != device.Revision {
+		writeDeviceError(ctx, &devices.ConflictError{
+			DeviceID: device.ID,
+			Expected: revision,
+			Current:  device.Revision,
+		})
+		return false
+	}
+	return true
+}
+
+// writeDeviceError answers revision conflicts with 412 and the current revision,
+// moves onto an existing device with 409
+func writeDeviceError(ctx *fiber.Ctx, err error) {
+	if err == devices.ErrDeviceExists {
+		ctx.Status(fiber.StatusConflict)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+	if conflict, ok := err.(*devices.ConflictError); ok && conflict.Expected == devices.ExistingRevision {
+		writeDeviceNotFound(ctx)
+		return
+	}
+	if conflict, ok := err.(*devices.ConflictError); ok {
+		ctx.Set(fiber.HeaderETag, deviceETag(conflict.Current))
+		ctx.Status(fiber.StatusPreconditionFailed)
+		ctx.JSON(fiber.Map{"message": err.Error(), "revision": conflict.Current})
+		return
+	}
+
+	ctx.Status(fiber.StatusBadRequest)
+	ctx.JSON(fiber.Map{"message": err.Error()})
+}
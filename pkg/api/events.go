@@ -0,0 +1,15 @@
+package api
+
+import (
+	"com.aviebrantz.coap-demo/pkg/core/events"
+	"github.com/apex/log"
+	"github.com/gofiber/fiber"
+)
+
+// publishEvent notifies the platform about a change made through the API, failures are only logged
+func (as *ApiServer) publishEvent(ctx *fiber.Ctx, eventType, projectID, deviceID string, data interface{}) {
+	err := events.Publish(ctx.Context(), as.eventsTopic, eventType, projectID, deviceID, data)
+	if err != nil {
+		log.WithField("module", "api").Errorf("err publishing %s event: %v", eventType, err)
+	}
+}
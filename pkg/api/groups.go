@@ -0,0 +1,326 @@
+package api
+
+import (
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store/groups"
+	"com.aviebrantz.coap-demo/pkg/core/store/historical"
+	"github.com/gofiber/fiber"
+	"github.com/google/uuid"
+)
+
+type groupRequest struct {
+	Name        string   `json:"name" form:"name"`
+	Description string   `json:"description" form:"description"`
+	ParentID    string   `json:"parentID" form:"parentID"`
+	DeviceIDs   []string `json:"deviceIDs" form:"deviceIDs"`
+	Selector    string   `json:"selector" form:"selector"`
+}
+
+func (req *groupRequest) apply(group *groups.Group) {
+	group.Name = req.Name
+	group.Description = req.Description
+	group.ParentID = req.ParentID
+	group.DeviceIDs = req.DeviceIDs
+	if group.DeviceIDs == nil {
+		group.DeviceIDs = make([]string, 0)
+	}
+	group.Selector = req.Selector
+	group.Updated = time.Now()
+}
+
+func (as *ApiServer) createGroup(ctx *fiber.Ctx) {
+	c := ctx.Context()
+	project := ctx.Params("project")
+
+	req := &groupRequest{}
+	if err := ctx.BodyParser(req); err != nil {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid group"})
+		return
+	}
+
+	group := &groups.Group{
+		ID:        uuid.New().String(),
+		ProjectID: project,
+		Created:   time.Now(),
+	}
+	req.apply(group)
+
+	if !as.checkGroup(ctx, group) {
+		return
+	}
+
+	err := as.groupStore.CreateGroup(c, group)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(group)
+}
+
+func (as *ApiServer) getGroupsByProject(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	list, err := as.groupStore.ListGroupsForProject(ctx.Context(), project)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(list)
+}
+
+func (as *ApiServer) getGroupByProject(ctx *fiber.Ctx) {
+	group := as.findGroup(ctx, ctx.Params("project"), ctx.Params("groupID"))
+	if group == nil {
+		return
+	}
+
+	ctx.JSON(group)
+}
+
+func (as *ApiServer) updateGroup(ctx *fiber.Ctx) {
+	req := &groupRequest{}
+	if err := ctx.BodyParser(req); err != nil {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid group"})
+		return
+	}
+
+	group := as.findGroup(ctx, ctx.Params("project"), ctx.Params("groupID"))
+	if group == nil {
+		return
+	}
+	req.apply(group)
+
+	if !as.checkGroup(ctx, group) {
+		return
+	}
+
+	err := as.groupStore.UpdateGroup(ctx.Context(), group)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(group)
+}
+
+// deleteGroup refuses groups with subgroups, they would be left without a parent
+func (as *ApiServer) deleteGroup(ctx *fiber.Ctx) {
+	c := ctx.Context()
+	project := ctx.Params("project")
+	groupID := ctx.Params("groupID")
+
+	list, err := as.groupStore.ListGroupsForProject(c, project)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+	if len(groups.Subtree(list, groupID)) > 1 {
+		ctx.Status(fiber.StatusConflict)
+		ctx.JSON(fiber.Map{"message": "group has subgroups, delete or move them first"})
+		return
+	}
+
+	err = as.groupStore.DeleteGroup(c, project, groupID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(fiber.Map{"message": "deleted"})
+}
+
+// addGroupDevice makes a device of the project a static member of the group
+func (as *ApiServer) addGroupDevice(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	deviceID := ctx.Params("deviceID")
+
+	group := as.findGroup(ctx, project, ctx.Params("groupID"))
+	if group == nil || as.findProjectDevice(ctx, project, deviceID) == nil {
+		return
+	}
+
+	if !group.HasDevice(deviceID) {
+		group.DeviceIDs = append(group.DeviceIDs, deviceID)
+		group.Updated = time.Now()
+		err := as.groupStore.UpdateGroup(ctx.Context(), group)
+		if err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			ctx.JSON(fiber.Map{"message": err.Error()})
+			return
+		}
+	}
+
+	ctx.JSON(group)
+}
+
+// removeGroupDevice drops a static member, devices matching the selector stay members
+func (as *ApiServer) removeGroupDevice(ctx *fiber.Ctx) {
+	deviceID := ctx.Params("deviceID")
+
+	group := as.findGroup(ctx, ctx.Params("project"), ctx.Params("groupID"))
+	if group == nil {
+		return
+	}
+
+	if !group.HasDevice(deviceID) {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "not found"})
+		return
+	}
+
+	ids := make([]string, 0, len(group.DeviceIDs))
+	for _, id := range group.DeviceIDs {
+		if id != deviceID {
+			ids = append(ids, id)
+		}
+	}
+	group.DeviceIDs = ids
+	group.Updated = time.Now()
+
+	err := as.groupStore.UpdateGroup(ctx.Context(), group)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(group)
+}
+
+// getGroupDevices lists the members of the group and of its subgroups,
+// only the direct members with recursive=false
+func (as *ApiServer) getGroupDevices(ctx *fiber.Ctx) {
+	members := as.groupMembers(ctx, ctx.Params("project"), ctx.Params("groupID"))
+	if members == nil {
+		return
+	}
+
+	ctx.JSON(members)
+}
+
+// getGroupHistory aggregates the history of every member of the group as a single series,
+// with the step, agg and fields of the device history
+func (as *ApiServer) getGroupHistory(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+
+	start, end, err := parseTimeRange(ctx, defaultHistoryWindow)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+	query, err := parseAggregationQuery(ctx, start, end)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	members := as.groupMembers(ctx, project, ctx.Params("groupID"))
+	if members == nil {
+		return
+	}
+
+	ids := make([]string, len(members))
+	for i, device := range members {
+		ids[i] = device.ID
+	}
+
+	points, err := historical.AggregateSeries(ctx.Context(), as.timeseriesStore, project, "device", ids, query)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(points)
+}
+
+// groupMembers resolves the members of a group, writing the error response on failure
+func (as *ApiServer) groupMembers(ctx *fiber.Ctx, project, groupID string) []*devices.Device {
+	c := ctx.Context()
+	list, err := as.groupStore.ListGroupsForProject(c, project)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return nil
+	}
+
+	tree := groups.Subtree(list, groupID)
+	if len(tree) == 0 {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "not found"})
+		return nil
+	}
+	if ctx.Query("recursive") == "false" {
+		tree = tree[:1]
+	}
+
+	projectDevices, err := as.deviceStore.ListDevicesForProject(c, project)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return nil
+	}
+
+	members, err := groups.Members(tree, projectDevices)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return nil
+	}
+	return members
+}
+
+// checkGroup validates a group and its parent, writing the error response when invalid
+func (as *ApiServer) checkGroup(ctx *fiber.Ctx, group *groups.Group) bool {
+	if err := group.Validate(); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return false
+	}
+
+	list, err := as.groupStore.ListGroupsForProject(ctx.Context(), group.ProjectID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return false
+	}
+
+	if err := groups.CheckParent(list, group.ID, group.ParentID); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return false
+	}
+	return true
+}
+
+// findGroup loads a group of the project, writing the error response when missing
+func (as *ApiServer) findGroup(ctx *fiber.Ctx, project, groupID string) *groups.Group {
+	group, err := as.groupStore.GetGroupByID(ctx.Context(), project, groupID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return nil
+	}
+
+	if group == nil {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "not found"})
+		return nil
+	}
+
+	return group
+}
@@ -1,19 +1,37 @@
 package api
 
 import (
+	"strings"
 	"time"
 
+	"com.aviebrantz.coap-demo/pkg/core/store/historical"
 	"github.com/gofiber/fiber"
 )
 
+const defaultHistoryWindow = time.Hour * 24 * 7
+
+// getDeviceHistory returns raw points, and the finest rollups kept for the parts of the range
+// compacted by the project retention policy. Any of step, agg or fields switches to aggregation,
+// which is how clients downsample long ranges
 func (as *ApiServer) getDeviceHistory(ctx *fiber.Ctx) {
 
 	deviceID := ctx.Params("deviceID")
-	end := time.Now()
-	start := end.Add(time.Hour * 24 * 7 * -1)
+	project := ctx.Params("project")
+	start, end, err := parseTimeRange(ctx, defaultHistoryWindow)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if ctx.Query("step") != "" || ctx.Query("agg") != "" || ctx.Query("fields") != "" {
+		as.aggregateDeviceHistory(ctx, project, deviceID, start, end)
+		return
+	}
 
 	points, err := as.timeseriesStore.GetDataPointsInRange(
 		ctx.Context(),
+		project,
 		"device",
 		deviceID,
 		start,
@@ -28,3 +46,111 @@ func (as *ApiServer) getDeviceHistory(ctx *fiber.Ctx) {
 
 	ctx.JSON(points)
 }
+
+// aggregateDeviceHistory buckets the range by step, one bucket for the whole range by default.
+// agg is a comma separated list of functions, mean by default
+func (as *ApiServer) aggregateDeviceHistory(ctx *fiber.Ctx, project, deviceID string, start, end time.Time) {
+	query, err := parseAggregationQuery(ctx, start, end)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	points, err := as.timeseriesStore.AggregateDataPoints(ctx.Context(), project, "device", deviceID, query)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(points)
+}
+
+// parseAggregationQuery reads step, agg and fields, defaulting to the mean over the whole range
+func parseAggregationQuery(ctx *fiber.Ctx, start, end time.Time) (historical.AggregationQuery, error) {
+	query := historical.AggregationQuery{
+		Start:     start,
+		End:       end,
+		Step:      end.Sub(start),
+		Functions: []string{historical.AggMean},
+	}
+
+	if value := ctx.Query("step"); value != "" {
+		step, err := time.ParseDuration(value)
+		if err != nil {
+			return query, err
+		}
+		query.Step = step
+	}
+	if value := ctx.Query("agg"); value != "" {
+		query.Functions = strings.Split(value, ",")
+	}
+	if value := ctx.Query("fields"); value != "" {
+		query.Fields = strings.Split(value, ",")
+	}
+	return query, nil
+}
+
+// getProjectHistory returns the series of the given fields for devices of the project,
+// all of them unless devices lists some
+func (as *ApiServer) getProjectHistory(ctx *fiber.Ctx) {
+	c := ctx.Context()
+	project := ctx.Params("project")
+
+	fields := ctx.Query("fields")
+	if fields == "" {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": "Missing fields"})
+		return
+	}
+
+	start, end, err := parseTimeRange(ctx, defaultHistoryWindow)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	list, err := as.deviceStore.ListDevicesForProject(c, project)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	members := make(map[string]bool, len(list))
+	for _, device := range list {
+		members[device.ID] = true
+	}
+
+	ids := make([]string, 0, len(list))
+	if value := ctx.Query("devices"); value != "" {
+		for _, id := range strings.Split(value, ",") {
+			if !members[id] {
+				ctx.Status(fiber.StatusNotFound)
+				ctx.JSON(fiber.Map{"message": "device " + id + " not found"})
+				return
+			}
+			ids = append(ids, id)
+		}
+	} else {
+		for _, device := range list {
+			ids = append(ids, device.ID)
+		}
+	}
+
+	series, err := historical.QueryFieldSeries(c, as.timeseriesStore, project, "device", historical.FieldQuery{
+		IDs:    ids,
+		Fields: strings.Split(fields, ","),
+		Start:  start,
+		End:    end,
+	})
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(series)
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"com.aviebrantz.coap-demo/pkg/core/store/apikeys"
+	"github.com/gofiber/fiber"
+)
+
+type createKeyRequest struct {
+	Name   string   `json:"name" form:"name"`
+	Scopes []string `json:"scopes" form:"scopes"`
+}
+
+// createKey issues an API key for the project, its token is only part of this response
+func (as *ApiServer) createKey(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	if !as.credentialsAllowed(ctx) {
+		return
+	}
+
+	req := &createKeyRequest{}
+	if err := ctx.BodyParser(req); err != nil || req.Name == "" {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Missing key name"})
+		return
+	}
+
+	if as.findProject(ctx, project) == nil {
+		return
+	}
+
+	key, token, err := apikeys.NewKey(project, req.Name, req.Scopes)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	err = as.apiKeyStore.CreateKey(ctx.Context(), key)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(fiber.Map{
+		"key":   key.Redacted(),
+		"token": token,
+	})
+}
+
+func (as *ApiServer) getKeysByProject(ctx *fiber.Ctx) {
+	list, err := as.apiKeyStore.ListKeysForProject(ctx.Context(), ctx.Params("project"))
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	keys := make([]*apikeys.APIKey, len(list))
+	for i, key := range list {
+		keys[i] = key.Redacted()
+	}
+	ctx.JSON(keys)
+}
+
+// deleteKey revokes an API key of the project
+func (as *ApiServer) deleteKey(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	keyID := ctx.Params("keyID")
+
+	key, err := as.apiKeyStore.GetKeyByID(ctx.Context(), keyID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+	if key == nil || key.ProjectID != project {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "not found"})
+		return
+	}
+
+	err = as.apiKeyStore.DeleteKey(ctx.Context(), project, keyID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(fiber.Map{"message": "deleted"})
+}
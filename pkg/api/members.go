@@ -0,0 +1,227 @@
+package api
+
+import (
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/users"
+	"github.com/gofiber/fiber"
+)
+
+type inviteMemberRequest struct {
+	Email string `json:"email" form:"email"`
+	Role  string `json:"role" form:"role"`
+}
+
+type updateMemberRequest struct {
+	Role string `json:"role" form:"role"`
+}
+
+func (as *ApiServer) getMembersByProject(ctx *fiber.Ctx) {
+	list, err := as.userStore.ListMembersForProject(ctx.Context(), ctx.Params("project"))
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(list)
+}
+
+// inviteMember gives a user a role in the project. Users without an account are created with an
+// invite, its token is only part of this response and is accepted through /auth/accept.
+// Users who haven't accepted their invite yet get a new one, replacing the token they were given,
+// so members can be invited again when their invite is lost or expired
+func (as *ApiServer) inviteMember(ctx *fiber.Ctx) {
+	c := ctx.Context()
+	project := ctx.Params("project")
+	if !as.credentialsAllowed(ctx) {
+		return
+	}
+
+	req := &inviteMemberRequest{}
+	if err := ctx.BodyParser(req); err != nil {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid member"})
+		return
+	}
+	email, err := users.NormalizeEmail(req.Email)
+	if err == nil {
+		err = users.ValidateRole(req.Role)
+	}
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if as.findProject(ctx, project) == nil {
+		return
+	}
+
+	member, err := as.userStore.GetMember(c, project, email)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	user, err := as.userStore.GetUserByEmail(c, email)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	signedUp := user != nil && user.PasswordHash != ""
+	if member != nil && signedUp {
+		ctx.Status(fiber.StatusConflict)
+		ctx.JSON(fiber.Map{"message": "already a member of the project"})
+		return
+	}
+
+	inviteToken := ""
+	switch {
+	case user == nil:
+		user = &users.User{Email: email, Created: time.Now()}
+		inviteToken, err = user.Invite()
+		if err == nil {
+			err = as.userStore.CreateUser(c, user)
+		}
+		if err == users.ErrUserExists {
+			ctx.Status(fiber.StatusConflict)
+			ctx.JSON(fiber.Map{"message": "user was invited concurrently, retry"})
+			return
+		}
+	case !signedUp:
+		// Reissuing rotates the invite, the previous token can no longer be accepted
+		inviteToken, err = user.Invite()
+		if err == nil {
+			err = as.userStore.UpdateUser(c, user)
+		}
+	}
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	// Members invited again keep their role, updateMember changes it
+	if member == nil {
+		member = users.NewMember(project, email, req.Role)
+		err = as.userStore.PutMember(c, member)
+		if err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			ctx.JSON(fiber.Map{"message": err.Error()})
+			return
+		}
+	}
+
+	response := fiber.Map{"member": member}
+	if inviteToken != "" {
+		response["inviteToken"] = inviteToken
+	}
+	ctx.JSON(response)
+}
+
+// updateMember changes the role of a member, the last owner of the project can't be demoted
+func (as *ApiServer) updateMember(ctx *fiber.Ctx) {
+	req := &updateMemberRequest{}
+	if err := ctx.BodyParser(req); err != nil {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid member"})
+		return
+	}
+	if err := users.ValidateRole(req.Role); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	member := as.findMember(ctx, ctx.Params("project"), ctx.Params("email"))
+	if member == nil {
+		return
+	}
+	if req.Role != users.RoleOwner && as.isLastOwner(ctx, member) {
+		return
+	}
+
+	member.Role = req.Role
+	member.Updated = time.Now()
+	err := as.userStore.PutMember(ctx.Context(), member)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(member)
+}
+
+// removeMember revokes the access of a user to the project, the last owner can't be removed
+func (as *ApiServer) removeMember(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+
+	member := as.findMember(ctx, project, ctx.Params("email"))
+	if member == nil || as.isLastOwner(ctx, member) {
+		return
+	}
+
+	err := as.userStore.DeleteMember(ctx.Context(), project, member.Email)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(fiber.Map{"message": "removed"})
+}
+
+// isLastOwner tells if the member is the only owner of its project, writing the error response when it is
+func (as *ApiServer) isLastOwner(ctx *fiber.Ctx, member *users.Member) bool {
+	if member.Role != users.RoleOwner {
+		return false
+	}
+
+	list, err := as.userStore.ListMembersForProject(ctx.Context(), member.ProjectID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return true
+	}
+	for _, other := range list {
+		if other.Role == users.RoleOwner && other.Email != member.Email {
+			return false
+		}
+	}
+
+	ctx.Status(fiber.StatusConflict)
+	ctx.JSON(fiber.Map{"message": "the project must keep an owner"})
+	return true
+}
+
+// findMember loads a member of the project, writing the error response when missing
+func (as *ApiServer) findMember(ctx *fiber.Ctx, project, email string) *users.Member {
+	email, err := users.NormalizeEmail(email)
+	if err != nil {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "not found"})
+		return nil
+	}
+
+	member, err := as.userStore.GetMember(ctx.Context(), project, email)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return nil
+	}
+
+	if member == nil {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "not found"})
+		return nil
+	}
+
+	return member
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"github.com/gofiber/fiber"
+)
+
+const (
+	defaultDevicesLimit = 100
+	maxDevicesLimit     = 1000
+)
+
+// parseTimeRange reads the RFC3339 start and end query parameters.
+// A missing end is now, a missing start goes back by the default window, zero meaning unbounded.
+func parseTimeRange(ctx *fiber.Ctx, defaultWindow time.Duration) (time.Time, time.Time, error) {
+	var start, end time.Time
+	var err error
+
+	end = time.Now()
+	if value := ctx.Query("end"); value != "" {
+		end, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return start, end, err
+		}
+	}
+
+	if defaultWindow > 0 {
+		start = end.Add(-defaultWindow)
+	}
+	if value := ctx.Query("start"); value != "" {
+		start, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return start, end, err
+		}
+	}
+
+	return start, end, nil
+}
+
+// parseDeviceQuery reads the device listing parameters: repeated filter=<path><op><value>,
+// sort=<key> with a leading - for descending order, limit, cursor and comma separated fields
+func parseDeviceQuery(ctx *fiber.Ctx) (devices.DeviceQuery, error) {
+	query := devices.DeviceQuery{
+		Limit:  defaultDevicesLimit,
+		Cursor: ctx.Query("cursor"),
+	}
+
+	for _, value := range ctx.Fasthttp.QueryArgs().PeekMulti("filter") {
+		filter, err := devices.ParseFilter(string(value))
+		if err != nil {
+			return query, err
+		}
+		query.Filters = append(query.Filters, filter)
+	}
+
+	if value := ctx.Query("labels"); value != "" {
+		selector, err := devices.ParseSelector(value)
+		if err != nil {
+			return query, err
+		}
+		query.Labels = selector
+	}
+
+	if value := ctx.Query("sort"); value != "" {
+		query.Descending = strings.HasPrefix(value, "-")
+		query.SortBy = strings.TrimPrefix(value, "-")
+	}
+
+	if value := ctx.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return query, err
+		}
+		if limit <= 0 || limit > maxDevicesLimit {
+			return query, fmt.Errorf("limit must be between 1 and %d", maxDevicesLimit)
+		}
+		query.Limit = limit
+	}
+
+	if value := ctx.Query("fields"); value != "" {
+		query.Fields = strings.Split(value, ",")
+	}
+
+	return query, query.Validate()
+}
+
+// deviceETag renders a device revision as a strong entity tag
+func deviceETag(revision int64) string {
+	return `"` + strconv.FormatInt(revision, 10) + `"`
+}
+
+// parseIfMatch reads the revision expected by a conditional request, AnyRevision without If-Match.
+// * matches any revision of an existing device
+func parseIfMatch(ctx *fiber.Ctx) (int64, error) {
+	value := strings.TrimSpace(ctx.Get(fiber.HeaderIfMatch))
+	if value == "" {
+		return devices.AnyRevision, nil
+	}
+	if value == "*" {
+		return devices.ExistingRevision, nil
+	}
+
+	value = strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || revision < 0 {
+		return 0, fmt.Errorf("invalid If-Match %q, expected a device revision", value)
+	}
+	return revision, nil
+}
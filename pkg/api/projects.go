@@ -1,6 +1,14 @@
 package api
 
 import (
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/events"
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store/projects"
+	"com.aviebrantz.coap-demo/pkg/core/store/templates"
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
+	"com.aviebrantz.coap-demo/pkg/core/store/users"
 	"github.com/gofiber/fiber"
 )
 
@@ -8,6 +16,26 @@ type createProjectRequest struct {
 	Name string `json:"name" form:"name"`
 }
 
+type updateProjectRequest struct {
+	Name string `json:"name" form:"name"`
+}
+
+type registerDeviceRequest struct {
+	TemplateID string `json:"templateID" form:"templateID"`
+}
+
+type moveDeviceRequest struct {
+	ProjectID string `json:"projectID" form:"projectID"`
+}
+
+// reservedProjectNames collide with top level routes
+var reservedProjectNames = map[string]bool{
+	"project": true,
+	"devices": true,
+	"auth":    true,
+	"backup":  true,
+}
+
 func (as *ApiServer) createProject(ctx *fiber.Ctx) {
 
 	req := &createProjectRequest{}
@@ -18,7 +46,19 @@ func (as *ApiServer) createProject(ctx *fiber.Ctx) {
 		return
 	}
 
+	if tenancy.ValidateID(req.Name) != nil || reservedProjectNames[req.Name] {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid project name"})
+		return
+	}
+
 	err := as.projectStore.CreateProject(ctx.Context(), req.Name)
+	if err == projects.ErrProjectExists {
+		ctx.Status(fiber.StatusConflict)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
 	if err != nil {
 		ctx.Status(fiber.StatusBadRequest)
 		ctx.JSON(fiber.Map{"message": err.Error()})
@@ -35,17 +75,327 @@ func (as *ApiServer) createProject(ctx *fiber.Ctx) {
 	ctx.JSON(project)
 }
 
+func (as *ApiServer) getProjects(ctx *fiber.Ctx) {
+	list, err := as.projectStore.ListProjects(ctx.Context())
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(list)
+}
+
+func (as *ApiServer) getProject(ctx *fiber.Ctx) {
+	project := as.findProject(ctx, ctx.Params("project"))
+	if project == nil {
+		return
+	}
+
+	ctx.JSON(project)
+}
+
+func (as *ApiServer) updateProject(ctx *fiber.Ctx) {
+	id := ctx.Params("project")
+
+	req := &updateProjectRequest{}
+	if err := ctx.BodyParser(req); err != nil || req.Name == "" {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Missing project name"})
+		return
+	}
+
+	if as.findProject(ctx, id) == nil {
+		return
+	}
+
+	err := as.projectStore.UpdateProject(ctx.Context(), id, map[string]interface{}{
+		"name": req.Name,
+	})
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	project := as.findProject(ctx, id)
+	if project == nil {
+		return
+	}
+
+	ctx.JSON(project)
+}
+
+// deleteProject refuses to delete projects with devices, unless cascade=true is given.
+// Every resource of the project is deleted with it, so a project created later with the same
+// ID starts empty: devices with their change log, history, rules, alerts, alarms, webhooks,
+// groups, templates, API keys and members.
+func (as *ApiServer) deleteProject(ctx *fiber.Ctx) {
+	c := ctx.Context()
+	id := ctx.Params("project")
+	cascade := ctx.Query("cascade") == "true"
+
+	if as.findProject(ctx, id) == nil {
+		return
+	}
+
+	list, err := as.deviceStore.ListDevicesForProject(c, id)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if len(list) > 0 && !cascade {
+		ctx.Status(fiber.StatusConflict)
+		ctx.JSON(fiber.Map{"message": "project has devices, use cascade=true to delete them"})
+		return
+	}
+
+	err = as.deleteProjectResources(ctx, id, list)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	err = as.projectStore.DeleteProject(c, id)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(fiber.Map{"message": "deleted"})
+}
+
+func (as *ApiServer) deleteProjectResources(ctx *fiber.Ctx, id string, list []*devices.Device) error {
+	c := ctx.Context()
+	for _, device := range list {
+		err := as.deviceStore.DeleteDevice(changeContext(ctx), id, device.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := as.deviceStore.DeleteChangesForProject(c, id)
+	if err != nil {
+		return err
+	}
+
+	err = as.timeseriesStore.DeleteSeriesForProject(c, id)
+	if err != nil {
+		return err
+	}
+
+	projectRules, err := as.ruleStore.ListRulesForProject(c, id)
+	if err != nil {
+		return err
+	}
+	for _, rule := range projectRules {
+		err = as.ruleStore.DeleteRule(c, id, rule.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = as.ruleStore.DeleteAlertsForProject(c, id)
+	if err != nil {
+		return err
+	}
+
+	err = as.alarmStore.DeleteAlarmsForProject(c, id)
+	if err != nil {
+		return err
+	}
+
+	projectWebhooks, err := as.webhookStore.ListWebhooksForProject(c, id)
+	if err != nil {
+		return err
+	}
+	for _, webhook := range projectWebhooks {
+		err = as.webhookStore.DeleteWebhook(c, id, webhook.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	projectGroups, err := as.groupStore.ListGroupsForProject(c, id)
+	if err != nil {
+		return err
+	}
+	for _, group := range projectGroups {
+		err = as.groupStore.DeleteGroup(c, id, group.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	projectTemplates, err := as.templateStore.ListTemplatesForProject(c, id)
+	if err != nil {
+		return err
+	}
+	for _, template := range projectTemplates {
+		err = as.templateStore.DeleteTemplate(c, id, template.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	projectKeys, err := as.apiKeyStore.ListKeysForProject(c, id)
+	if err != nil {
+		return err
+	}
+	for _, key := range projectKeys {
+		err = as.apiKeyStore.DeleteKey(c, id, key.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	projectMembers, err := as.userStore.ListMembersForProject(c, id)
+	if err != nil {
+		return err
+	}
+	for _, member := range projectMembers {
+		err = as.userStore.DeleteMember(c, id, member.Email)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findProject loads a project, writing the error response when missing
+func (as *ApiServer) findProject(ctx *fiber.Ctx, id string) *projects.Project {
+	project, err := as.projectStore.GetProjectByID(ctx.Context(), id)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return nil
+	}
+
+	if project == nil {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "not found"})
+		return nil
+	}
+
+	return project
+}
+
+// registerDeviceOnProject claims an unassigned device, or creates it in the project when it never reported.
+// The body may assign the device a template of the project, its default config is published then
 func (as *ApiServer) registerDeviceOnProject(ctx *fiber.Ctx) {
 	deviceID := ctx.Params("deviceID")
 	project := ctx.Params("project")
 
-	err := as.deviceStore.RegisterDeviceToProject(ctx.Context(), deviceID, project)
+	req := &registerDeviceRequest{}
+	if len(ctx.Body()) > 0 {
+		if err := ctx.BodyParser(req); err != nil {
+			ctx.
+				Status(fiber.StatusBadRequest).
+				JSON(fiber.Map{"message": "Invalid device registration"})
+			return
+		}
+	}
+
+	if as.findProject(ctx, project) == nil {
+		return
+	}
+	var template *templates.Template
+	if req.TemplateID != "" {
+		template = as.findTemplate(ctx, project, req.TemplateID)
+		if template == nil {
+			return
+		}
+	}
 
+	device, err := as.deviceStore.MoveDevice(changeContext(ctx), tenancy.Unassigned, deviceID, project, devices.AnyRevision)
+	if err == nil && device == nil {
+		err = as.deviceStore.UpsertDevice(changeContext(ctx), project, deviceID, time.Now(), make(map[string]interface{}))
+	}
+	if err == nil && req.TemplateID != "" {
+		_, err = as.deviceStore.UpdateDevice(changeContext(ctx), project, deviceID, devices.AnyRevision, time.Now(), map[string]interface{}{
+			devices.TemplateField: req.TemplateID,
+		})
+	}
+	if err != nil {
+		writeDeviceError(ctx, err)
+		return
+	}
+
+	as.publishEvent(ctx, events.TypeDeviceRegistered, project, deviceID, fiber.Map{
+		"deviceID":  deviceID,
+		"projectID": project,
+	})
+	as.publishDefaultConfig(ctx, project, deviceID, template)
+	ctx.JSON(fiber.Map{"message": "associated"})
+}
+
+func (as *ApiServer) moveDeviceToProject(ctx *fiber.Ctx) {
+	deviceID := ctx.Params("deviceID")
+	project := ctx.Params("project")
+
+	req := &moveDeviceRequest{}
+	if err := ctx.BodyParser(req); err != nil || req.ProjectID == "" {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Missing target projectID"})
+		return
+	}
+
+	revision, err := parseIfMatch(ctx)
 	if err != nil {
 		ctx.Status(fiber.StatusBadRequest)
 		ctx.JSON(fiber.Map{"message": err.Error()})
 		return
 	}
 
-	ctx.JSON(fiber.Map{"message": "associated"})
+	if !as.permittedIn(ctx, req.ProjectID, users.PermissionWrite) || as.findProject(ctx, req.ProjectID) == nil {
+		return
+	}
+
+	device, err := as.deviceStore.MoveDevice(changeContext(ctx), project, deviceID, req.ProjectID, revision)
+	if err != nil {
+		writeDeviceError(ctx, err)
+		return
+	}
+	if device == nil {
+		writeDeviceNotFound(ctx)
+		return
+	}
+
+	as.publishEvent(ctx, events.TypeDeviceRegistered, req.ProjectID, deviceID, fiber.Map{
+		"deviceID":  deviceID,
+		"projectID": req.ProjectID,
+	})
+	ctx.Set(fiber.HeaderETag, deviceETag(device.Revision))
+	ctx.JSON(fiber.Map{"message": "moved"})
+}
+
+// unregisterDeviceFromProject moves the device back to the unassigned namespace
+func (as *ApiServer) unregisterDeviceFromProject(ctx *fiber.Ctx) {
+	deviceID := ctx.Params("deviceID")
+	project := ctx.Params("project")
+
+	revision, err := parseIfMatch(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	device, err := as.deviceStore.MoveDevice(changeContext(ctx), project, deviceID, tenancy.Unassigned, revision)
+	if err != nil {
+		writeDeviceError(ctx, err)
+		return
+	}
+	if device == nil {
+		writeDeviceNotFound(ctx)
+		return
+	}
+
+	ctx.JSON(fiber.Map{"message": "unregistered"})
 }
@@ -0,0 +1,64 @@
+package api
+
+import (
+	"com.aviebrantz.coap-demo/pkg/core/store/historical"
+	"com.aviebrantz.coap-demo/pkg/core/store/projects"
+	"github.com/gofiber/fiber"
+)
+
+func (as *ApiServer) getRetention(ctx *fiber.Ctx) {
+	project := as.findProject(ctx, ctx.Params("project"))
+	if project == nil {
+		return
+	}
+
+	policy, err := project.Retention()
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if policy == nil {
+		policy = &projects.RetentionPolicy{}
+	}
+	ctx.JSON(policy)
+}
+
+// setRetention stores the retention policy of the project, only time series stores able
+// to downsample their history apply it
+func (as *ApiServer) setRetention(ctx *fiber.Ctx) {
+	if _, ok := as.timeseriesStore.(historical.RollupStore); !ok {
+		ctx.Status(fiber.StatusNotImplemented)
+		ctx.JSON(fiber.Map{"message": "retention policies are not supported by the time series storage"})
+		return
+	}
+
+	id := ctx.Params("project")
+
+	policy := &projects.RetentionPolicy{}
+	if err := ctx.BodyParser(policy); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if err := policy.Validate(); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if as.findProject(ctx, id) == nil {
+		return
+	}
+
+	err := as.projectStore.UpdateProject(ctx.Context(), id, policy.ToMap())
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(policy)
+}
@@ -0,0 +1,186 @@
+package api
+
+import (
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/rules"
+	"com.aviebrantz.coap-demo/pkg/rules/engine"
+	"github.com/gofiber/fiber"
+	"github.com/google/uuid"
+)
+
+type ruleRequest struct {
+	Name            string `json:"name" form:"name"`
+	Expression      string `json:"expression" form:"expression"`
+	For             string `json:"for" form:"for"`
+	ClearExpression string `json:"clearExpression" form:"clearExpression"`
+	ClearFor        string `json:"clearFor" form:"clearFor"`
+	Severity        string `json:"severity" form:"severity"`
+	Enabled         *bool  `json:"enabled" form:"enabled"`
+}
+
+func (req *ruleRequest) apply(rule *rules.Rule) {
+	rule.Name = req.Name
+	rule.Expression = req.Expression
+	rule.For = req.For
+	rule.ClearExpression = req.ClearExpression
+	rule.ClearFor = req.ClearFor
+	rule.Severity = req.Severity
+	rule.Enabled = true
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	rule.Updated = time.Now()
+}
+
+func (as *ApiServer) createRule(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+
+	req := &ruleRequest{}
+	if err := ctx.BodyParser(req); err != nil {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid rule"})
+		return
+	}
+
+	rule := &rules.Rule{
+		ID:        uuid.New().String(),
+		ProjectID: project,
+		Created:   time.Now(),
+	}
+	req.apply(rule)
+
+	if err := engine.Validate(rule); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	err := as.ruleStore.CreateRule(ctx.Context(), rule)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(rule)
+}
+
+func (as *ApiServer) getRulesByProject(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	list, err := as.ruleStore.ListRulesForProject(ctx.Context(), project)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(list)
+}
+
+func (as *ApiServer) getRuleByProject(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	ruleID := ctx.Params("ruleID")
+
+	rule, err := as.ruleStore.GetRuleByID(ctx.Context(), project, ruleID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if rule == nil {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "not found"})
+		return
+	}
+
+	ctx.JSON(rule)
+}
+
+func (as *ApiServer) updateRule(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	ruleID := ctx.Params("ruleID")
+
+	req := &ruleRequest{}
+	if err := ctx.BodyParser(req); err != nil {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid rule"})
+		return
+	}
+
+	rule, err := as.ruleStore.GetRuleByID(ctx.Context(), project, ruleID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if rule == nil {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "not found"})
+		return
+	}
+
+	req.apply(rule)
+	if err := engine.Validate(rule); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	err = as.ruleStore.UpdateRule(ctx.Context(), rule)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(rule)
+}
+
+func (as *ApiServer) deleteRule(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	ruleID := ctx.Params("ruleID")
+
+	err := as.ruleStore.DeleteRule(ctx.Context(), project, ruleID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(fiber.Map{"message": "deleted"})
+}
+
+func (as *ApiServer) getAlerts(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+
+	query := rules.AlertQuery{
+		DeviceID: ctx.Query("deviceID"),
+		RuleID:   ctx.Query("ruleID"),
+		State:    ctx.Query("state"),
+	}
+	if deviceID := ctx.Params("deviceID"); deviceID != "" {
+		query.DeviceID = deviceID
+	}
+
+	var err error
+	query.Start, query.End, err = parseTimeRange(ctx, 0)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	list, err := as.ruleStore.ListAlerts(ctx.Context(), project, query)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(list)
+}
@@ -4,16 +4,35 @@ import (
 	"strconv"
 
 	"com.aviebrantz.coap-demo/pkg/config"
+	"com.aviebrantz.coap-demo/pkg/core/store/alarms"
+	"com.aviebrantz.coap-demo/pkg/core/store/apikeys"
+	"com.aviebrantz.coap-demo/pkg/core/store/backup"
 	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store/groups"
 	"com.aviebrantz.coap-demo/pkg/core/store/historical"
+	"com.aviebrantz.coap-demo/pkg/core/store/integrations"
 	"com.aviebrantz.coap-demo/pkg/core/store/projects"
+	"com.aviebrantz.coap-demo/pkg/core/store/rules"
+	"com.aviebrantz.coap-demo/pkg/core/store/templates"
+	"com.aviebrantz.coap-demo/pkg/core/store/users"
+	"github.com/apex/log"
 	"github.com/gofiber/fiber"
+	"gocloud.dev/pubsub"
 )
 
 type ApiServer struct {
 	deviceStore     devices.DeviceStore
 	projectStore    projects.ProjectStore
 	timeseriesStore historical.TimeSeriesStore
+	ruleStore       rules.RuleStore
+	alarmStore      alarms.AlarmStore
+	webhookStore    integrations.WebhookStore
+	groupStore      groups.GroupStore
+	templateStore   templates.TemplateStore
+	apiKeyStore     apikeys.APIKeyStore
+	userStore       users.UserStore
+	snapshotter     backup.Snapshotter
+	eventsTopic     *pubsub.Topic
 	config          config.APIServerConfig
 }
 
@@ -21,26 +40,139 @@ func NewServer(
 	deviceStore devices.DeviceStore,
 	projectStore projects.ProjectStore,
 	timeseriesStore historical.TimeSeriesStore,
+	ruleStore rules.RuleStore,
+	alarmStore alarms.AlarmStore,
+	webhookStore integrations.WebhookStore,
+	groupStore groups.GroupStore,
+	templateStore templates.TemplateStore,
+	apiKeyStore apikeys.APIKeyStore,
+	userStore users.UserStore,
+	snapshotter backup.Snapshotter,
+	eventsTopic *pubsub.Topic,
 	config config.APIServerConfig,
 ) *ApiServer {
 	return &ApiServer{
 		deviceStore:     deviceStore,
 		projectStore:    projectStore,
 		timeseriesStore: timeseriesStore,
+		ruleStore:       ruleStore,
+		alarmStore:      alarmStore,
+		webhookStore:    webhookStore,
+		groupStore:      groupStore,
+		templateStore:   templateStore,
+		apiKeyStore:     apiKeyStore,
+		userStore:       userStore,
+		snapshotter:     snapshotter,
+		eventsTopic:     eventsTopic,
 		config:          config,
 	}
 }
 
 func (as *ApiServer) Start() {
+	if !as.authEnabled() {
+		log.WithField("module", "api").Warn("No admin token configured, the API is not authenticated")
+	}
+	app := as.newApp()
+	app.Listen(":" + strconv.Itoa(as.config.Port))
+}
+
+func (as *ApiServer) newApp() *fiber.App {
 	app := fiber.New()
 
-	app.Post("/project", as.createProject)
-	app.Post("/:project/devices/:deviceID", as.registerDeviceOnProject)
+	// Top level routes go first, they would otherwise match /:project. They span every
+	// project, only the admin can call them
+	app.Post("/project", as.requireAdmin, as.createProject)
+	app.Get("/project", as.requireAdmin, as.getProjects)
+	app.Get("/devices", as.requireAdmin, as.getDevices)
+	app.Get("/backup/snapshot", as.requireAdmin, as.getSnapshot)
+	app.Get("/backup/export", as.requireAdmin, as.exportData)
+	app.Post("/backup/import", as.requireAdmin, as.importData)
+	app.Post("/auth/login", as.login)
+	app.Post("/auth/accept", as.acceptInvite)
+
+	// Every project route is reserved to the admin, the API keys of the project and its members,
+	// each route then requires a permission
+	app.Use("/:project", as.authorizeProject)
+	read := as.allow(users.PermissionRead)
+	readHistory := as.allow(users.PermissionReadHistory)
+	write := as.allow(users.PermissionWrite)
+	sendCommands := as.allow(users.PermissionSendCommands)
+	manage := as.allow(users.PermissionManage)
+
+	app.Get("/:project", read, as.getProject)
+	app.Patch("/:project", manage, as.updateProject)
+	app.Delete("/:project", as.requireAdmin, as.deleteProject)
+	app.Get("/:project/retention", read, as.getRetention)
+	app.Put("/:project/retention", manage, as.setRetention)
+
+	app.Post("/:project/keys", as.requireAdmin, as.createKey)
+	app.Get("/:project/keys", as.requireAdmin, as.getKeysByProject)
+	app.Delete("/:project/keys/:keyID", as.requireAdmin, as.deleteKey)
+
+	app.Get("/:project/members", read, as.getMembersByProject)
+	app.Post("/:project/members", manage, as.inviteMember)
+	app.Put("/:project/members/:email", manage, as.updateMember)
+	app.Delete("/:project/members/:email", manage, as.removeMember)
+
+	// Group routes go before the device ones, /:project/devices would match /:project/groups/:groupID/devices
+	app.Post("/:project/groups", write, as.createGroup)
+	app.Get("/:project/groups", read, as.getGroupsByProject)
+	app.Get("/:project/groups/:groupID", read, as.getGroupByProject)
+	app.Put("/:project/groups/:groupID", write, as.updateGroup)
+	app.Delete("/:project/groups/:groupID", write, as.deleteGroup)
+	app.Get("/:project/groups/:groupID/devices", read, as.getGroupDevices)
+	app.Put("/:project/groups/:groupID/devices/:deviceID", write, as.addGroupDevice)
+	app.Delete("/:project/groups/:groupID/devices/:deviceID", write, as.removeGroupDevice)
+	app.Get("/:project/groups/:groupID/history", readHistory, as.getGroupHistory)
+
+	app.Post("/:project/devices/:deviceID", write, as.registerDeviceOnProject)
+	app.Patch("/:project/devices/:deviceID", write, as.updateDevice)
+	app.Patch("/:project/devices/:deviceID/metadata", write, as.updateDeviceMetadata)
+	app.Delete("/:project/devices/:deviceID", write, as.deleteDevice)
+	app.Put("/:project/devices/:deviceID/project", write, as.moveDeviceToProject)
+	app.Delete("/:project/devices/:deviceID/project", write, as.unregisterDeviceFromProject)
+	app.Put("/:project/devices/:deviceID/parent", write, as.setDeviceParent)
+	app.Delete("/:project/devices/:deviceID/parent", write, as.removeDeviceParent)
+	app.Put("/:project/devices/:deviceID/template", write, as.setDeviceTemplate)
+	app.Delete("/:project/devices/:deviceID/template", write, as.removeDeviceTemplate)
+	app.Post("/:project/devices/:deviceID/commands/:command", sendCommands, as.sendCommand)
 	//app.Post("/:project/certificates", as.registerRootCert)
 
-	app.Get("/:project/devices", as.getDevicesByProject)
-	app.Get("/:project/devices/:deviceID", as.getDeviceByProject)
-	app.Get("/:project/devices/:deviceID/history", as.getDeviceHistory)
+	app.Get("/:project/devices", read, as.getDevicesByProject)
+	app.Get("/:project/devices/:deviceID", read, as.getDeviceByProject)
+	app.Get("/:project/devices/:deviceID/history", readHistory, as.getDeviceHistory)
+	app.Get("/:project/devices/:deviceID/alerts", read, as.getAlerts)
+	app.Get("/:project/devices/:deviceID/changes", readHistory, as.getDeviceChanges)
+	app.Get("/:project/devices/:deviceID/children", read, as.getDeviceChildren)
+	app.Get("/:project/devices/:deviceID/view", read, as.getDeviceView)
+	app.Get("/:project/history", readHistory, as.getProjectHistory)
 
-	app.Listen(":" + strconv.Itoa(as.config.Port))
+	app.Post("/:project/templates", write, as.createTemplate)
+	app.Post("/:project/templates/import", write, as.importTemplate)
+	app.Get("/:project/templates", read, as.getTemplatesByProject)
+	app.Get("/:project/templates/:templateID", read, as.getTemplateByProject)
+	app.Put("/:project/templates/:templateID", write, as.updateTemplate)
+	app.Delete("/:project/templates/:templateID", write, as.deleteTemplate)
+
+	app.Post("/:project/rules", write, as.createRule)
+	app.Get("/:project/rules", read, as.getRulesByProject)
+	app.Get("/:project/rules/:ruleID", read, as.getRuleByProject)
+	app.Put("/:project/rules/:ruleID", write, as.updateRule)
+	app.Delete("/:project/rules/:ruleID", write, as.deleteRule)
+	app.Get("/:project/alerts", read, as.getAlerts)
+
+	app.Get("/:project/devices/:deviceID/alarms", read, as.getAlarms)
+	app.Get("/:project/alarms", read, as.getAlarms)
+	app.Get("/:project/alarms/:alarmID", read, as.getAlarmByProject)
+	app.Post("/:project/alarms/:alarmID/ack", write, as.acknowledgeAlarm)
+	app.Post("/:project/alarms/:alarmID/clear", write, as.clearAlarm)
+
+	// Webhooks send project data to third parties, they are managed like the project
+	app.Post("/:project/webhooks", manage, as.createWebhook)
+	app.Get("/:project/webhooks", manage, as.getWebhooksByProject)
+	app.Get("/:project/webhooks/:webhookID", manage, as.getWebhookByProject)
+	app.Delete("/:project/webhooks/:webhookID", manage, as.deleteWebhook)
+	app.Get("/:project/webhooks/:webhookID/deliveries", manage, as.getWebhookDeliveries)
+
+	return app
 }
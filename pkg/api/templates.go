@@ -0,0 +1,302 @@
+package api
+
+import (
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/events"
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store/templates"
+	"github.com/gofiber/fiber"
+	"github.com/google/uuid"
+)
+
+type templateRequest struct {
+	Name          string                 `json:"name"`
+	Description   string                 `json:"description"`
+	Fields        []templates.Field      `json:"fields"`
+	Commands      []templates.Command    `json:"commands"`
+	DefaultConfig map[string]interface{} `json:"defaultConfig"`
+}
+
+func (req *templateRequest) apply(template *templates.Template) {
+	template.Name = req.Name
+	template.Description = req.Description
+	template.Fields = req.Fields
+	if template.Fields == nil {
+		template.Fields = make([]templates.Field, 0)
+	}
+	template.Commands = req.Commands
+	if template.Commands == nil {
+		template.Commands = make([]templates.Command, 0)
+	}
+	template.DefaultConfig = req.DefaultConfig
+	template.Updated = time.Now()
+}
+
+type setTemplateRequest struct {
+	TemplateID string `json:"templateID" form:"templateID"`
+}
+
+func (as *ApiServer) createTemplate(ctx *fiber.Ctx) {
+	req := &templateRequest{}
+	if err := ctx.BodyParser(req); err != nil {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid template"})
+		return
+	}
+
+	template := &templates.Template{}
+	req.apply(template)
+	as.saveNewTemplate(ctx, template)
+}
+
+// importTemplate creates a template from a W3C WoT Thing Description
+func (as *ApiServer) importTemplate(ctx *fiber.Ctx) {
+	template, err := templates.FromThingDescription([]byte(ctx.Body()))
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	template.Updated = time.Now()
+	as.saveNewTemplate(ctx, template)
+}
+
+func (as *ApiServer) saveNewTemplate(ctx *fiber.Ctx, template *templates.Template) {
+	template.ID = uuid.New().String()
+	template.ProjectID = ctx.Params("project")
+	template.Created = time.Now()
+
+	if err := template.Validate(); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	err := as.templateStore.CreateTemplate(ctx.Context(), template)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(template)
+}
+
+func (as *ApiServer) getTemplatesByProject(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	list, err := as.templateStore.ListTemplatesForProject(ctx.Context(), project)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(list)
+}
+
+func (as *ApiServer) getTemplateByProject(ctx *fiber.Ctx) {
+	template := as.findTemplate(ctx, ctx.Params("project"), ctx.Params("templateID"))
+	if template == nil {
+		return
+	}
+
+	ctx.JSON(template)
+}
+
+func (as *ApiServer) updateTemplate(ctx *fiber.Ctx) {
+	req := &templateRequest{}
+	if err := ctx.BodyParser(req); err != nil {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid template"})
+		return
+	}
+
+	template := as.findTemplate(ctx, ctx.Params("project"), ctx.Params("templateID"))
+	if template == nil {
+		return
+	}
+	req.apply(template)
+
+	if err := template.Validate(); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	err := as.templateStore.UpdateTemplate(ctx.Context(), template)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(template)
+}
+
+// deleteTemplate refuses templates still assigned to devices
+func (as *ApiServer) deleteTemplate(ctx *fiber.Ctx) {
+	c := ctx.Context()
+	project := ctx.Params("project")
+	templateID := ctx.Params("templateID")
+
+	if as.findTemplate(ctx, project, templateID) == nil {
+		return
+	}
+
+	list, err := as.deviceStore.ListDevicesForProject(c, project)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+	for _, device := range list {
+		if device.TemplateID() == templateID {
+			ctx.Status(fiber.StatusConflict)
+			ctx.JSON(fiber.Map{"message": "template is assigned to devices"})
+			return
+		}
+	}
+
+	err = as.templateStore.DeleteTemplate(c, project, templateID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(fiber.Map{"message": "deleted"})
+}
+
+// setDeviceTemplate assigns a template of the project to the device, only at the If-Match revision when given
+func (as *ApiServer) setDeviceTemplate(ctx *fiber.Ctx) {
+	deviceID := ctx.Params("deviceID")
+	project := ctx.Params("project")
+
+	req := &setTemplateRequest{}
+	if err := ctx.BodyParser(req); err != nil || req.TemplateID == "" {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Missing templateID"})
+		return
+	}
+
+	revision, err := parseIfMatch(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	if as.findProjectDevice(ctx, project, deviceID) == nil {
+		return
+	}
+	template := as.findTemplate(ctx, project, req.TemplateID)
+	if template == nil {
+		return
+	}
+
+	as.assignTemplate(ctx, project, deviceID, revision, template)
+}
+
+// removeDeviceTemplate unassigns the template of the device, its uplinks are no longer validated
+func (as *ApiServer) removeDeviceTemplate(ctx *fiber.Ctx) {
+	deviceID := ctx.Params("deviceID")
+	project := ctx.Params("project")
+
+	revision, err := parseIfMatch(ctx)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	device := as.findProjectDevice(ctx, project, deviceID)
+	if device == nil {
+		return
+	}
+	if device.TemplateID() == "" {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "device has no template"})
+		return
+	}
+
+	as.assignTemplate(ctx, project, deviceID, revision, nil)
+}
+
+// assignTemplate sets the template of the device, removing it when nil
+func (as *ApiServer) assignTemplate(ctx *fiber.Ctx, project, deviceID string, revision int64, template *templates.Template) {
+	templateID := ""
+	if template != nil {
+		templateID = template.ID
+	}
+	device, err := as.deviceStore.UpdateDevice(changeContext(ctx), project, deviceID, revision, time.Now(), map[string]interface{}{
+		devices.TemplateField: templateID,
+	})
+	if err != nil {
+		writeDeviceError(ctx, err)
+		return
+	}
+	as.publishDefaultConfig(ctx, project, deviceID, template)
+
+	ctx.Set(fiber.HeaderETag, deviceETag(device.Revision))
+	ctx.JSON(device)
+}
+
+// publishDefaultConfig publishes the default config of a template just assigned to the device,
+// for the integrations delivering config to devices. Templates without one publish nothing
+func (as *ApiServer) publishDefaultConfig(ctx *fiber.Ctx, project, deviceID string, template *templates.Template) {
+	if template == nil || len(template.DefaultConfig) == 0 {
+		return
+	}
+	as.publishEvent(ctx, events.TypeDeviceConfig, project, deviceID, fiber.Map{
+		"deviceID":   deviceID,
+		"templateID": template.ID,
+		"config":     template.DefaultConfig,
+	})
+}
+
+// getDeviceView returns the device with the fields, commands and default config of its template
+func (as *ApiServer) getDeviceView(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+
+	device := as.findProjectDevice(ctx, project, ctx.Params("deviceID"))
+	if device == nil {
+		return
+	}
+
+	var template *templates.Template
+	if device.TemplateID() != "" {
+		var err error
+		template, err = as.templateStore.GetTemplateByID(ctx.Context(), project, device.TemplateID())
+		if err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			ctx.JSON(fiber.Map{"message": err.Error()})
+			return
+		}
+	}
+
+	ctx.Set(fiber.HeaderETag, deviceETag(device.Revision))
+	ctx.JSON(templates.NewView(device, template))
+}
+
+// findTemplate loads a template of the project, writing the error response when missing
+func (as *ApiServer) findTemplate(ctx *fiber.Ctx, project, templateID string) *templates.Template {
+	template, err := as.templateStore.GetTemplateByID(ctx.Context(), project, templateID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return nil
+	}
+
+	if template == nil {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "template not found"})
+		return nil
+	}
+
+	return template
+}
@@ -0,0 +1,119 @@
+package api
+
+import (
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/users"
+	"github.com/gofiber/fiber"
+)
+
+// defaultTokenTTL is how long user tokens are valid unless configured
+const defaultTokenTTL = 12 * time.Hour
+
+type loginRequest struct {
+	Email    string `json:"email" form:"email"`
+	Password string `json:"password" form:"password"`
+}
+
+type acceptInviteRequest struct {
+	Email    string `json:"email" form:"email"`
+	Token    string `json:"token" form:"token"`
+	Name     string `json:"name" form:"name"`
+	Password string `json:"password" form:"password"`
+}
+
+// login checks the password of a user and issues a token for the API
+func (as *ApiServer) login(ctx *fiber.Ctx) {
+	if as.config.JWTKey == "" {
+		ctx.Status(fiber.StatusNotImplemented)
+		ctx.JSON(fiber.Map{"message": "user sign in is not configured"})
+		return
+	}
+
+	req := &loginRequest{}
+	if err := ctx.BodyParser(req); err != nil {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid credentials"})
+		return
+	}
+
+	var user *users.User
+	email, err := users.NormalizeEmail(req.Email)
+	if err == nil {
+		user, err = as.userStore.GetUserByEmail(ctx.Context(), email)
+		if err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			ctx.JSON(fiber.Map{"message": err.Error()})
+			return
+		}
+	}
+	if user == nil || !user.CheckPassword(req.Password) {
+		ctx.Status(fiber.StatusUnauthorized)
+		ctx.JSON(fiber.Map{"message": "invalid credentials"})
+		return
+	}
+
+	ttl := as.config.TokenTTL
+	if ttl <= 0 {
+		ttl = defaultTokenTTL
+	}
+	token, expires, err := users.IssueToken([]byte(as.config.JWTKey), user.Email, ttl)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(fiber.Map{
+		"token":   token,
+		"expires": expires,
+	})
+}
+
+// acceptInvite signs up an invited user, setting its password
+func (as *ApiServer) acceptInvite(ctx *fiber.Ctx) {
+	req := &acceptInviteRequest{}
+	if err := ctx.BodyParser(req); err != nil {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid invite"})
+		return
+	}
+
+	email, err := users.NormalizeEmail(req.Email)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+	user, err := as.userStore.GetUserByEmail(ctx.Context(), email)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+	if user == nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": "invalid or expired invite"})
+		return
+	}
+
+	if err := user.AcceptInvite(req.Token, req.Password); err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+	if req.Name != "" {
+		user.Name = req.Name
+	}
+
+	err = as.userStore.UpdateUser(ctx.Context(), user)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(user.Redacted())
+}
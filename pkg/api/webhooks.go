@@ -0,0 +1,196 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/integrations"
+	"github.com/gofiber/fiber"
+	"github.com/google/uuid"
+)
+
+const defaultDeliveriesLimit = 100
+
+type createWebhookRequest struct {
+	URL     string   `json:"url" form:"url"`
+	Events  []string `json:"events" form:"events"`
+	Devices []string `json:"devices" form:"devices"`
+	Secret  string   `json:"secret" form:"secret"`
+}
+
+// webhookView hides the signing secret, which is only returned on creation
+type webhookView struct {
+	ID        string    `json:"id"`
+	ProjectID string    `json:"projectID"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Devices   []string  `json:"devices,omitempty"`
+	Enabled   bool      `json:"enabled"`
+	Created   time.Time `json:"created"`
+}
+
+func newWebhookView(webhook *integrations.Webhook) *webhookView {
+	return &webhookView{
+		ID:        webhook.ID,
+		ProjectID: webhook.ProjectID,
+		URL:       webhook.URL,
+		Events:    webhook.Events,
+		Devices:   webhook.Devices,
+		Enabled:   webhook.Enabled,
+		Created:   webhook.Created,
+	}
+}
+
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (as *ApiServer) createWebhook(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+
+	req := &createWebhookRequest{}
+	if err := ctx.BodyParser(req); err != nil {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid webhook"})
+		return
+	}
+
+	endpoint, err := url.Parse(req.URL)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		ctx.
+			Status(fiber.StatusBadRequest).
+			JSON(fiber.Map{"message": "Invalid webhook url"})
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		secret, err = generateSecret()
+		if err != nil {
+			ctx.Status(fiber.StatusInternalServerError)
+			ctx.JSON(fiber.Map{"message": err.Error()})
+			return
+		}
+	}
+
+	if req.Events == nil {
+		req.Events = make([]string, 0)
+	}
+
+	webhook := &integrations.Webhook{
+		ID:        uuid.New().String(),
+		ProjectID: project,
+		URL:       req.URL,
+		Events:    req.Events,
+		Devices:   req.Devices,
+		Secret:    secret,
+		Enabled:   true,
+		Created:   time.Now(),
+	}
+
+	err = as.webhookStore.CreateWebhook(ctx.Context(), webhook)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(webhook)
+}
+
+func (as *ApiServer) getWebhooksByProject(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	list, err := as.webhookStore.ListWebhooksForProject(ctx.Context(), project)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	views := make([]*webhookView, 0, len(list))
+	for _, webhook := range list {
+		views = append(views, newWebhookView(webhook))
+	}
+
+	ctx.JSON(views)
+}
+
+func (as *ApiServer) getWebhookByProject(ctx *fiber.Ctx) {
+	webhook := as.findWebhook(ctx)
+	if webhook == nil {
+		return
+	}
+
+	ctx.JSON(newWebhookView(webhook))
+}
+
+func (as *ApiServer) deleteWebhook(ctx *fiber.Ctx) {
+	project := ctx.Params("project")
+	webhookID := ctx.Params("webhookID")
+
+	err := as.webhookStore.DeleteWebhook(ctx.Context(), project, webhookID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(fiber.Map{"message": "deleted"})
+}
+
+func (as *ApiServer) getWebhookDeliveries(ctx *fiber.Ctx) {
+	webhook := as.findWebhook(ctx)
+	if webhook == nil {
+		return
+	}
+
+	limit := defaultDeliveriesLimit
+	if value := ctx.Query("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil {
+			ctx.Status(fiber.StatusBadRequest)
+			ctx.JSON(fiber.Map{"message": err.Error()})
+			return
+		}
+	}
+
+	list, err := as.webhookStore.ListDeliveries(ctx.Context(), webhook.ProjectID, webhook.ID, limit)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return
+	}
+
+	ctx.JSON(list)
+}
+
+// findWebhook loads the webhook of the route, writing the error response when missing
+func (as *ApiServer) findWebhook(ctx *fiber.Ctx) *integrations.Webhook {
+	project := ctx.Params("project")
+	webhookID := ctx.Params("webhookID")
+
+	webhook, err := as.webhookStore.GetWebhookByID(ctx.Context(), project, webhookID)
+	if err != nil {
+		ctx.Status(fiber.StatusBadRequest)
+		ctx.JSON(fiber.Map{"message": err.Error()})
+		return nil
+	}
+
+	if webhook == nil {
+		ctx.Status(fiber.StatusNotFound)
+		ctx.JSON(fiber.Map{"message": "not found"})
+		return nil
+	}
+
+	return webhook
+}
@@ -1,23 +1,67 @@
 package config
 
+import "time"
+
 type PlatformConfig struct {
 	StorageConfig   StorageConfig   `yaml:"storage"`
 	MessagingConfig MessagingConfig `yaml:"messaging"`
 	APIServerConfig APIServerConfig `yaml:"api"`
 	GatewayConfigs  []GatewayConfig `yaml:"gateways"`
+	EgressConfigs   []EgressConfig  `yaml:"egress,omitempty"`
 }
 
 type StorageConfig struct {
-	Type string `yaml:"type"`
-	URL  string `yaml:"url"`
+	Type        string            `yaml:"type"`
+	URL         string            `yaml:"url"`
+	Database    string            `yaml:"database,omitempty"`
+	Collections CollectionsConfig `yaml:"collections,omitempty"`
+	Compaction  CompactionConfig  `yaml:"compaction,omitempty"`
+	TimeSeries  TimeSeriesConfig  `yaml:"timeseries,omitempty"`
+}
+
+type TimeSeriesConfig struct {
+	Layout string `yaml:"layout,omitempty"`
+}
+
+type CompactionConfig struct {
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+type CollectionsConfig struct {
+	Devices           string `yaml:"devices,omitempty"`
+	DeviceChanges     string `yaml:"deviceChanges,omitempty"`
+	DeviceSnapshots   string `yaml:"deviceSnapshots,omitempty"`
+	Projects          string `yaml:"projects,omitempty"`
+	History           string `yaml:"history,omitempty"`
+	FieldHistory      string `yaml:"fieldHistory,omitempty"`
+	Rules             string `yaml:"rules,omitempty"`
+	RuleStates        string `yaml:"ruleStates,omitempty"`
+	Alerts            string `yaml:"alerts,omitempty"`
+	Alarms            string `yaml:"alarms,omitempty"`
+	Webhooks          string `yaml:"webhooks,omitempty"`
+	WebhookDeliveries string `yaml:"webhookDeliveries,omitempty"`
+	Groups            string `yaml:"groups,omitempty"`
+	Templates         string `yaml:"templates,omitempty"`
+	APIKeys           string `yaml:"apiKeys,omitempty"`
+	Users             string `yaml:"users,omitempty"`
+	Members           string `yaml:"members,omitempty"`
 }
 
 type MessagingConfig struct {
-	Type string `yaml:"type"`
+	Type     string `yaml:"type"`
+	Encoding string `yaml:"encoding,omitempty"`
 }
 
 type APIServerConfig struct {
 	Port int `yaml:"port"`
+	// AdminToken grants access to every route and manages API keys, authentication is
+	// disabled when empty. It is required once users can sign in or API keys exist
+	AdminToken string `yaml:"adminToken,omitempty"`
+	// JWTKey signs the tokens of users signing in with a password, user sign in is
+	// disabled when empty. The API refuses to start with it but no admin token
+	JWTKey string `yaml:"jwtKey,omitempty"`
+	// TokenTTL is how long user tokens are valid, 12h by default
+	TokenTTL time.Duration `yaml:"tokenTTL,omitempty"`
 }
 
 type GatewayConfig struct {
@@ -25,3 +69,10 @@ type GatewayConfig struct {
 	Port     int    `yaml:"port"`
 	SslPort  int    `yaml:"sslPort,omitempty"`
 }
+
+type EgressConfig struct {
+	Type   string `yaml:"type"`
+	URL    string `yaml:"url"`
+	Mode   string `yaml:"mode,omitempty"`
+	Source string `yaml:"source,omitempty"`
+}
@@ -0,0 +1,174 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"gocloud.dev/pubsub"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func testEnvelope() *Envelope {
+	return &Envelope{
+		Version:       Version,
+		DeviceID:      "0a1b2c",
+		ProjectID:     "project",
+		Protocol:      "coap",
+		ReceivedAt:    time.Unix(1600000000, 123456789),
+		ReportedAt:    time.Unix(1599999990, 0),
+		ContentFormat: "application/cbor",
+		AuthIdentity:  "0a1b2c",
+		GatewayID:     "ff00",
+		Payload:       []byte(`{"temperature":21.5}`),
+	}
+}
+
+func checkEnvelope(t *testing.T, expected, got *Envelope) {
+	t.Helper()
+	if got.Version != expected.Version || got.DeviceID != expected.DeviceID || got.ProjectID != expected.ProjectID ||
+		got.Protocol != expected.Protocol || got.ContentFormat != expected.ContentFormat ||
+		got.AuthIdentity != expected.AuthIdentity || got.GatewayID != expected.GatewayID {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+	if !got.ReceivedAt.Equal(expected.ReceivedAt) || !got.ReportedAt.Equal(expected.ReportedAt) {
+		t.Errorf("expected times %v %v, got %v %v", expected.ReceivedAt, expected.ReportedAt, got.ReceivedAt, got.ReportedAt)
+	}
+	if !bytes.Equal(got.Payload, expected.Payload) {
+		t.Errorf("expected payload %s, got %s", expected.Payload, got.Payload)
+	}
+}
+
+func checkUnsupported(t *testing.T, err error, version int) {
+	t.Helper()
+	var unsupported *UnsupportedVersionError
+	if !errors.As(err, &unsupported) {
+		t.Fatalf("expected an UnsupportedVersionError, got %v", err)
+	}
+	if unsupported.Version != version {
+		t.Errorf("expected version %d, got %d", version, unsupported.Version)
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	e := testEnvelope()
+	body, err := MarshalJSON(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := UnmarshalJSON(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEnvelope(t, e, got)
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		envelope *Envelope
+	}{
+		{"every field", testEnvelope()},
+		{"only required fields", &Envelope{Version: Version, DeviceID: "0a1b2c"}},
+	}
+
+	for _, test := range tests {
+		body, err := MarshalProto(test.envelope)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		got, err := UnmarshalProto(body)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		checkEnvelope(t, test.envelope, got)
+	}
+}
+
+func TestProtoSkipsUnknownFields(t *testing.T) {
+	e := testEnvelope()
+	body, err := MarshalProto(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body = protowire.AppendTag(body, 42, protowire.Fixed64Type)
+	body = protowire.AppendFixed64(body, 7)
+	body = protowire.AppendTag(body, 43, protowire.BytesType)
+	body = protowire.AppendString(body, "newer writer")
+
+	got, err := UnmarshalProto(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEnvelope(t, e, got)
+}
+
+func TestProtoRejectsTruncatedBody(t *testing.T) {
+	body, err := MarshalProto(testEnvelope())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = UnmarshalProto(body[:len(body)-1])
+	if err == nil {
+		t.Error("expected an error decoding a truncated body")
+	}
+}
+
+func TestMessageRoundTrip(t *testing.T) {
+	for _, encoding := range []string{EncodingJSON, EncodingProto} {
+		e := testEnvelope()
+		msg, err := ToMessage(e, encoding)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		if msg.Metadata[MetadataEncoding] != encoding || msg.Metadata[MetadataDeviceID] != e.DeviceID {
+			t.Errorf("%s: unexpected metadata %v", encoding, msg.Metadata)
+		}
+		got, err := FromMessage(msg)
+		if err != nil {
+			t.Fatalf("%s: %v", encoding, err)
+		}
+		checkEnvelope(t, e, got)
+	}
+}
+
+func TestUnsupportedVersion(t *testing.T) {
+	e := testEnvelope()
+	e.Version = Version + 1
+
+	body, err := MarshalJSON(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = UnmarshalJSON(body)
+	checkUnsupported(t, err, Version+1)
+
+	body, err = MarshalProto(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = UnmarshalProto(body)
+	checkUnsupported(t, err, Version+1)
+
+	// The metadata is checked before the body is decoded
+	msg, err := ToMessage(testEnvelope(), EncodingJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Metadata[MetadataVersion] = "2"
+	_, err = FromMessage(msg)
+	checkUnsupported(t, err, 2)
+}
+
+func TestUnknownEncoding(t *testing.T) {
+	_, err := ToMessage(testEnvelope(), "xml")
+	if err == nil {
+		t.Error("expected an error encoding with an unknown encoding")
+	}
+
+	_, err = FromMessage(&pubsub.Message{Body: []byte("<envelope/>"), Metadata: map[string]string{MetadataEncoding: "xml"}})
+	if err == nil {
+		t.Error("expected an error decoding an unknown encoding")
+	}
+}
@@ -0,0 +1,26 @@
+package envelope
+
+import (
+	"encoding/json"
+)
+
+// MarshalJSON encodes the envelope as a JSON document
+func MarshalJSON(e *Envelope) ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// UnmarshalJSON decodes a JSON document into an envelope, rejecting unknown versions
+func UnmarshalJSON(body []byte) (*Envelope, error) {
+	e := &Envelope{}
+	err := json.Unmarshal(body, e)
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkVersion(e.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
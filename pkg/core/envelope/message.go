@@ -0,0 +1,59 @@
+package envelope
+
+import (
+	"fmt"
+	"strconv"
+
+	"gocloud.dev/pubsub"
+)
+
+// ToMessage wraps the envelope in a pubsub message using the given encoding
+func ToMessage(e *Envelope, encoding string) (*pubsub.Message, error) {
+	var body []byte
+	var err error
+	switch encoding {
+	case EncodingProto:
+		body, err = MarshalProto(e)
+	case EncodingJSON, "":
+		encoding = EncodingJSON
+		body, err = MarshalJSON(e)
+	default:
+		return nil, fmt.Errorf("unknown envelope encoding %q", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &pubsub.Message{
+		Body: body,
+		Metadata: map[string]string{
+			MetadataVersion:  strconv.Itoa(e.Version),
+			MetadataEncoding: encoding,
+			MetadataDeviceID: e.DeviceID,
+		},
+	}, nil
+}
+
+// FromMessage decodes the envelope carried by a pubsub message.
+// Messages declaring or containing an unknown version return an *UnsupportedVersionError.
+func FromMessage(msg *pubsub.Message) (*Envelope, error) {
+	if v, ok := msg.Metadata[MetadataVersion]; ok {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid envelope version %q", v)
+		}
+		err = checkVersion(version)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch msg.Metadata[MetadataEncoding] {
+	case EncodingProto:
+		return UnmarshalProto(msg.Body)
+	case EncodingJSON, "":
+		return UnmarshalJSON(msg.Body)
+	default:
+		return nil, fmt.Errorf("unknown envelope encoding %q", msg.Metadata[MetadataEncoding])
+	}
+}
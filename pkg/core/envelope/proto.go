@@ -0,0 +1,139 @@
+package envelope
+
+import (
+	"errors"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	fieldVersion       protowire.Number = 1
+	fieldDeviceID      protowire.Number = 2
+	fieldProjectID     protowire.Number = 3
+	fieldProtocol      protowire.Number = 4
+	fieldReceivedAt    protowire.Number = 5
+	fieldReportedAt    protowire.Number = 6
+	fieldContentFormat protowire.Number = 7
+	fieldAuthIdentity  protowire.Number = 8
+	fieldPayload       protowire.Number = 9
+	fieldGatewayID     protowire.Number = 10
+)
+
+// MarshalProto encodes the envelope following envelope.proto
+func MarshalProto(e *Envelope) ([]byte, error) {
+	var b []byte
+	b = appendVarint(b, fieldVersion, uint64(e.Version))
+	b = appendString(b, fieldDeviceID, e.DeviceID)
+	b = appendString(b, fieldProjectID, e.ProjectID)
+	b = appendString(b, fieldProtocol, e.Protocol)
+	b = appendVarint(b, fieldReceivedAt, uint64(unixNano(e.ReceivedAt)))
+	b = appendVarint(b, fieldReportedAt, uint64(unixNano(e.ReportedAt)))
+	b = appendString(b, fieldContentFormat, e.ContentFormat)
+	b = appendString(b, fieldAuthIdentity, e.AuthIdentity)
+	if len(e.Payload) > 0 {
+		b = protowire.AppendTag(b, fieldPayload, protowire.BytesType)
+		b = protowire.AppendBytes(b, e.Payload)
+	}
+	b = appendString(b, fieldGatewayID, e.GatewayID)
+	return b, nil
+}
+
+// UnmarshalProto decodes a protobuf encoded envelope, rejecting unknown versions
+func UnmarshalProto(body []byte) (*Envelope, error) {
+	e := &Envelope{}
+	for len(body) > 0 {
+		num, typ, n := protowire.ConsumeTag(body)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		body = body[n:]
+
+		switch {
+		case typ == protowire.VarintType:
+			v, n := protowire.ConsumeVarint(body)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			body = body[n:]
+			switch num {
+			case fieldVersion:
+				e.Version = int(v)
+			case fieldReceivedAt:
+				e.ReceivedAt = fromUnixNano(int64(v))
+			case fieldReportedAt:
+				e.ReportedAt = fromUnixNano(int64(v))
+			}
+		case typ == protowire.BytesType:
+			v, n := protowire.ConsumeBytes(body)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			body = body[n:]
+			switch num {
+			case fieldDeviceID:
+				e.DeviceID = string(v)
+			case fieldProjectID:
+				e.ProjectID = string(v)
+			case fieldProtocol:
+				e.Protocol = string(v)
+			case fieldContentFormat:
+				e.ContentFormat = string(v)
+			case fieldAuthIdentity:
+				e.AuthIdentity = string(v)
+			case fieldPayload:
+				e.Payload = append([]byte(nil), v...)
+			case fieldGatewayID:
+				e.GatewayID = string(v)
+			}
+		default:
+			// Skip unknown fields so newer writers don't break older readers
+			n := protowire.ConsumeFieldValue(num, typ, body)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			body = body[n:]
+		}
+	}
+
+	if e.DeviceID == "" && e.Version == 0 {
+		return nil, errors.New("empty envelope")
+	}
+
+	err := checkVersion(e.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func appendVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendString(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+func fromUnixNano(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, v)
+}
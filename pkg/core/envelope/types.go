@@ -0,0 +1,85 @@
+package envelope
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Version is the envelope schema version produced by this build
+const Version = 1
+
+// Supported wire encodings for an envelope body
+const (
+	EncodingJSON  = "json"
+	EncodingProto = "protobuf"
+)
+
+// Metadata keys set on every pubsub message carrying an envelope
+const (
+	MetadataVersion  = "version"
+	MetadataEncoding = "encoding"
+	MetadataDeviceID = "deviceID"
+)
+
+// Envelope is the contract between gateways and everything consuming the data topic
+type Envelope struct {
+	Version       int       `json:"version"`
+	DeviceID      string    `json:"deviceID"`
+	ProjectID     string    `json:"projectID,omitempty"`
+	Protocol      string    `json:"protocol"`
+	ReceivedAt    time.Time `json:"receivedAt"`
+	ReportedAt    time.Time `json:"reportedAt"`
+	ContentFormat string    `json:"contentFormat,omitempty"`
+	AuthIdentity  string    `json:"authIdentity,omitempty"`
+	// GatewayID is the device that relayed the uplink of a child device
+	GatewayID string          `json:"gatewayID,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// UnsupportedVersionError is returned when decoding an envelope written with an unknown schema version
+type UnsupportedVersionError struct {
+	Version int
+}
+
+func (e *UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("unsupported envelope version %d", e.Version)
+}
+
+// New creates an envelope for a device uplink, received and reported now
+func New(deviceID, protocol string, payload map[string]interface{}) (*Envelope, error) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	return &Envelope{
+		Version:    Version,
+		DeviceID:   deviceID,
+		Protocol:   protocol,
+		ReceivedAt: now,
+		ReportedAt: now,
+		Payload:    body,
+	}, nil
+}
+
+// Data decodes the payload into a nested map
+func (e *Envelope) Data() (map[string]interface{}, error) {
+	data := make(map[string]interface{})
+	if len(e.Payload) == 0 {
+		return data, nil
+	}
+	err := json.Unmarshal(e.Payload, &data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func checkVersion(version int) error {
+	if version != Version {
+		return &UnsupportedVersionError{Version: version}
+	}
+	return nil
+}
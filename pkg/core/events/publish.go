@@ -0,0 +1,26 @@
+package events
+
+import (
+	"context"
+
+	"gocloud.dev/pubsub"
+)
+
+// Publish builds an event and sends it to the topic
+func Publish(ctx context.Context, topic *pubsub.Topic, eventType, projectID, deviceID string, data interface{}) error {
+	if topic == nil {
+		return nil
+	}
+
+	e, err := New(eventType, projectID, deviceID, data)
+	if err != nil {
+		return err
+	}
+
+	msg, err := ToMessage(e)
+	if err != nil {
+		return err
+	}
+
+	return topic.Send(ctx, msg)
+}
@@ -0,0 +1,75 @@
+package events
+
+import (
+	"encoding/json"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+// Platform event types published on the events topic
+const (
+	TypeDeviceStateReported = "device.state.reported"
+	TypeDeviceRegistered    = "device.registered"
+	TypeDeviceCommand       = "device.command"
+	TypeDeviceConfig        = "device.config"
+
+	TypeAlertFiring   = "alert.firing"
+	TypeAlertResolved = "alert.resolved"
+
+	TypeAlarmRaised       = "alarm.raised"
+	TypeAlarmAcknowledged = "alarm.acknowledged"
+	TypeAlarmCleared      = "alarm.cleared"
+)
+
+const metadataType = "type"
+
+// Event is a platform event, as opposed to device uplinks which travel as envelopes on the data topic
+type Event struct {
+	Type      string          `json:"type"`
+	ProjectID string          `json:"projectID"`
+	DeviceID  string          `json:"deviceID,omitempty"`
+	Time      time.Time       `json:"time"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// New creates an event happening now, with data encoded as JSON
+func New(eventType, projectID, deviceID string, data interface{}) (*Event, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{
+		Type:      eventType,
+		ProjectID: projectID,
+		DeviceID:  deviceID,
+		Time:      time.Now(),
+		Data:      body,
+	}, nil
+}
+
+// ToMessage wraps the event in a pubsub message
+func ToMessage(e *Event) (*pubsub.Message, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pubsub.Message{
+		Body: body,
+		Metadata: map[string]string{
+			metadataType: e.Type,
+		},
+	}, nil
+}
+
+// FromMessage decodes the event carried by a pubsub message
+func FromMessage(msg *pubsub.Message) (*Event, error) {
+	e := &Event{}
+	err := json.Unmarshal(msg.Body, e)
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
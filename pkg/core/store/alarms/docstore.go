@@ -0,0 +1,117 @@
+package alarms
+
+import (
+	"context"
+	"io"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+type alarmDocStore struct {
+	coll *docstore.Collection
+}
+
+// NewAlarmDocStore create an alarm store using a goacloud.dev/docstore collection
+func NewAlarmDocStore(coll *docstore.Collection) AlarmStore {
+	return &alarmDocStore{
+		coll: coll,
+	}
+}
+
+func (s *alarmDocStore) GetAlarmByID(ctx context.Context, projectID, id string) (*Alarm, error) {
+	alarm := &Alarm{ID: id}
+	err := s.coll.Get(ctx, alarm)
+	if err != nil {
+		code := gcerrors.Code(err)
+		if code == gcerrors.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if alarm.ProjectID != projectID {
+		return nil, nil
+	}
+
+	return alarm, nil
+}
+
+func (s *alarmDocStore) FindActiveAlarm(ctx context.Context, projectID, deviceID, alarmType string) (*Alarm, error) {
+	list, err := s.ListAlarms(ctx, projectID, AlarmQuery{
+		DeviceID: deviceID,
+		Type:     alarmType,
+		Status:   StatusActive,
+	})
+	if err != nil || len(list) == 0 {
+		return nil, err
+	}
+	return list[0], nil
+}
+
+func (s *alarmDocStore) CreateAlarm(ctx context.Context, alarm *Alarm) error {
+	return s.coll.Create(ctx, alarm)
+}
+
+func (s *alarmDocStore) UpdateAlarm(ctx context.Context, alarm *Alarm) error {
+	return s.coll.Replace(ctx, alarm)
+}
+
+func (s *alarmDocStore) ListAlarms(ctx context.Context, projectID string, query AlarmQuery) ([]*Alarm, error) {
+	q := s.coll.
+		Query().
+		Where("projectID", "=", projectID)
+	if query.DeviceID != "" {
+		q = q.Where("deviceID", "=", query.DeviceID)
+	}
+	if query.Type != "" {
+		q = q.Where("type", "=", query.Type)
+	}
+	if query.Status != "" {
+		q = q.Where("status", "=", query.Status)
+	}
+	if query.Severity != "" {
+		q = q.Where("severity", "=", query.Severity)
+	}
+	if query.Acknowledged != nil {
+		q = q.Where("acknowledged", "=", *query.Acknowledged)
+	}
+
+	iter := q.Get(ctx)
+	defer iter.Stop()
+
+	list := make([]*Alarm, 0)
+	for {
+		alarm := &Alarm{}
+		err := iter.Next(ctx, alarm)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		list = append(list, alarm)
+	}
+	sortByStartTime(list)
+	return list, nil
+}
+
+func (s *alarmDocStore) DeleteAlarmsForProject(ctx context.Context, projectID string) error {
+	iter := s.coll.
+		Query().
+		Where("projectID", "=", projectID).
+		Get(ctx, "id")
+	defer iter.Stop()
+
+	actions := s.coll.Actions()
+	for {
+		alarm := &Alarm{}
+		err := iter.Next(ctx, alarm)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		actions = actions.Delete(alarm)
+	}
+	return actions.Do(ctx)
+}
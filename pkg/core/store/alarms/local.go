@@ -0,0 +1,164 @@
+package alarms
+
+import (
+	"context"
+	"encoding/json"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
+	bolt "go.etcd.io/bbolt"
+)
+
+type alarmLocalStore struct {
+	db *bolt.DB
+}
+
+const (
+	alarmBucketName       = "alarms"
+	activeAlarmBucketName = "alarms_active"
+)
+
+// alarmBucket holds the alarms of the project, by ID
+func alarmBucket(projectID string) []byte {
+	return []byte(tenancy.Key(projectID, alarmBucketName))
+}
+
+// activeAlarmBucket indexes the active alarms of the project by device and type
+func activeAlarmBucket(projectID string) []byte {
+	return []byte(tenancy.Key(projectID, activeAlarmBucketName))
+}
+
+func NewAlarmLocalStore(db *bolt.DB) AlarmStore {
+	return &alarmLocalStore{
+		db: db,
+	}
+}
+
+// activeKey indexes the active alarm of a device for a given type, used to deduplicate triggers
+func activeKey(deviceID, alarmType string) []byte {
+	return []byte(deviceID + "/" + alarmType)
+}
+
+func (s *alarmLocalStore) GetAlarmByID(ctx context.Context, projectID, id string) (*Alarm, error) {
+	var alarm *Alarm
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		alarm, err = getAlarm(tx, projectID, id)
+		return err
+	})
+	return alarm, err
+}
+
+func getAlarm(tx *bolt.Tx, projectID, id string) (*Alarm, error) {
+	buck := tx.Bucket(alarmBucket(projectID))
+	if buck == nil {
+		return nil, nil
+	}
+
+	v := buck.Get([]byte(id))
+	if v == nil {
+		return nil, nil
+	}
+
+	alarm := &Alarm{}
+	err := json.Unmarshal(v, alarm)
+	if err != nil {
+		return nil, err
+	}
+	return alarm, nil
+}
+
+func (s *alarmLocalStore) FindActiveAlarm(ctx context.Context, projectID, deviceID, alarmType string) (*Alarm, error) {
+	var alarm *Alarm
+	err := s.db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket(activeAlarmBucket(projectID))
+		if index == nil {
+			return nil
+		}
+
+		id := index.Get(activeKey(deviceID, alarmType))
+		if id == nil {
+			return nil
+		}
+
+		var err error
+		alarm, err = getAlarm(tx, projectID, string(id))
+		if err == nil && alarm == nil {
+			return errAlarmStoreCorrupted
+		}
+		return err
+	})
+	return alarm, err
+}
+
+func (s *alarmLocalStore) CreateAlarm(ctx context.Context, alarm *Alarm) error {
+	return s.putAlarm(alarm)
+}
+
+func (s *alarmLocalStore) UpdateAlarm(ctx context.Context, alarm *Alarm) error {
+	return s.putAlarm(alarm)
+}
+
+func (s *alarmLocalStore) putAlarm(alarm *Alarm) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck, err := tx.CreateBucketIfNotExists(alarmBucket(alarm.ProjectID))
+		if err != nil {
+			return err
+		}
+
+		index, err := tx.CreateBucketIfNotExists(activeAlarmBucket(alarm.ProjectID))
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(alarm)
+		if err != nil {
+			return err
+		}
+
+		err = buck.Put([]byte(alarm.ID), value)
+		if err != nil {
+			return err
+		}
+
+		key := activeKey(alarm.DeviceID, alarm.Type)
+		if alarm.Status == StatusActive {
+			return index.Put(key, []byte(alarm.ID))
+		}
+		if string(index.Get(key)) == alarm.ID {
+			return index.Delete(key)
+		}
+		return nil
+	})
+}
+
+func (s *alarmLocalStore) ListAlarms(ctx context.Context, projectID string, query AlarmQuery) ([]*Alarm, error) {
+	list := make([]*Alarm, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket(alarmBucket(projectID))
+		if buck == nil {
+			return nil
+		}
+
+		return buck.ForEach(func(k, v []byte) error {
+			alarm := &Alarm{}
+			err := json.Unmarshal(v, alarm)
+			if err != nil {
+				return err
+			}
+			if query.matches(alarm) {
+				list = append(list, alarm)
+			}
+			return nil
+		})
+	})
+
+	sortByStartTime(list)
+	return list, err
+}
+
+func (s *alarmLocalStore) DeleteAlarmsForProject(ctx context.Context, projectID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		_, err := tenancy.DeleteBuckets(tx, projectID, alarmBucketName, activeAlarmBucketName)
+		return err
+	})
+}
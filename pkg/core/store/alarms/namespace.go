@@ -0,0 +1,94 @@
+package alarms
+
+import (
+	"encoding/json"
+	"strings"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
+	bolt "go.etcd.io/bbolt"
+)
+
+// legacyBucketPrefix starts the alarm and active index buckets written before alarms were
+// namespaced by project. The index of a project could share the bucket of the alarms of
+// another, "alarms_active_X" was both, so buckets are split by content instead of by name
+const legacyBucketPrefix = "alarms_"
+
+// NamespaceAlarms moves alarms written before the store was namespaced by project to the
+// namespace of the project they record, and rebuilds the active index from them.
+// Legacy index entries are dropped. It returns the number of moved alarms
+func NamespaceAlarms(db *bolt.DB) (int, error) {
+	legacy := make([]string, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, buck *bolt.Bucket) error {
+			n := string(name)
+			if strings.HasPrefix(n, legacyBucketPrefix) && !strings.Contains(n, tenancy.Separator) {
+				legacy = append(legacy, n)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for _, name := range legacy {
+		err = db.Update(func(tx *bolt.Tx) error {
+			moved, err := moveLegacyAlarms(tx, name)
+			count += moved
+			return err
+		})
+		if err != nil {
+			return count, err
+		}
+	}
+	return count, nil
+}
+
+func moveLegacyAlarms(tx *bolt.Tx, name string) (int, error) {
+	src := tx.Bucket([]byte(name))
+	if src == nil {
+		return 0, nil
+	}
+
+	// Index entries map device and type to an alarm ID, they don't decode as alarms
+	list := make([]*Alarm, 0)
+	err := src.ForEach(func(k, v []byte) error {
+		alarm := &Alarm{}
+		if json.Unmarshal(v, alarm) == nil && alarm.ID != "" {
+			list = append(list, alarm)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, alarm := range list {
+		buck, err := tx.CreateBucketIfNotExists(alarmBucket(alarm.ProjectID))
+		if err != nil {
+			return 0, err
+		}
+		index, err := tx.CreateBucketIfNotExists(activeAlarmBucket(alarm.ProjectID))
+		if err != nil {
+			return 0, err
+		}
+
+		value, err := json.Marshal(alarm)
+		if err != nil {
+			return 0, err
+		}
+		err = buck.Put([]byte(alarm.ID), value)
+		if err != nil {
+			return 0, err
+		}
+		if alarm.Status == StatusActive {
+			err = index.Put(activeKey(alarm.DeviceID, alarm.Type), []byte(alarm.ID))
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return len(list), tx.DeleteBucket([]byte(name))
+}
@@ -0,0 +1,180 @@
+package alarms
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+)
+
+type AlarmStore interface {
+	GetAlarmByID(ctx context.Context, projectID, id string) (*Alarm, error)
+	FindActiveAlarm(ctx context.Context, projectID, deviceID, alarmType string) (*Alarm, error)
+	CreateAlarm(ctx context.Context, alarm *Alarm) error
+	UpdateAlarm(ctx context.Context, alarm *Alarm) error
+	ListAlarms(ctx context.Context, projectID string, query AlarmQuery) ([]*Alarm, error)
+	DeleteAlarmsForProject(ctx context.Context, projectID string) error
+}
+
+// Alarm status
+const (
+	StatusActive  = "active"
+	StatusCleared = "cleared"
+)
+
+// Alarm severities, from most to least severe
+const (
+	SeverityCritical = "critical"
+	SeverityMajor    = "major"
+	SeverityMinor    = "minor"
+	SeverityWarning  = "warning"
+)
+
+var severityRank = map[string]int{
+	SeverityCritical: 4,
+	SeverityMajor:    3,
+	SeverityMinor:    2,
+	SeverityWarning:  1,
+}
+
+var (
+	ErrAlarmCleared        = errors.New("alarm already cleared")
+	ErrAlarmAcknowledged   = errors.New("alarm already acknowledged")
+	ErrInvalidSeverity     = errors.New("invalid alarm severity")
+	errAlarmStoreCorrupted = errors.New("invalid alarm record")
+)
+
+// Alarm is an operator facing condition on a device, repeated triggers are deduplicated into one active alarm
+type Alarm struct {
+	ID        string `json:"id" docstore:"id"`
+	ProjectID string `json:"projectID" docstore:"projectID"`
+	DeviceID  string `json:"deviceID" docstore:"deviceID"`
+	// Type identifies the condition, at most one alarm per device and type is active
+	Type     string `json:"type" docstore:"type"`
+	RuleID   string `json:"ruleID,omitempty" docstore:"ruleID"`
+	Message  string `json:"message,omitempty" docstore:"message"`
+	Severity string `json:"severity" docstore:"severity"`
+	Status   string `json:"status" docstore:"status"`
+	// Count is the number of times the condition triggered while the alarm was active
+	Count         int                    `json:"count" docstore:"count"`
+	StartTime     time.Time              `json:"startTime" docstore:"startTime"`
+	LastTriggered time.Time              `json:"lastTriggered" docstore:"lastTriggered"`
+	Values        map[string]interface{} `json:"values,omitempty" docstore:"values"`
+
+	Acknowledged   bool      `json:"acknowledged" docstore:"acknowledged"`
+	AckBy          string    `json:"ackBy,omitempty" docstore:"ackBy"`
+	AckComment     string    `json:"ackComment,omitempty" docstore:"ackComment"`
+	AckTime        time.Time `json:"ackTime,omitempty" docstore:"ackTime"`
+	ClearedTime    time.Time `json:"clearedTime,omitempty" docstore:"clearedTime"`
+	ClearedBy      string    `json:"clearedBy,omitempty" docstore:"clearedBy"`
+	ClearedComment string    `json:"clearedComment,omitempty" docstore:"clearedComment"`
+}
+
+// NormalizeSeverity validates a severity, defaulting to warning when empty
+func NormalizeSeverity(severity string) (string, error) {
+	if severity == "" {
+		return SeverityWarning, nil
+	}
+	if _, ok := severityRank[severity]; !ok {
+		return "", ErrInvalidSeverity
+	}
+	return severity, nil
+}
+
+// Trigger records a repeated trigger of an active alarm, escalating its severity if needed
+func (a *Alarm) Trigger(severity string, values map[string]interface{}, now time.Time) {
+	a.Count++
+	a.LastTriggered = now
+	a.Values = values
+	if severityRank[severity] > severityRank[a.Severity] {
+		a.Severity = severity
+	}
+}
+
+// Acknowledge marks the alarm as seen by an operator, it stays active until cleared
+func (a *Alarm) Acknowledge(user, comment string, now time.Time) error {
+	if a.Acknowledged {
+		return ErrAlarmAcknowledged
+	}
+	a.Acknowledged = true
+	a.AckBy = user
+	a.AckComment = comment
+	a.AckTime = now
+	return nil
+}
+
+// Clear moves the alarm out of the active state
+func (a *Alarm) Clear(user, comment string, now time.Time) error {
+	if a.Status == StatusCleared {
+		return ErrAlarmCleared
+	}
+	a.Status = StatusCleared
+	a.ClearedBy = user
+	a.ClearedComment = comment
+	a.ClearedTime = now
+	return nil
+}
+
+// AlarmQuery filters alarms, zero values match everything
+type AlarmQuery struct {
+	DeviceID     string
+	Type         string
+	Status       string
+	Severity     string
+	Acknowledged *bool
+}
+
+func (q AlarmQuery) matches(alarm *Alarm) bool {
+	if q.DeviceID != "" && alarm.DeviceID != q.DeviceID {
+		return false
+	}
+	if q.Type != "" && alarm.Type != q.Type {
+		return false
+	}
+	if q.Status != "" && alarm.Status != q.Status {
+		return false
+	}
+	if q.Severity != "" && alarm.Severity != q.Severity {
+		return false
+	}
+	if q.Acknowledged != nil && alarm.Acknowledged != *q.Acknowledged {
+		return false
+	}
+	return true
+}
+
+// Summary counts active alarms, used on device views
+type Summary struct {
+	Count           int            `json:"count"`
+	Unacknowledged  int            `json:"unacknowledged"`
+	HighestSeverity string         `json:"highestSeverity,omitempty"`
+	BySeverity      map[string]int `json:"bySeverity"`
+}
+
+// Summarize builds the summary of the active alarms in the list
+func Summarize(list []*Alarm) *Summary {
+	summary := &Summary{
+		BySeverity: make(map[string]int),
+	}
+	for _, alarm := range list {
+		if alarm.Status != StatusActive {
+			continue
+		}
+		summary.Count++
+		summary.BySeverity[alarm.Severity]++
+		if !alarm.Acknowledged {
+			summary.Unacknowledged++
+		}
+		if severityRank[alarm.Severity] > severityRank[summary.HighestSeverity] {
+			summary.HighestSeverity = alarm.Severity
+		}
+	}
+	return summary
+}
+
+// sortByStartTime orders alarms from the most recent
+func sortByStartTime(list []*Alarm) {
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].StartTime.After(list[j].StartTime)
+	})
+}
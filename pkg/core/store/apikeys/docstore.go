@@ -0,0 +1,75 @@
+package apikeys
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+type apiKeyDocStore struct {
+	coll *docstore.Collection
+}
+
+// NewAPIKeyDocStore create an API key store using a goacloud.dev/docstore collection
+func NewAPIKeyDocStore(coll *docstore.Collection) APIKeyStore {
+	return &apiKeyDocStore{
+		coll: coll,
+	}
+}
+
+func (s *apiKeyDocStore) GetKeyByID(ctx context.Context, id string) (*APIKey, error) {
+	key := &APIKey{ID: id}
+	err := s.coll.Get(ctx, key)
+	if err != nil {
+		code := gcerrors.Code(err)
+		if code == gcerrors.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *apiKeyDocStore) CreateKey(ctx context.Context, key *APIKey) error {
+	return s.coll.Create(ctx, key)
+}
+
+func (s *apiKeyDocStore) DeleteKey(ctx context.Context, projectID, id string) error {
+	key, err := s.GetKeyByID(ctx, id)
+	if err != nil || key == nil || key.ProjectID != projectID {
+		return err
+	}
+	return s.coll.Delete(ctx, key)
+}
+
+func (s *apiKeyDocStore) ListKeysForProject(ctx context.Context, projectID string) ([]*APIKey, error) {
+	iter := s.coll.
+		Query().
+		Where("projectID", "=", projectID).
+		Get(ctx)
+	defer iter.Stop()
+
+	keys := make([]*APIKey, 0)
+	for {
+		key := &APIKey{}
+		err := iter.Next(ctx, key)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (s *apiKeyDocStore) TouchKey(ctx context.Context, id string, used time.Time) error {
+	err := s.coll.Update(ctx, &APIKey{ID: id}, docstore.Mods{"lastUsed": used})
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package apikeys
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type apiKeyLocalStore struct {
+	db *bolt.DB
+}
+
+const apiKeyBucket = "apikeys"
+
+func NewAPIKeyLocalStore(db *bolt.DB) APIKeyStore {
+	return &apiKeyLocalStore{
+		db: db,
+	}
+}
+
+func (s *apiKeyLocalStore) GetKeyByID(ctx context.Context, id string) (*APIKey, error) {
+	var key *APIKey
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		key, err = getKey(tx, id)
+		return err
+	})
+	return key, err
+}
+
+func getKey(tx *bolt.Tx, id string) (*APIKey, error) {
+	buck := tx.Bucket([]byte(apiKeyBucket))
+	if buck == nil {
+		return nil, nil
+	}
+
+	v := buck.Get([]byte(id))
+	if v == nil {
+		return nil, nil
+	}
+
+	key := &APIKey{}
+	return key, json.Unmarshal(v, key)
+}
+
+func putKey(tx *bolt.Tx, key *APIKey) error {
+	buck, err := tx.CreateBucketIfNotExists([]byte(apiKeyBucket))
+	if err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(key)
+	if err != nil {
+		return err
+	}
+
+	return buck.Put([]byte(key.ID), value)
+}
+
+func (s *apiKeyLocalStore) CreateKey(ctx context.Context, key *APIKey) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return putKey(tx, key)
+	})
+}
+
+func (s *apiKeyLocalStore) DeleteKey(ctx context.Context, projectID, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		key, err := getKey(tx, id)
+		if err != nil || key == nil || key.ProjectID != projectID {
+			return err
+		}
+		return tx.Bucket([]byte(apiKeyBucket)).Delete([]byte(id))
+	})
+}
+
+func (s *apiKeyLocalStore) ListKeysForProject(ctx context.Context, projectID string) ([]*APIKey, error) {
+	keys := make([]*APIKey, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(apiKeyBucket))
+		if buck == nil {
+			return nil
+		}
+
+		return buck.ForEach(func(k, v []byte) error {
+			key := &APIKey{}
+			err := json.Unmarshal(v, key)
+			if err != nil {
+				return err
+			}
+			if key.ProjectID == projectID {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+	})
+	return keys, err
+}
+
+func (s *apiKeyLocalStore) TouchKey(ctx context.Context, id string, used time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		key, err := getKey(tx, id)
+		if err != nil || key == nil {
+			return err
+		}
+		key.LastUsed = used
+		return putKey(tx, key)
+	})
+}
@@ -0,0 +1,112 @@
+package apikeys
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIKeyStore keeps the API keys of every project, keys are looked up by ID alone
+// as the token tells the ID but not the project
+type APIKeyStore interface {
+	GetKeyByID(ctx context.Context, id string) (*APIKey, error)
+	CreateKey(ctx context.Context, key *APIKey) error
+	DeleteKey(ctx context.Context, projectID, id string) error
+	ListKeysForProject(ctx context.Context, projectID string) ([]*APIKey, error)
+	// TouchKey records when the key was last used
+	TouchKey(ctx context.Context, id string, used time.Time) error
+}
+
+// Scopes of API keys. read grants reading the project, its devices and their history,
+// write grants changing them and sending commands without reading. Keys combine both to
+// read and write, no scope manages the project, its members or its webhooks
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// APIKey grants access to the routes of a single project. Only a hash of the secret is kept,
+// the token is given once when the key is created
+type APIKey struct {
+	ID        string    `json:"id" docstore:"id"`
+	ProjectID string    `json:"projectID" docstore:"projectID"`
+	Name      string    `json:"name" docstore:"name"`
+	Scopes    []string  `json:"scopes" docstore:"scopes"`
+	Hash      string    `json:"hash,omitempty" docstore:"hash"`
+	Created   time.Time `json:"created" docstore:"created"`
+	LastUsed  time.Time `json:"lastUsed,omitempty" docstore:"lastUsed"`
+}
+
+// secretLength is the number of random bytes of a secret
+const secretLength = 32
+
+// NewKey creates a key with a random secret, returning the token to authenticate with,
+// in the form <id>.<secret>
+func NewKey(projectID, name string, scopes []string) (*APIKey, string, error) {
+	if err := ValidateScopes(scopes); err != nil {
+		return nil, "", err
+	}
+
+	raw := make([]byte, secretLength)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", err
+	}
+	secret := hex.EncodeToString(raw)
+
+	key := &APIKey{
+		ID:        uuid.New().String(),
+		ProjectID: projectID,
+		Name:      name,
+		Scopes:    scopes,
+		Hash:      hashSecret(secret),
+		Created:   time.Now(),
+	}
+	return key, key.ID + "." + secret, nil
+}
+
+// ParseToken splits a token in the ID of its key and the secret
+func ParseToken(token string) (string, string, bool) {
+	i := strings.LastIndex(token, ".")
+	if i <= 0 || i == len(token)-1 {
+		return "", "", false
+	}
+	return token[:i], token[i+1:], true
+}
+
+// ValidateScopes rejects empty and unknown scopes
+func ValidateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("missing scopes, expected %s or %s", ScopeRead, ScopeWrite)
+	}
+	for _, scope := range scopes {
+		if scope != ScopeRead && scope != ScopeWrite {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// Verify tells if the secret is the one of the key, in constant time
+func (k *APIKey) Verify(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(k.Hash)) == 1
+}
+
+// Redacted is a copy of the key without its hash, to be listed
+func (k *APIKey) Redacted() *APIKey {
+	redacted := *k
+	redacted.Hash = ""
+	return &redacted
+}
+
+// Secrets are random, a fast hash is enough to keep them from being read back from the store
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,223 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store/historical"
+	"com.aviebrantz.coap-demo/pkg/core/store/projects"
+	"gocloud.dev/docstore"
+)
+
+// Export formats, NDJSON writes one record per line and JSON a single array of records
+const (
+	FormatNDJSON = "ndjson"
+	FormatJSON   = "json"
+)
+
+// Record kinds, an export starts with a header followed by projects, devices and points
+const (
+	KindHeader  = "header"
+	KindProject = "project"
+	KindDevice  = "device"
+	KindPoint   = "point"
+)
+
+// exportVersion 2 namespaces points by project, version 1 points belong to the project of their device
+const exportVersion = 2
+
+// historyDatatype is the time series datatype of device history
+const historyDatatype = "device"
+
+// Stores are the stores covered by a logical export, of any backend
+type Stores struct {
+	Projects   projects.ProjectStore
+	Devices    devices.DeviceStore
+	TimeSeries historical.TimeSeriesStore
+}
+
+// Record is a line of a logical export, only the field of its kind is set
+type Record struct {
+	Kind    string            `json:"kind"`
+	Header  *Header           `json:"header,omitempty"`
+	Project *projects.Project `json:"project,omitempty"`
+	Device  *devices.Device   `json:"device,omitempty"`
+	Point   *Point            `json:"point,omitempty"`
+}
+
+type Header struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+}
+
+// Point is a history point of a device. Rollups keep their resolution and stats
+type Point struct {
+	ProjectID string `json:"projectID,omitempty"`
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	historical.DataPoint
+}
+
+// Stats counts the records of an export or import. Imports skip the points of series
+// that already had history
+type Stats struct {
+	Projects int `json:"projects"`
+	Devices  int `json:"devices"`
+	Points   int `json:"points"`
+	Skipped  int `json:"skipped,omitempty"`
+}
+
+// Export writes projects, devices and the whole history of every device
+func Export(ctx context.Context, stores Stores, w io.Writer, format string) (*Stats, error) {
+	enc, err := newRecordEncoder(w, format)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{}
+	err = enc.encode(&Record{
+		Kind:   KindHeader,
+		Header: &Header{Version: exportVersion, Created: time.Now().UTC()},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	projectList, err := stores.Projects.ListProjects(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(projectList, func(i, j int) bool { return projectList[i].ID < projectList[j].ID })
+	for _, project := range projectList {
+		delete(project.Data, docstore.DefaultRevisionField)
+		err = enc.encode(&Record{Kind: KindProject, Project: project})
+		if err != nil {
+			return nil, err
+		}
+		stats.Projects++
+	}
+
+	deviceList, err := stores.Devices.ListDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(deviceList, func(i, j int) bool {
+		if deviceList[i].ProjectID != deviceList[j].ProjectID {
+			return deviceList[i].ProjectID < deviceList[j].ProjectID
+		}
+		return deviceList[i].ID < deviceList[j].ID
+	})
+	for _, device := range deviceList {
+		delete(device.Data, docstore.DefaultRevisionField)
+		err = enc.encode(&Record{Kind: KindDevice, Device: device})
+		if err != nil {
+			return nil, err
+		}
+		stats.Devices++
+	}
+
+	for _, device := range deviceList {
+		n, err := exportHistory(ctx, stores.TimeSeries, enc, device.ProjectID, device.ID)
+		if err != nil {
+			return nil, err
+		}
+		stats.Points += n
+	}
+
+	return stats, enc.close()
+}
+
+func exportHistory(ctx context.Context, ts historical.TimeSeriesStore, enc *recordEncoder, projectID, deviceID string) (int, error) {
+	points, err := ts.GetDataPointsInRange(ctx, projectID, historyDatatype, deviceID, time.Time{}, time.Unix(0, math.MaxInt64))
+	if err != nil {
+		return 0, err
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+
+	for _, point := range points {
+		stripBookkeeping(point.Data, projectID, deviceID)
+		err = enc.encode(&Record{
+			Kind:  KindPoint,
+			Point: &Point{ProjectID: projectID, Type: historyDatatype, ID: deviceID, DataPoint: *point},
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(points), nil
+}
+
+// stripBookkeeping drops the fields document stores add to each point,
+// they are written again by the store the export is imported into
+func stripBookkeeping(data map[string]interface{}, projectID, deviceID string) {
+	delete(data, docstore.DefaultRevisionField)
+	delete(data, "id")
+	if data["projectID"] == projectID {
+		delete(data, "projectID")
+	}
+	if data["deviceID"] == deviceID {
+		delete(data, "deviceID")
+	}
+	if data["type"] == historyDatatype {
+		delete(data, "type")
+	}
+	if _, ok := data["time"].(string); ok {
+		delete(data, "time")
+	}
+}
+
+// recordEncoder writes records as NDJSON lines or as the elements of a JSON array
+type recordEncoder struct {
+	w      *bufio.Writer
+	enc    *json.Encoder
+	array  bool
+	writes int
+}
+
+func newRecordEncoder(w io.Writer, format string) (*recordEncoder, error) {
+	if format != "" && format != FormatNDJSON && format != FormatJSON {
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+	buf := bufio.NewWriter(w)
+	return &recordEncoder{
+		w:     buf,
+		enc:   json.NewEncoder(buf),
+		array: format == FormatJSON,
+	}, nil
+}
+
+func (e *recordEncoder) encode(record *Record) error {
+	if e.array {
+		sep := ","
+		if e.writes == 0 {
+			sep = "["
+		}
+		_, err := e.w.WriteString(sep)
+		if err != nil {
+			return err
+		}
+	}
+	e.writes++
+	return e.enc.Encode(record)
+}
+
+func (e *recordEncoder) close() error {
+	if e.array {
+		closing := "]\n"
+		if e.writes == 0 {
+			closing = "[]\n"
+		}
+		_, err := e.w.WriteString(closing)
+		if err != nil {
+			return err
+		}
+	}
+	return e.w.Flush()
+}
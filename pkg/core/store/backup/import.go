@@ -0,0 +1,268 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store/historical"
+	"com.aviebrantz.coap-demo/pkg/core/store/projects"
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
+)
+
+// importSource tags device changes made by imports
+const importSource = devices.SourceSystem + ":import"
+
+// legacyTimeLayout is how time.Time values formatted with %v were stored by the local stores
+const legacyTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// timeFields are top level fields of projects and devices restored as times
+var timeFields = []string{"created", "updated"}
+
+// importState remembers what earlier records of an import decided for later ones
+type importState struct {
+	// deviceProjects has the project of imported devices, for the points of exports written
+	// before points carried one
+	deviceProjects map[string]string
+	// series tells whether the points of a series are imported, only series without
+	// history before the import are, so importing an export twice doesn't duplicate it
+	series map[string]bool
+}
+
+// Import loads an export in either format. Existing projects and devices are updated,
+// points are inserted into series without history. Rollups are restored by time series
+// stores able to downsample, other stores get them as raw points holding their averages
+func Import(ctx context.Context, stores Stores, r io.Reader) (*Stats, error) {
+	ctx = devices.WithSource(ctx, importSource)
+	buf := bufio.NewReader(r)
+	dec := json.NewDecoder(buf)
+
+	array, err := isArray(buf)
+	if err != nil {
+		return nil, err
+	}
+	if array {
+		_, err = dec.Token()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	stats := &Stats{}
+	state := &importState{
+		deviceProjects: make(map[string]string),
+		series:         make(map[string]bool),
+	}
+	for n := 1; ; n++ {
+		if array && !dec.More() {
+			break
+		}
+
+		record := &Record{}
+		err = dec.Decode(record)
+		if err == io.EOF && !array {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("record %d: %v", n, err)
+		}
+
+		err = importRecord(ctx, stores, record, stats, state)
+		if err != nil {
+			return stats, fmt.Errorf("record %d: %v", n, err)
+		}
+	}
+	return stats, nil
+}
+
+// isArray peeks at the first non blank byte of the input
+func isArray(r *bufio.Reader) (bool, error) {
+	for i := 1; ; i++ {
+		b, err := r.Peek(i)
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		switch b[i-1] {
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		return b[i-1] == '[', nil
+	}
+}
+
+// importRecord loads a record
+func importRecord(ctx context.Context, stores Stores, record *Record, stats *Stats, state *importState) error {
+	switch record.Kind {
+	case KindHeader:
+		if record.Header == nil || record.Header.Version > exportVersion {
+			return fmt.Errorf("unsupported export version")
+		}
+		return nil
+	case KindProject:
+		if record.Project == nil {
+			return fmt.Errorf("missing project")
+		}
+		err := importProject(ctx, stores.Projects, record.Project)
+		if err == nil {
+			stats.Projects++
+		}
+		return err
+	case KindDevice:
+		if record.Device == nil {
+			return fmt.Errorf("missing device")
+		}
+		err := importDevice(ctx, stores.Devices, record.Device)
+		if err == nil {
+			stats.Devices++
+			state.deviceProjects[record.Device.ID] = record.Device.ProjectID
+		}
+		return err
+	case KindPoint:
+		point := record.Point
+		if point == nil {
+			return fmt.Errorf("missing point")
+		}
+		if point.Data == nil {
+			point.Data = make(map[string]interface{})
+		}
+		if point.ProjectID == "" {
+			point.ProjectID = state.deviceProjects[point.ID]
+		}
+
+		key := tenancy.Key(point.ProjectID, point.Type+"/"+point.ID)
+		importing, ok := state.series[key]
+		if !ok {
+			existing, err := stores.TimeSeries.GetDataPointsInRange(ctx, point.ProjectID, point.Type, point.ID, time.Time{}, time.Unix(0, math.MaxInt64))
+			if err != nil {
+				return err
+			}
+			importing = len(existing) == 0
+			state.series[key] = importing
+		}
+		if !importing {
+			stats.Skipped++
+			return nil
+		}
+
+		err := importPoint(ctx, stores.TimeSeries, point)
+		if err == nil {
+			stats.Points++
+		}
+		return err
+	}
+	return fmt.Errorf("unknown record kind %q", record.Kind)
+}
+
+// importPoint inserts a raw point or restores a rollup with its stats. Stores unable to
+// downsample get rollups as raw points holding their averages
+func importPoint(ctx context.Context, ts historical.TimeSeriesStore, point *Point) error {
+	rollupStore, ok := ts.(historical.RollupStore)
+	if !ok || point.Resolution == "" || len(point.Stats) == 0 {
+		return ts.InsertDataPoint(ctx, point.ProjectID, point.Type, point.ID, point.Time, point.Data)
+	}
+
+	resolution, ok := historical.ResolutionByName(point.Resolution)
+	if !ok {
+		return fmt.Errorf("unknown rollup resolution %q", point.Resolution)
+	}
+	return rollupStore.RestoreRollups(ctx, point.ProjectID, point.Type, point.ID, resolution, []*historical.Rollup{{
+		Time:   point.Time,
+		Fields: point.Stats,
+	}})
+}
+
+func importProject(ctx context.Context, store projects.ProjectStore, project *projects.Project) error {
+	err := tenancy.ValidateID(project.ID)
+	if err != nil {
+		return err
+	}
+
+	err = store.CreateProject(ctx, project.ID)
+	if err != nil && err != projects.ErrProjectExists {
+		return err
+	}
+
+	data := restoreTimes(project.Data)
+	delete(data, "projectID")
+	delete(data, "updated")
+	if len(data) == 0 {
+		return nil
+	}
+	return store.UpdateProject(ctx, project.ID, data)
+}
+
+func importDevice(ctx context.Context, store devices.DeviceStore, device *devices.Device) error {
+	err := tenancy.ValidateID(device.ID)
+	if err != nil {
+		return err
+	}
+
+	existing, err := store.GetDeviceByID(ctx, device.ProjectID, device.ID)
+	if err != nil {
+		return err
+	}
+	// Created first so the exported created time is kept by the update
+	if existing == nil {
+		err = store.CreateDevice(ctx, device.ProjectID, device.ID, make(map[string]interface{}))
+		if err != nil {
+			return err
+		}
+	}
+
+	data := restoreTimes(device.Data)
+	updated, ok := data["updated"].(time.Time)
+	if !ok {
+		updated = time.Now()
+	}
+	delete(data, "updated")
+	err = store.UpsertDevice(ctx, device.ProjectID, device.ID, updated, data)
+	if err != nil || device.Metadata == nil {
+		return err
+	}
+
+	var current *devices.Metadata
+	if existing != nil {
+		current = existing.Metadata
+	}
+	_, err = store.UpdateDeviceMetadata(ctx, device.ProjectID, device.ID, devices.AnyRevision, devices.ReplaceMetadata(current, device.Metadata))
+	return err
+}
+
+// restoreTimes turns the time fields JSON left as text back into times
+func restoreTimes(data map[string]interface{}) map[string]interface{} {
+	if data == nil {
+		data = make(map[string]interface{})
+	}
+	for _, field := range timeFields {
+		value, ok := data[field].(string)
+		if !ok {
+			continue
+		}
+		if t, ok := parseTime(value); ok {
+			data[field] = t
+		}
+	}
+	return data
+}
+
+func parseTime(value string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		return t, true
+	}
+	// Drop the monotonic clock reading, like m=+0.007840407
+	if i := strings.Index(value, " m="); i > 0 {
+		value = value[:i]
+	}
+	t, err = time.Parse(legacyTimeLayout, value)
+	return t, err == nil
+}
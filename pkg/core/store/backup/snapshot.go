@@ -0,0 +1,27 @@
+package backup
+
+import (
+	"errors"
+	"io"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrSnapshotUnsupported = errors.New("snapshots are only supported by local storage")
+
+// Snapshotter streams a consistent copy of the whole database
+type Snapshotter interface {
+	Snapshot(w io.Writer) (int64, error)
+}
+
+// WriteSnapshot copies the database file as seen by a single read transaction, so
+// writers keep going while it streams. It returns the number of bytes written
+func WriteSnapshot(db *bolt.DB, w io.Writer) (int64, error) {
+	var n int64
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		n, err = tx.WriteTo(w)
+		return err
+	})
+	return n, err
+}
@@ -0,0 +1,135 @@
+package devices
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+
+	"gocloud.dev/docstore"
+)
+
+// Change sources, qualified like gateway:coap, api:key:<id> or system:import
+const (
+	SourceGateway = "gateway"
+	SourceAPI     = "api"
+	SourceSystem  = "system"
+)
+
+// unchangedFields are bumped by every write or identify the document, they are left out of change entries
+var unchangedFields = map[string]bool{
+	"updated":                     true,
+	revisionField:                 true,
+	keyField:                      true,
+	docstore.DefaultRevisionField: true,
+}
+
+// Change is an append-only record of a device mutation
+type Change struct {
+	ID        string `json:"id" docstore:"id"`
+	DeviceID  string `json:"deviceID" docstore:"deviceID"`
+	ProjectID string `json:"projectID,omitempty" docstore:"projectID"`
+	// Revision is the device revision the mutation produced, 0 once deleted
+	Revision int64        `json:"revision" docstore:"revision"`
+	Source   string       `json:"source" docstore:"source"`
+	Time     time.Time    `json:"time" docstore:"time"`
+	Changes  []PathChange `json:"changes" docstore:"changes"`
+	// Nanos orders documents in stores without sub-second time comparisons
+	Nanos int64 `json:"-" docstore:"t"`
+}
+
+// PathChange is a changed state value, Old is nil for new paths and New for removed ones
+type PathChange struct {
+	Path string      `json:"path" docstore:"path"`
+	Old  interface{} `json:"old" docstore:"old"`
+	New  interface{} `json:"new" docstore:"new"`
+}
+
+type sourceKey struct{}
+
+// WithSource tags the device mutations made with ctx
+func WithSource(ctx context.Context, source string) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// SourceFromContext is the source set by WithSource, system when unset
+func SourceFromContext(ctx context.Context) string {
+	if source, ok := ctx.Value(sourceKey{}).(string); ok && source != "" {
+		return source
+	}
+	return SourceSystem
+}
+
+func newChange(ctx context.Context, deviceID, projectID string, revision int64, changes []PathChange) *Change {
+	now := time.Now()
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return &Change{
+		DeviceID:  deviceID,
+		ProjectID: projectID,
+		Revision:  revision,
+		Source:    SourceFromContext(ctx),
+		Time:      now,
+		Changes:   changes,
+		Nanos:     now.UnixNano(),
+	}
+}
+
+// diffState compares two states path by path, nested maps are walked down to their values
+func diffState(old, new map[string]interface{}) []PathChange {
+	oldValues := make(map[string]interface{})
+	flattenState("", old, oldValues)
+	newValues := make(map[string]interface{})
+	flattenState("", new, newValues)
+
+	changes := make([]PathChange, 0)
+	for path, value := range newValues {
+		previous, ok := oldValues[path]
+		if !ok || !reflect.DeepEqual(previous, value) {
+			changes = append(changes, PathChange{Path: path, Old: previous, New: value})
+		}
+	}
+	for path, previous := range oldValues {
+		if _, ok := newValues[path]; !ok {
+			changes = append(changes, PathChange{Path: path, Old: previous})
+		}
+	}
+	return changes
+}
+
+func flattenState(prefix string, state map[string]interface{}, values map[string]interface{}) {
+	for k, v := range state {
+		if prefix == "" && unchangedFields[k] {
+			continue
+		}
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		if nested, ok := v.(map[string]interface{}); ok {
+			flattenState(path, nested, values)
+			continue
+		}
+		values[path] = v
+	}
+}
+
+// changeKey orders local change entries by time, the sequence keeps entries of a same instant apart
+func changeKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(changeNanos(t)))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// changeNanos clamps times out of the int64 nanosecond range, like unbounded query limits
+func changeNanos(t time.Time) int64 {
+	if t.Before(time.Unix(0, 0)) {
+		return 0
+	}
+	if t.After(time.Unix(0, math.MaxInt64)) {
+		return math.MaxInt64
+	}
+	return t.UnixNano()
+}
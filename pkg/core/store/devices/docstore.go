@@ -3,28 +3,37 @@ package devices
 import (
 	"context"
 	"io"
-	"log"
+	"strconv"
 	"time"
 
-	"github.com/jeremywohl/flatten"
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
+	"github.com/google/uuid"
 	"gocloud.dev/docstore"
 	"gocloud.dev/gcerrors"
 )
 
+// maxUpdateAttempts bounds the retries of an update racing with other writers
+const maxUpdateAttempts = 5
+
 type deviceDocStore struct {
-	devicesColl *docstore.Collection
+	devicesColl   *docstore.Collection
+	changesColl   *docstore.Collection
+	snapshotsColl *docstore.Collection
 }
 
-// NewDeviceDocStore create a device store using a goacloud.dev/docstore collection
-func NewDeviceDocStore(devicesColl *docstore.Collection) DeviceStore {
+// NewDeviceDocStore create a device store using goacloud.dev/docstore collections,
+// change entries and snapshots are written after each mutation succeeds
+func NewDeviceDocStore(devicesColl, changesColl, snapshotsColl *docstore.Collection) DeviceStore {
 	return &deviceDocStore{
-		devicesColl: devicesColl,
+		devicesColl:   devicesColl,
+		changesColl:   changesColl,
+		snapshotsColl: snapshotsColl,
 	}
 }
 
-func (s *deviceDocStore) GetDeviceByID(ctx context.Context, id string) (*Device, error) {
+func (s *deviceDocStore) GetDeviceByID(ctx context.Context, projectID, id string) (*Device, error) {
 	deviceDoc := make(map[string]interface{})
-	deviceDoc["deviceID"] = id
+	deviceDoc[keyField] = tenancy.Key(projectID, id)
 	err := s.devicesColl.Get(ctx, deviceDoc)
 	if err != nil {
 		code := gcerrors.Code(err)
@@ -34,67 +43,283 @@ func (s *deviceDocStore) GetDeviceByID(ctx context.Context, id string) (*Device,
 		return nil, err
 	}
 
-	projectID := ""
-	if value, ok := deviceDoc["projectID"]; ok {
-		projectID = value.(string)
-	}
-
-	device := &Device{
-		ID:        id,
-		ProjectID: projectID,
-		Data:      deviceDoc,
-	}
-
-	return device, nil
+	return newDeviceFromDoc(deviceDoc), nil
 }
 
-func (s *deviceDocStore) CreateDevice(ctx context.Context, id string, data map[string]interface{}) error {
+func (s *deviceDocStore) CreateDevice(ctx context.Context, projectID, id string, data map[string]interface{}) error {
 	data["created"] = time.Now()
 	data["deviceID"] = id
+	setProjectField(data, projectID)
+	data[keyField] = tenancy.Key(projectID, id)
 	return s.devicesColl.Create(ctx, data)
 }
 
-func (s *deviceDocStore) UpsertDevice(ctx context.Context, id string, updated time.Time, updates map[string]interface{}) error {
+func (s *deviceDocStore) UpsertDevice(ctx context.Context, projectID, id string, updated time.Time, updates map[string]interface{}) error {
+	_, err := s.UpdateDevice(ctx, projectID, id, AnyRevision, updated, updates)
+	return err
+}
+
+// UpdateDevice reads the device then updates it guarded by the docstore revision of the read,
+// so concurrent writers fail the precondition instead of overwriting each other.
+// Unconditional updates retry on such races
+func (s *deviceDocStore) UpdateDevice(ctx context.Context, projectID, id string, revision int64, updated time.Time, updates map[string]interface{}) (*Device, error) {
+	for attempt := 0; ; attempt++ {
+		device, err := s.GetDeviceByID(ctx, projectID, id)
+		if err != nil {
+			return nil, err
+		}
+
+		current := int64(0)
+		existed := device != nil
+		if existed {
+			current = device.Revision
+		}
+		err = checkRevision(id, revision, current, existed)
+		if err != nil {
+			return nil, err
+		}
 
-	device, err := s.GetDeviceByID(ctx, id)
+		if device == nil {
+			err = s.CreateDevice(ctx, projectID, id, make(map[string]interface{}))
+			if gcerrors.Code(err) == gcerrors.AlreadyExists && attempt < maxUpdateAttempts {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			device, err = s.GetDeviceByID(ctx, projectID, id)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Mods only touch top level fields, nested updates are merged with the stored value.
+		// Not every docstore driver can create intermediate maps on dotted field paths.
+		mods := docstore.Mods{}
+		for k, v := range updates {
+			if k == keyField || k == "projectID" || k == metadataField {
+				continue
+			}
+			mods[docstore.FieldPath(k)] = mergeValue(device.Data[k], v)
+		}
+		mods["updated"] = updated
+		mods[revisionField] = current + 1
+
+		err = s.devicesColl.Actions().Update(device.Data, mods).Do(ctx)
+		if gcerrors.Code(err) == gcerrors.FailedPrecondition && attempt < maxUpdateAttempts {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		updatedDevice, err := s.GetDeviceByID(ctx, projectID, id)
+		if err != nil {
+			return nil, err
+		}
+		var oldData map[string]interface{}
+		if existed {
+			oldData = device.Data
+		}
+		return updatedDevice, s.recordChange(ctx, projectID, oldData, updatedDevice.Data, updatedDevice)
+	}
+}
+
+// MoveDevice creates the device in the target namespace, then deletes the source document
+// guarded by the docstore revision of the read. The copy is removed again when that fails
+func (s *deviceDocStore) MoveDevice(ctx context.Context, fromProjectID, id, toProjectID string, revision int64) (*Device, error) {
+	device, err := s.GetDeviceByID(ctx, fromProjectID, id)
+	if err != nil || device == nil {
+		return nil, err
+	}
+	err = checkRevision(id, revision, device.Revision, true)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if fromProjectID == toProjectID {
+		return device, nil
+	}
+
+	data := make(map[string]interface{}, len(device.Data))
+	for k, v := range device.Data {
+		if k != docstore.DefaultRevisionField {
+			data[k] = v
+		}
+	}
+	data["updated"] = time.Now()
+	data[revisionField] = device.Revision + 1
+	setProjectField(data, toProjectID)
+	data[keyField] = tenancy.Key(toProjectID, id)
+	if device.Metadata != nil {
+		data[metadataField] = metadataValue(device.Metadata)
 	}
 
-	if device == nil {
-		err = s.CreateDevice(ctx, id, make(map[string]interface{}))
+	err = s.devicesColl.Create(ctx, data)
+	if gcerrors.Code(err) == gcerrors.AlreadyExists {
+		return nil, ErrDeviceExists
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.devicesColl.Delete(ctx, device.Data)
+	if err != nil {
+		s.devicesColl.Delete(ctx, map[string]interface{}{keyField: data[keyField]})
+		return nil, err
+	}
+
+	moved, err := s.GetDeviceByID(ctx, toProjectID, id)
+	if err != nil || moved == nil {
+		return nil, err
+	}
+	err = s.recordChange(ctx, fromProjectID, device.Data, nil, device)
+	if err != nil {
+		return nil, err
+	}
+	return moved, s.recordChange(ctx, toProjectID, nil, moved.Data, moved)
+}
+
+// UpdateDeviceMetadata replaces the metadata field guarded by the docstore revision of the read,
+// retrying like UpdateDevice
+func (s *deviceDocStore) UpdateDeviceMetadata(ctx context.Context, projectID, id string, revision int64, patch *MetadataPatch) (*Device, error) {
+	err := patch.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		device, err := s.GetDeviceByID(ctx, projectID, id)
+		if err != nil || device == nil {
+			return nil, err
+		}
+		err = checkRevision(id, revision, device.Revision, true)
 		if err != nil {
-			return err
+			return nil, err
+		}
+
+		// A nil mod removes the field
+		var value interface{}
+		if metadata := patch.Apply(device.Metadata); metadata != nil {
+			value = metadataValue(metadata)
+		}
+		mods := docstore.Mods{
+			metadataField: value,
+			revisionField: device.Revision + 1,
+		}
+
+		err = s.devicesColl.Actions().Update(device.Data, mods).Do(ctx)
+		if gcerrors.Code(err) == gcerrors.FailedPrecondition && attempt < maxUpdateAttempts {
+			continue
 		}
-		device, err = s.GetDeviceByID(ctx, id)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		return s.GetDeviceByID(ctx, projectID, id)
 	}
+}
 
-	nestedUpdates, err := flatten.Flatten(updates, "", flatten.DotStyle)
+func (s *deviceDocStore) DeleteDevice(ctx context.Context, projectID, id string) error {
+	device, err := s.GetDeviceByID(ctx, projectID, id)
+	if err != nil || device == nil {
+		return err
+	}
+
+	err = s.devicesColl.Delete(ctx, device.Data)
 	if err != nil {
-		log.Printf("Invalid msg format :%v", err)
 		return err
 	}
+	return s.recordChange(ctx, projectID, device.Data, nil, device)
+}
 
-	nestedUpdates["updated"] = updated
-	mods := docstore.Mods{}
-	for k, v := range nestedUpdates {
-		mods[docstore.FieldPath(k)] = v
+// recordChange appends the difference between two states to the change log of the device
+// in the project, a nil state being a missing device
+func (s *deviceDocStore) recordChange(ctx context.Context, projectID string, old, new map[string]interface{}, device *Device) error {
+	changes := diffState(old, new)
+	if len(changes) == 0 {
+		return nil
 	}
 
-	err = s.devicesColl.Actions().Update(device.Data, mods).Do(ctx)
+	revision := int64(0)
+	if new != nil {
+		revision = device.Revision
+	}
+	change := newChange(ctx, device.ID, projectID, revision, changes)
+	change.ID = uuid.New().String()
+	err := s.changesColl.Create(ctx, change)
 	if err != nil {
-		log.Printf("err update device :%v", err)
+		return err
+	}
+
+	var oldDevice, newDevice *Device
+	if old != nil {
+		oldDevice = newDeviceFromDoc(old)
+	}
+	if new != nil {
+		newDevice = device
+	}
+	for _, snap := range snapshotsDue(projectID, oldDevice, newDevice, change.Time) {
+		snap.ID = uuid.New().String()
+		err = s.snapshotsColl.Create(ctx, snap)
+		if err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func (s *deviceDocStore) RegisterDeviceToProject(ctx context.Context, deviceID, projectID string) error {
-	updates := make(map[string]interface{})
-	updates["projectID"] = projectID
-	return s.UpsertDevice(ctx, deviceID, time.Now(), updates)
+// DeviceStateAt replays the change entries following the last snapshot taken before asOf
+func (s *deviceDocStore) DeviceStateAt(ctx context.Context, projectID, id string, asOf time.Time) (*Device, error) {
+	iter := s.snapshotsColl.
+		Query().
+		Where("projectID", "=", projectID).
+		Where("deviceID", "=", id).
+		Where("t", "<=", changeNanos(asOf)).
+		OrderBy("t", docstore.Descending).
+		Limit(1).
+		Get(ctx)
+	defer iter.Stop()
+
+	var base *snapshot
+	start := time.Time{}
+	snap := &snapshot{}
+	err := iter.Next(ctx, snap)
+	if err == nil {
+		base = snap
+		start = snap.Time.Add(time.Nanosecond)
+	} else if err != io.EOF {
+		return nil, err
+	}
+
+	changes, err := s.ListDeviceChanges(ctx, projectID, id, start, asOf)
+	if err != nil {
+		return nil, err
+	}
+	return rebuildState(projectID, id, base, changes), nil
+}
+
+func (s *deviceDocStore) ListDeviceChanges(ctx context.Context, projectID, id string, start, end time.Time) ([]*Change, error) {
+	iter := s.changesColl.
+		Query().
+		Where("projectID", "=", projectID).
+		Where("deviceID", "=", id).
+		Where("t", ">=", changeNanos(start)).
+		Where("t", "<=", changeNanos(end)).
+		OrderBy("t", docstore.Ascending).
+		Get(ctx)
+	defer iter.Stop()
+
+	changes := make([]*Change, 0)
+	for {
+		change := &Change{}
+		err := iter.Next(ctx, change)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
 }
 
 func (s *deviceDocStore) ListDevicesForProject(ctx context.Context, projectID string) ([]*Device, error) {
@@ -102,19 +327,161 @@ func (s *deviceDocStore) ListDevicesForProject(ctx context.Context, projectID st
 		Query().
 		Where(docstore.FieldPath("projectID"), "=", projectID).
 		Get(ctx)
+	return collectDevices(ctx, iter)
+}
+
+// QueryDevicesForProject pushes the filters and label equalities the docstore can evaluate down to the query,
+// sorting, paging and the remaining filters are applied on the results
+func (s *deviceDocStore) QueryDevicesForProject(ctx context.Context, projectID string, query DeviceQuery) (*DevicePage, error) {
+	err := query.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	q := s.devicesColl.
+		Query().
+		Where(docstore.FieldPath("projectID"), "=", projectID)
+	for _, f := range query.Filters {
+		value, ok := whereValue(f)
+		if ok {
+			q = q.Where(docstore.FieldPath(f.Path), f.Op, value)
+		}
+	}
+	for _, r := range query.Labels {
+		if r.Op == SelectEquals || (r.Op == SelectIn && len(r.Values) == 1) {
+			q = q.Where(docstore.FieldPath(metadataField+".labels."+r.Key), "=", r.Values[0])
+		}
+	}
+
+	list, err := collectDevices(ctx, q.Get(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return applyQuery(list, query)
+}
+
+// whereValue types a filter value for a docstore Where clause. Only comparisons whose
+// stored type is unambiguous are pushed down: numeric ranges and equality on plain text
+func whereValue(f Filter) (interface{}, bool) {
+	number, err := strconv.ParseFloat(f.Value, 64)
+	switch f.Op {
+	case "<", "<=", ">", ">=":
+		return number, err == nil
+	case "=":
+		if err != nil && f.Value != "true" && f.Value != "false" {
+			return f.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (s *deviceDocStore) ListDeviceProjects(ctx context.Context, id string) ([]string, error) {
+	list, err := collectDevices(ctx, s.devicesColl.
+		Query().
+		Where("deviceID", "=", id).
+		Get(ctx, keyField, "projectID"))
+	if err != nil {
+		return nil, err
+	}
+
+	projects := make([]string, 0, len(list))
+	for _, device := range list {
+		if device.ProjectID != tenancy.Unassigned {
+			projects = append(projects, device.ProjectID)
+		}
+	}
+	return projects, nil
+}
+
+func (s *deviceDocStore) ListDevices(ctx context.Context) ([]*Device, error) {
+	iter := s.devicesColl.
+		Query().
+		Get(ctx)
+	return collectDevices(ctx, iter)
+}
+
+func collectDevices(ctx context.Context, iter *docstore.DocumentIterator) ([]*Device, error) {
 	defer iter.Stop()
 
 	devices := make([]*Device, 0)
 	for {
-		device := &Device{}
-		err := iter.Next(ctx, device)
+		deviceDoc := make(map[string]interface{})
+		err := iter.Next(ctx, deviceDoc)
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return nil, nil
-		} else {
-			devices = append(devices, device)
+			return nil, err
 		}
+		devices = append(devices, newDeviceFromDoc(deviceDoc))
 	}
 	return devices, nil
 }
+
+func newDeviceFromDoc(deviceDoc map[string]interface{}) *Device {
+	id, _ := deviceDoc["deviceID"].(string)
+	projectID := tenancy.Unassigned
+	if key, ok := deviceDoc[keyField].(string); ok {
+		projectID, _, _ = tenancy.SplitKey(key)
+	}
+	// Metadata is kept apart from the state, the document still identifies the device
+	metadata := parseMetadata(deviceDoc[metadataField])
+	delete(deviceDoc, metadataField)
+	return &Device{
+		ID:        id,
+		ProjectID: projectID,
+		Revision:  revisionValue(deviceDoc[revisionField]),
+		Data:      deviceDoc,
+		Metadata:  metadata,
+	}
+}
+
+// mergeValue deep merges an update into a stored value, nested maps are merged key by key
+func mergeValue(stored, update interface{}) interface{} {
+	storedMap, ok := stored.(map[string]interface{})
+	if !ok {
+		return update
+	}
+	updateMap, ok := update.(map[string]interface{})
+	if !ok {
+		return update
+	}
+
+	merged := make(map[string]interface{}, len(storedMap)+len(updateMap))
+	for k, v := range storedMap {
+		merged[k] = v
+	}
+	for k, v := range updateMap {
+		merged[k] = mergeValue(storedMap[k], v)
+	}
+	return merged
+}
+
+func (s *deviceDocStore) DeleteChangesForProject(ctx context.Context, projectID string) error {
+	err := deleteProjectDocs(ctx, s.changesColl, projectID, func() interface{} { return &Change{} })
+	if err != nil {
+		return err
+	}
+	return deleteProjectDocs(ctx, s.snapshotsColl, projectID, func() interface{} { return &snapshot{} })
+}
+
+// deleteProjectDocs deletes the documents of the project in the collection, decoded by newDoc
+func deleteProjectDocs(ctx context.Context, coll *docstore.Collection, projectID string, newDoc func() interface{}) error {
+	iter := coll.
+		Query().
+		Where("projectID", "=", projectID).
+		Get(ctx, "id")
+	defer iter.Stop()
+
+	actions := coll.Actions()
+	for {
+		doc := newDoc()
+		err := iter.Next(ctx, doc)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		actions = actions.Delete(doc)
+	}
+	return actions.Do(ctx)
+}
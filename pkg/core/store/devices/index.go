@@ -0,0 +1,227 @@
+package devices
+
+import (
+	"encoding/json"
+	"strings"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
+	bolt "go.etcd.io/bbolt"
+)
+
+// deviceIndexBucket holds one nested bucket per device ID, keyed by the projects having a device with it
+const deviceIndexBucket = "index_device_projects"
+
+// indexDevice adds or removes a project of a device ID inside the caller transaction,
+// unassigned devices are left out
+func indexDevice(tx *bolt.Tx, projectID, id string, present bool) error {
+	if projectID == tenancy.Unassigned {
+		return nil
+	}
+
+	index, err := tx.CreateBucketIfNotExists([]byte(deviceIndexBucket))
+	if err != nil {
+		return err
+	}
+
+	if present {
+		projects, err := index.CreateBucketIfNotExists([]byte(id))
+		if err != nil {
+			return err
+		}
+		return projects.Put([]byte(projectID), []byte{})
+	}
+
+	projects := index.Bucket([]byte(id))
+	if projects == nil {
+		return nil
+	}
+	err = projects.Delete([]byte(projectID))
+	if err != nil {
+		return err
+	}
+	// Drop IDs left without projects so deleted devices don't linger in the index
+	if k, _ := projects.Cursor().First(); k == nil {
+		return index.DeleteBucket([]byte(id))
+	}
+	return nil
+}
+
+// deviceBucketID returns the project and ID of a device bucket name
+func deviceBucketID(name string) (projectID, id string, ok bool) {
+	projectID, rest, ok := tenancy.SplitKey(name)
+	if !ok || !strings.HasPrefix(rest, deviceBucketPrefix) {
+		return "", "", false
+	}
+	return projectID, strings.TrimPrefix(rest, deviceBucketPrefix), true
+}
+
+// EnsureDeviceIndex builds the device to projects index of databases written before it existed,
+// it must run before the store writes as writes create the index bucket
+func EnsureDeviceIndex(db *bolt.DB) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		if tx.Bucket([]byte(deviceIndexBucket)) != nil {
+			return nil
+		}
+		_, err := rebuildDeviceIndex(tx)
+		return err
+	})
+}
+
+// RebuildDeviceIndex recreates the device to projects index from the device buckets.
+// It returns the number of indexed devices
+func RebuildDeviceIndex(db *bolt.DB) (int, error) {
+	count := 0
+	err := db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(deviceIndexBucket))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		count, err = rebuildDeviceIndex(tx)
+		return err
+	})
+	return count, err
+}
+
+func rebuildDeviceIndex(tx *bolt.Tx) (int, error) {
+	// The bucket is created even without devices, its presence marks the index as built
+	_, err := tx.CreateBucketIfNotExists([]byte(deviceIndexBucket))
+	if err != nil {
+		return 0, err
+	}
+
+	type membership struct{ projectID, id string }
+	found := make([]membership, 0)
+	err = tx.ForEach(func(name []byte, buck *bolt.Bucket) error {
+		projectID, id, ok := deviceBucketID(string(name))
+		if ok && projectID != tenancy.Unassigned {
+			found = append(found, membership{projectID: projectID, id: id})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Buckets can't be created while iterating the root, so entries are written afterwards
+	for _, m := range found {
+		err = indexDevice(tx, m.projectID, m.id, true)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(found), nil
+}
+
+// NamespaceDevices moves device, change log and snapshot buckets written before stores were
+// namespaced by project to the namespace of their project, then rebuilds the index.
+// Change entries and snapshots are split by the project they were recorded in.
+// It returns the number of moved devices
+func NamespaceDevices(db *bolt.DB) (int, error) {
+	type legacyDevice struct{ id, projectID string }
+	legacy := make([]legacyDevice, 0)
+	logs := make([]string, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, buck *bolt.Bucket) error {
+			n := string(name)
+			if strings.Contains(n, tenancy.Separator) {
+				return nil
+			}
+			switch {
+			case strings.HasPrefix(n, deviceBucketPrefix):
+				legacy = append(legacy, legacyDevice{
+					id:        strings.TrimPrefix(n, deviceBucketPrefix),
+					projectID: string(buck.Get([]byte("projectID"))),
+				})
+			case strings.HasPrefix(n, changeBucketPrefix), strings.HasPrefix(n, snapshotBucketPrefix):
+				logs = append(logs, n)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	projectOf := make(map[string]string, len(legacy))
+	for _, d := range legacy {
+		projectOf[deviceBucketPrefix+d.id] = d.projectID
+	}
+	moved, err := tenancy.RenameBuckets(db, func(name string) (string, bool) {
+		projectID, ok := projectOf[name]
+		if !ok {
+			return "", false
+		}
+		return tenancy.Key(projectID, name), true
+	})
+	if err != nil {
+		return moved, err
+	}
+
+	for _, name := range logs {
+		err = db.Update(func(tx *bolt.Tx) error {
+			return splitLog(tx, name)
+		})
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	_, err = RebuildDeviceIndex(db)
+	return moved, err
+}
+
+// splitLog moves the entries of a legacy change log or snapshot bucket to the namespaces
+// of the projects they were recorded in
+func splitLog(tx *bolt.Tx, name string) error {
+	src := tx.Bucket([]byte(name))
+	if src == nil {
+		return nil
+	}
+
+	type entry struct {
+		projectID  string
+		key, value []byte
+	}
+	entries := make([]entry, 0)
+	err := src.ForEach(func(k, v []byte) error {
+		var recorded struct {
+			ProjectID string                 `json:"projectID"`
+			Data      map[string]interface{} `json:"data"`
+		}
+		if json.Unmarshal(v, &recorded) != nil {
+			return nil
+		}
+		// Snapshots taken before they carried a project have it in their state
+		projectID := recorded.ProjectID
+		if projectID == "" {
+			projectID, _ = recorded.Data["projectID"].(string)
+		}
+		entries = append(entries, entry{
+			projectID: projectID,
+			key:       append([]byte{}, k...),
+			value:     append([]byte{}, v...),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		dst, err := tx.CreateBucketIfNotExists([]byte(tenancy.Key(e.projectID, name)))
+		if err != nil {
+			return err
+		}
+		if src.Sequence() > dst.Sequence() {
+			err = dst.SetSequence(src.Sequence())
+			if err != nil {
+				return err
+			}
+		}
+		err = dst.Put(e.key, e.value)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.DeleteBucket([]byte(name))
+}
@@ -1,12 +1,15 @@
 package devices
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
-	"log"
-	"strings"
+	"strconv"
 	"time"
 
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
 	"github.com/jeremywohl/flatten"
 	"github.com/nqd/flat"
 	bolt "go.etcd.io/bbolt"
@@ -17,7 +20,11 @@ type deviceLocalStore struct {
 	db *bolt.DB
 }
 
-const deviceBucketPrefix = "device_"
+const (
+	deviceBucketPrefix   = "device_"
+	changeBucketPrefix   = "changes_"
+	snapshotBucketPrefix = "snapshots_"
+)
 
 func NewDeviceLocalStore(db *bolt.DB) DeviceStore {
 	return &deviceLocalStore{
@@ -25,46 +32,61 @@ func NewDeviceLocalStore(db *bolt.DB) DeviceStore {
 	}
 }
 
-func (s *deviceLocalStore) GetDeviceByID(ctx context.Context, id string) (*Device, error) {
-	device := &Device{}
-	err := s.db.View(func(tx *bolt.Tx) error {
-		buck := tx.Bucket([]byte(deviceBucketPrefix + id))
-		if buck == nil {
-			device = nil
-			return nil
-		}
+func deviceBucket(projectID, id string) []byte {
+	return []byte(tenancy.Key(projectID, deviceBucketPrefix+id))
+}
 
-		data := make(map[string]interface{})
-		cur := buck.Cursor()
-		for k, v := cur.First(); k != nil; k, v = cur.Next() {
-			data[string(k)] = string(v)
-		}
+func (s *deviceLocalStore) GetDeviceByID(ctx context.Context, projectID, id string) (*Device, error) {
+	var device *Device
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		device, err = readDevice(tx, projectID, id)
+		return err
+	})
 
-		nestedData, err := flat.Unflatten(data, &flat.Options{
-			Delimiter: "/",
-		})
+	return device, err
+}
 
-		if err != nil {
-			return err
-		}
+func readDevice(tx *bolt.Tx, projectID, id string) (*Device, error) {
+	buck := tx.Bucket(deviceBucket(projectID, id))
+	if buck == nil {
+		return nil, nil
+	}
 
-		device.Data = nestedData
-		device.ID = id
-		device.ProjectID = ""
-		if value, ok := nestedData["projectID"]; ok {
-			device.ProjectID = value.(string)
+	data := make(map[string]interface{})
+	var metadata *Metadata
+	cur := buck.Cursor()
+	for k, v := cur.First(); k != nil; k, v = cur.Next() {
+		if string(k) == metadataField {
+			metadata = parseMetadata(v)
+			continue
 		}
+		data[string(k)] = string(v)
+	}
 
-		return nil
+	nestedData, err := flat.Unflatten(data, &flat.Options{
+		Delimiter: "/",
 	})
 
-	return device, err
+	if err != nil {
+		return nil, err
+	}
+
+	device := &Device{
+		ID:        id,
+		ProjectID: projectID,
+		Data:      nestedData,
+		Metadata:  metadata,
+	}
+	device.Revision = revisionValue(nestedData[revisionField])
+
+	return device, nil
 }
 
-func (s *deviceLocalStore) CreateDevice(ctx context.Context, id string, data map[string]interface{}) error {
+func (s *deviceLocalStore) CreateDevice(ctx context.Context, projectID, id string, data map[string]interface{}) error {
 	data["created"] = time.Now()
 	data["deviceID"] = id
-	err := s.UpsertDevice(ctx, id, time.Now(), data)
+	err := s.UpsertDevice(ctx, projectID, id, time.Now(), data)
 
 	if err != nil {
 		return err
@@ -73,84 +95,418 @@ func (s *deviceLocalStore) CreateDevice(ctx context.Context, id string, data map
 	return nil
 }
 
-func (s *deviceLocalStore) UpsertDevice(ctx context.Context, id string, updated time.Time, updates map[string]interface{}) error {
-	tx, err := s.db.Begin(true)
+func (s *deviceLocalStore) UpsertDevice(ctx context.Context, projectID, id string, updated time.Time, updates map[string]interface{}) error {
+	_, err := s.UpdateDevice(ctx, projectID, id, AnyRevision, updated, updates)
+	return err
+}
+
+func (s *deviceLocalStore) UpdateDevice(ctx context.Context, projectID, id string, revision int64, updated time.Time, updates map[string]interface{}) (*Device, error) {
+	var device *Device
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		buck := tx.Bucket(deviceBucket(projectID, id))
+		current := int64(0)
+		if buck != nil {
+			current = revisionValue(string(buck.Get([]byte(revisionField))))
+		}
+		err := checkRevision(id, revision, current, buck != nil)
+		if err != nil {
+			return err
+		}
+
+		old, err := readDevice(tx, projectID, id)
+		if err != nil {
+			return err
+		}
+
+		if buck == nil {
+			updates["created"] = time.Now()
+			updates["deviceID"] = id
+			buck, err = tx.CreateBucket(deviceBucket(projectID, id))
+			if err != nil {
+				return err
+			}
+			err = indexDevice(tx, projectID, id, true)
+			if err != nil {
+				return err
+			}
+		}
+
+		setProjectField(updates, projectID)
+		updates["updated"] = updated
+		updates[revisionField] = current + 1
+		flattenData, err := flatten.Flatten(updates, "", flatten.PathStyle)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range flattenData {
+			err = buck.Put([]byte(k), []byte(formatValue(v)))
+			if err != nil {
+				return err
+			}
+		}
+
+		device, err = readDevice(tx, projectID, id)
+		if err != nil {
+			return err
+		}
+		return recordChange(ctx, tx, projectID, old, device)
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return device, nil
+}
+
+// MoveDevice copies the state to the target namespace and deletes it from the source, in a
+// single transaction. The source project logs the device leaving and the target one joining
+func (s *deviceLocalStore) MoveDevice(ctx context.Context, fromProjectID, id, toProjectID string, revision int64) (*Device, error) {
+	var device *Device
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		old, err := readDevice(tx, fromProjectID, id)
+		if err != nil || old == nil {
+			return err
+		}
+		err = checkRevision(id, revision, old.Revision, true)
+		if err != nil {
+			return err
+		}
+		if fromProjectID == toProjectID {
+			device = old
+			return nil
+		}
 
-	defer func() {
+		buck, err := tx.CreateBucket(deviceBucket(toProjectID, id))
+		if err == bolt.ErrBucketExists {
+			return ErrDeviceExists
+		}
 		if err != nil {
-			err = tx.Rollback()
+			return err
+		}
+
+		src := tx.Bucket(deviceBucket(fromProjectID, id))
+		err = src.ForEach(func(k, v []byte) error {
+			if string(k) == "projectID" {
+				return nil
+			}
+			return buck.Put(append([]byte{}, k...), append([]byte{}, v...))
+		})
+		if err != nil {
+			return err
+		}
+
+		updates := map[string]interface{}{
+			"updated":     time.Now(),
+			revisionField: old.Revision + 1,
+		}
+		setProjectField(updates, toProjectID)
+		for k, v := range updates {
+			err = buck.Put([]byte(k), []byte(formatValue(v)))
 			if err != nil {
-				log.Printf("err rollback : %v\n", err)
+				return err
 			}
 		}
-	}()
 
-	buck := tx.Bucket([]byte(deviceBucketPrefix + id))
-	if buck == nil {
-		updates["created"] = time.Now()
-		updates["deviceID"] = id
-		buck, err = tx.CreateBucketIfNotExists([]byte(deviceBucketPrefix + id))
+		err = tx.DeleteBucket(deviceBucket(fromProjectID, id))
+		if err != nil {
+			return err
+		}
+		err = indexDevice(tx, fromProjectID, id, false)
+		if err != nil {
+			return err
+		}
+		err = indexDevice(tx, toProjectID, id, true)
+		if err != nil {
+			return err
+		}
+
+		device, err = readDevice(tx, toProjectID, id)
+		if err != nil {
+			return err
+		}
+		err = recordChange(ctx, tx, fromProjectID, old, nil)
 		if err != nil {
 			return err
 		}
+		return recordChange(ctx, tx, toProjectID, nil, device)
+	})
+	if err != nil {
+		return nil, err
 	}
+	return device, nil
+}
 
-	updates["updated"] = updated
-	flattenData, err := flatten.Flatten(updates, "", flatten.PathStyle)
+// UpdateDeviceMetadata keeps the metadata as JSON under its own key of the device bucket
+func (s *deviceLocalStore) UpdateDeviceMetadata(ctx context.Context, projectID, id string, revision int64, patch *MetadataPatch) (*Device, error) {
+	err := patch.Validate()
 	if err != nil {
+		return nil, err
+	}
+
+	var device *Device
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		old, err := readDevice(tx, projectID, id)
+		if err != nil || old == nil {
+			return err
+		}
+		err = checkRevision(id, revision, old.Revision, true)
+		if err != nil {
+			return err
+		}
+
+		buck := tx.Bucket(deviceBucket(projectID, id))
+		metadata := patch.Apply(old.Metadata)
+		if metadata == nil {
+			err = buck.Delete([]byte(metadataField))
+		} else {
+			var value []byte
+			value, err = json.Marshal(metadata)
+			if err != nil {
+				return err
+			}
+			err = buck.Put([]byte(metadataField), value)
+		}
+		if err != nil {
+			return err
+		}
+		err = buck.Put([]byte(revisionField), []byte(formatValue(old.Revision+1)))
+		if err != nil {
+			return err
+		}
+
+		device, err = readDevice(tx, projectID, id)
 		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return device, nil
+}
 
-	for k, v := range flattenData {
-		value := fmt.Sprintf("%v", v)
-		err = buck.Put([]byte(k), []byte(value))
+func (s *deviceLocalStore) DeleteDevice(ctx context.Context, projectID, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		old, err := readDevice(tx, projectID, id)
+		if err != nil || old == nil {
+			return err
+		}
+		err = indexDevice(tx, projectID, id, false)
+		if err != nil {
+			return err
+		}
+		err = tx.DeleteBucket(deviceBucket(projectID, id))
 		if err != nil {
 			return err
 		}
+		return recordChange(ctx, tx, projectID, old, nil)
+	})
+}
+
+func (s *deviceLocalStore) ListDevices(ctx context.Context) ([]*Device, error) {
+	devices := make([]*Device, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, buck *bolt.Bucket) error {
+			projectID, id, ok := deviceBucketID(string(name))
+			if !ok {
+				return nil
+			}
+			device, err := readDevice(tx, projectID, id)
+			if err != nil {
+				return err
+			}
+			devices = append(devices, device)
+			return nil
+		})
+	})
+	return devices, err
+}
+
+// ListDevicesForProject seeks the device buckets of the namespace, they sort together
+func (s *deviceLocalStore) ListDevicesForProject(ctx context.Context, projectID string) ([]*Device, error) {
+	devices := make([]*Device, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		prefix := []byte(tenancy.Key(projectID, deviceBucketPrefix))
+		c := tx.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			device, err := readDevice(tx, projectID, string(k[len(prefix):]))
+			if err != nil {
+				return err
+			}
+			if device != nil {
+				devices = append(devices, device)
+			}
+		}
+		return nil
+	})
+	return devices, err
+}
+
+func (s *deviceLocalStore) ListDeviceProjects(ctx context.Context, id string) ([]string, error) {
+	projects := make([]string, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		index := tx.Bucket([]byte(deviceIndexBucket))
+		if index == nil {
+			return nil
+		}
+		buck := index.Bucket([]byte(id))
+		if buck == nil {
+			return nil
+		}
+		return buck.ForEach(func(k, v []byte) error {
+			projects = append(projects, string(k))
+			return nil
+		})
+	})
+	return projects, err
+}
+
+func (s *deviceLocalStore) QueryDevicesForProject(ctx context.Context, projectID string, query DeviceQuery) (*DevicePage, error) {
+	list, err := s.ListDevicesForProject(ctx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	return applyQuery(list, query)
+}
+
+// recordChange appends the difference between two states of a device to the change log
+// of the project, a nil state being a missing device. Writes that change nothing besides
+// the update time are skipped
+func recordChange(ctx context.Context, tx *bolt.Tx, projectID string, old, new *Device) error {
+	var oldData, newData map[string]interface{}
+	var id string
+	revision := int64(0)
+	if old != nil {
+		oldData = old.Data
+		id = old.ID
+	}
+	if new != nil {
+		newData = new.Data
+		id, revision = new.ID, new.Revision
 	}
 
-	err = tx.Commit()
+	changes := diffState(oldData, newData)
+	if len(changes) == 0 {
+		return nil
+	}
+	change := newChange(ctx, id, projectID, revision, changes)
 
+	buck, err := tx.CreateBucketIfNotExists([]byte(tenancy.Key(projectID, changeBucketPrefix+id)))
+	if err != nil {
+		return err
+	}
+	seq, err := buck.NextSequence()
+	if err != nil {
+		return err
+	}
+	change.ID = strconv.FormatUint(seq, 10)
+
+	value, err := json.Marshal(change)
+	if err != nil {
+		return err
+	}
+	err = buck.Put(changeKey(change.Time, seq), value)
 	if err != nil {
 		return err
 	}
 
+	for _, snap := range snapshotsDue(projectID, old, new, change.Time) {
+		err = putSnapshot(tx, snap)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (s *deviceLocalStore) RegisterDeviceToProject(ctx context.Context, deviceID, projectID string) error {
-	updates := make(map[string]interface{})
-	updates["projectID"] = projectID
-	return s.UpsertDevice(ctx, deviceID, time.Now(), updates)
+func putSnapshot(tx *bolt.Tx, snap *snapshot) error {
+	buck, err := tx.CreateBucketIfNotExists([]byte(tenancy.Key(snap.ProjectID, snapshotBucketPrefix+snap.DeviceID)))
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	return buck.Put(changeKey(snap.Time, uint64(snap.Revision)), value)
 }
 
-func (s *deviceLocalStore) ListDevicesForProject(ctx context.Context, projectID string) ([]*Device, error) {
-	devices := make([]*Device, 0)
+func (s *deviceLocalStore) ListDeviceChanges(ctx context.Context, projectID, id string, start, end time.Time) ([]*Change, error) {
+	var changes []*Change
 	err := s.db.View(func(tx *bolt.Tx) error {
-		tx.ForEach(func(name []byte, buck *bolt.Bucket) error {
-			if strings.HasPrefix(string(name), deviceBucketPrefix) {
-				v := buck.Get([]byte("projectID"))
-				if v == nil {
-					return nil
-				}
-				value := string(v)
-				if value == projectID {
-					id := strings.Replace(string(name), deviceBucketPrefix, "", -1)
-					device, err := s.GetDeviceByID(ctx, id)
-					if err != nil {
-						return nil
-					}
-					if device != nil {
-						devices = append(devices, device)
-					}
+		var err error
+		changes, err = readChanges(tx, projectID, id, start, end)
+		return err
+	})
+	return changes, err
+}
+
+func readChanges(tx *bolt.Tx, projectID, id string, start, end time.Time) ([]*Change, error) {
+	changes := make([]*Change, 0)
+	buck := tx.Bucket([]byte(tenancy.Key(projectID, changeBucketPrefix+id)))
+	if buck == nil {
+		return changes, nil
+	}
+
+	last := changeNanos(end)
+	cur := buck.Cursor()
+	for k, v := cur.Seek(changeKey(start, 0)); k != nil; k, v = cur.Next() {
+		if int64(binary.BigEndian.Uint64(k)) > last {
+			break
+		}
+		change := &Change{}
+		err := json.Unmarshal(v, change)
+		if err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// DeviceStateAt replays the change entries following the last snapshot taken before asOf
+func (s *deviceLocalStore) DeviceStateAt(ctx context.Context, projectID, id string, asOf time.Time) (*Device, error) {
+	var device *Device
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var base *snapshot
+		start := time.Time{}
+		if buck := tx.Bucket([]byte(tenancy.Key(projectID, snapshotBucketPrefix+id))); buck != nil {
+			cur := buck.Cursor()
+			k, v := cur.Seek(changeKey(asOf.Add(time.Nanosecond), 0))
+			if k == nil {
+				k, v = cur.Last()
+			} else {
+				k, v = cur.Prev()
+			}
+			if k != nil {
+				base = &snapshot{}
+				err := json.Unmarshal(v, base)
+				if err != nil {
+					return err
 				}
+				start = base.Time.Add(time.Nanosecond)
 			}
-			return nil
-		})
+		}
+
+		changes, err := readChanges(tx, projectID, id, start, asOf)
+		if err != nil {
+			return err
+		}
+		device = rebuildState(projectID, id, base, changes)
 		return nil
 	})
-	return devices, err
+	return device, err
+}
+
+// formatValue renders a state value as stored text, times use RFC3339 so they sort and parse back
+func formatValue(v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		return t.Format(time.RFC3339Nano)
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func (s *deviceLocalStore) DeleteChangesForProject(ctx context.Context, projectID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		_, err := tenancy.DeleteBuckets(tx, projectID, changeBucketPrefix, snapshotBucketPrefix)
+		return err
+	})
 }
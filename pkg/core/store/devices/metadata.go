@@ -0,0 +1,215 @@
+package devices
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// metadataField holds the metadata in stored devices, apart from the reported state
+const metadataField = "metadata"
+
+// maxLabelLength bounds label keys and values, like Kubernetes labels
+const maxLabelLength = 63
+
+// Metadata is managed by operators, devices never report it
+type Metadata struct {
+	Name        string            `json:"name,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Location    *Location         `json:"location,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+// Location places a device, by coordinates, by address or both
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Address   string  `json:"address,omitempty"`
+}
+
+// MetadataPatch is a merge patch of metadata, fields left nil are kept. An empty location
+// clears it and null labels are removed
+type MetadataPatch struct {
+	Name        *string            `json:"name"`
+	Description *string            `json:"description"`
+	Location    *Location          `json:"location"`
+	Labels      map[string]*string `json:"labels"`
+}
+
+// Labels of the device, nil without metadata
+func (d *Device) Labels() map[string]string {
+	if d.Metadata == nil {
+		return nil
+	}
+	return d.Metadata.Labels
+}
+
+// Validate checks the patch would leave valid metadata
+func (p *MetadataPatch) Validate() error {
+	if p.Location != nil && !p.Location.isZero() {
+		if p.Location.Latitude < -90 || p.Location.Latitude > 90 {
+			return fmt.Errorf("invalid latitude %v", p.Location.Latitude)
+		}
+		if p.Location.Longitude < -180 || p.Location.Longitude > 180 {
+			return fmt.Errorf("invalid longitude %v", p.Location.Longitude)
+		}
+	}
+	for key, value := range p.Labels {
+		err := validateLabelKey(key)
+		if err != nil {
+			return err
+		}
+		if value != nil {
+			err = validateLabelValue(*value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Apply returns the metadata patched, nil when nothing is left
+func (p *MetadataPatch) Apply(m *Metadata) *Metadata {
+	patched := &Metadata{}
+	if m != nil {
+		*patched = *m
+	}
+	if p.Name != nil {
+		patched.Name = *p.Name
+	}
+	if p.Description != nil {
+		patched.Description = *p.Description
+	}
+	if p.Location != nil {
+		patched.Location = p.Location
+		if p.Location.isZero() {
+			patched.Location = nil
+		}
+	}
+
+	labels := make(map[string]string, len(patched.Labels)+len(p.Labels))
+	for k, v := range patched.Labels {
+		labels[k] = v
+	}
+	for k, v := range p.Labels {
+		if v == nil {
+			delete(labels, k)
+			continue
+		}
+		labels[k] = *v
+	}
+	patched.Labels = labels
+	if len(labels) == 0 {
+		patched.Labels = nil
+	}
+
+	if patched.isZero() {
+		return nil
+	}
+	return patched
+}
+
+// ReplaceMetadata builds the patch turning the current metadata into m
+func ReplaceMetadata(current, m *Metadata) *MetadataPatch {
+	if m == nil {
+		m = &Metadata{}
+	}
+	patch := &MetadataPatch{
+		Name:        &m.Name,
+		Description: &m.Description,
+		Location:    m.Location,
+		Labels:      make(map[string]*string),
+	}
+	if patch.Location == nil {
+		patch.Location = &Location{}
+	}
+	if current != nil {
+		for k := range current.Labels {
+			patch.Labels[k] = nil
+		}
+	}
+	for k := range m.Labels {
+		value := m.Labels[k]
+		patch.Labels[k] = &value
+	}
+	return patch
+}
+
+func (m *Metadata) isZero() bool {
+	return m.Name == "" && m.Description == "" && m.Location == nil && len(m.Labels) == 0
+}
+
+func (l *Location) isZero() bool {
+	return *l == Location{}
+}
+
+// metadataValue renders metadata for a document store, nil without metadata
+func metadataValue(m *Metadata) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return nil
+	}
+	value := make(map[string]interface{})
+	if json.Unmarshal(raw, &value) != nil {
+		return nil
+	}
+	return value
+}
+
+// parseMetadata reads stored metadata, a document map or JSON text
+func parseMetadata(v interface{}) *Metadata {
+	var raw []byte
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case string:
+		raw = []byte(value)
+	case []byte:
+		raw = value
+	default:
+		var err error
+		raw, err = json.Marshal(value)
+		if err != nil {
+			return nil
+		}
+	}
+
+	m := &Metadata{}
+	if json.Unmarshal(raw, m) != nil || m.isZero() {
+		return nil
+	}
+	return m
+}
+
+// validateLabelKey accepts letters, digits, '-' and '_', so keys are plain document field names
+func validateLabelKey(key string) error {
+	if key == "" || len(key) > maxLabelLength {
+		return fmt.Errorf("invalid label key %q, expected 1 to %d characters", key, maxLabelLength)
+	}
+	for _, r := range key {
+		if !isLabelRune(r) || r == '.' {
+			return fmt.Errorf("invalid label key %q, expected letters, digits, '-' and '_'", key)
+		}
+	}
+	return nil
+}
+
+// validateLabelValue accepts letters, digits, '-', '_' and '.', so values never clash with selectors
+func validateLabelValue(value string) error {
+	if len(value) > maxLabelLength {
+		return fmt.Errorf("invalid label value %q, expected up to %d characters", value, maxLabelLength)
+	}
+	for _, r := range value {
+		if !isLabelRune(r) {
+			return fmt.Errorf("invalid label value %q, expected letters, digits, '-', '_' and '.'", value)
+		}
+	}
+	return nil
+}
+
+func isLabelRune(r rune) bool {
+	return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.'
+}
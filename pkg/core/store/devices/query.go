@@ -0,0 +1,289 @@
+package devices
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sort keys accepted by DeviceQuery
+const (
+	SortByID      = "id"
+	SortByCreated = "created"
+	SortByUpdated = "updated"
+)
+
+// ErrInvalidCursor is returned when a page cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// filterOperators lists two character operators first, so they win over their prefixes
+var filterOperators = []string{"<=", ">=", "!=", "<", ">", "="}
+
+// Filter is a predicate on a dotted path of the device state, like battery<20
+type Filter struct {
+	Path  string `json:"path"`
+	Op    string `json:"op"`
+	Value string `json:"value"`
+}
+
+// DeviceQuery selects, orders and pages the devices of a project
+type DeviceQuery struct {
+	Filters []Filter
+	// Labels selects devices by metadata labels, empty selects every device
+	Labels     Selector
+	SortBy     string
+	Descending bool
+	Limit      int
+	Cursor     string
+	// Fields projects device data to the given dotted paths, empty keeps everything
+	Fields []string
+}
+
+// DevicePage is a page of a device query, NextCursor is empty on the last page
+type DevicePage struct {
+	Devices    []*Device `json:"devices"`
+	NextCursor string    `json:"nextCursor,omitempty"`
+}
+
+type cursor struct {
+	Time int64  `json:"t,omitempty"`
+	ID   string `json:"id"`
+}
+
+// ParseFilter parses an expression in the form <path><op><value>, the first operator wins
+func ParseFilter(expr string) (Filter, error) {
+	index, operator := -1, ""
+	for _, op := range filterOperators {
+		i := strings.Index(expr, op)
+		if i > 0 && (index < 0 || i < index) {
+			index, operator = i, op
+		}
+	}
+	if index < 0 {
+		return Filter{}, fmt.Errorf("invalid filter %q, expected <path><op><value> with op one of %s", expr, strings.Join(filterOperators, " "))
+	}
+
+	return Filter{
+		Path:  strings.TrimSpace(expr[:index]),
+		Op:    operator,
+		Value: strings.TrimSpace(expr[index+len(operator):]),
+	}, nil
+}
+
+// Validate checks the sort key and the filters of the query
+func (q DeviceQuery) Validate() error {
+	switch q.SortBy {
+	case "", SortByID, SortByCreated, SortByUpdated:
+	default:
+		return fmt.Errorf("invalid sort %q, expected one of %s, %s, %s", q.SortBy, SortByID, SortByCreated, SortByUpdated)
+	}
+	for _, f := range q.Filters {
+		if f.Path == "" {
+			return fmt.Errorf("filter without path")
+		}
+	}
+	if q.Limit < 0 {
+		return fmt.Errorf("invalid limit %d", q.Limit)
+	}
+	return nil
+}
+
+// Matches tells if the device state satisfies the filter. Values are compared as numbers
+// when both sides are numeric, as strings otherwise
+func (f Filter) Matches(data map[string]interface{}) bool {
+	value, ok := lookupPath(data, f.Path)
+	if !ok || value == nil {
+		return f.Op == "!="
+	}
+
+	stored := fmt.Sprintf("%v", value)
+	var cmp int
+	a, errA := strconv.ParseFloat(stored, 64)
+	b, errB := strconv.ParseFloat(f.Value, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	} else {
+		cmp = strings.Compare(stored, f.Value)
+	}
+
+	switch f.Op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+// applyQuery filters, sorts, pages and projects devices already loaded by a store
+func applyQuery(list []*Device, q DeviceQuery) (*DevicePage, error) {
+	err := q.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	matched := make([]*Device, 0, len(list))
+	for _, device := range list {
+		if matchesAll(device.Data, q.Filters) && q.Labels.Matches(device.Labels()) {
+			matched = append(matched, device)
+		}
+	}
+
+	sortKey := func(device *Device) cursor {
+		key := cursor{ID: device.ID}
+		if q.SortBy == SortByCreated || q.SortBy == SortByUpdated {
+			key.Time = timeValue(device.Data[q.SortBy]).UnixNano()
+		}
+		return key
+	}
+	less := func(a, b cursor) bool {
+		if q.Descending {
+			a, b = b, a
+		}
+		if a.Time != b.Time {
+			return a.Time < b.Time
+		}
+		return a.ID < b.ID
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return less(sortKey(matched[i]), sortKey(matched[j]))
+	})
+
+	if q.Cursor != "" {
+		after, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		start := sort.Search(len(matched), func(i int) bool {
+			return less(after, sortKey(matched[i]))
+		})
+		matched = matched[start:]
+	}
+
+	page := &DevicePage{}
+	if q.Limit > 0 && len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+		page.NextCursor = encodeCursor(sortKey(matched[len(matched)-1]))
+	}
+
+	page.Devices = matched
+	if len(q.Fields) > 0 {
+		page.Devices = make([]*Device, len(matched))
+		for i, device := range matched {
+			page.Devices[i] = project(device, q.Fields)
+		}
+	}
+	return page, nil
+}
+
+func matchesAll(data map[string]interface{}, filters []Filter) bool {
+	for _, f := range filters {
+		if !f.Matches(data) {
+			return false
+		}
+	}
+	return true
+}
+
+func project(device *Device, fields []string) *Device {
+	data := make(map[string]interface{})
+	for _, path := range fields {
+		value, ok := lookupPath(device.Data, path)
+		if !ok {
+			continue
+		}
+		parts := strings.Split(path, ".")
+		current := data
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := current[part].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				current[part] = next
+			}
+			current = next
+		}
+		current[parts[len(parts)-1]] = value
+	}
+	return &Device{
+		ID:        device.ID,
+		ProjectID: device.ProjectID,
+		Data:      data,
+		Metadata:  device.Metadata,
+	}
+}
+
+func lookupPath(data map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// legacyTimeLayout is how time.Time values were formatted with %v by older local stores
+const legacyTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// timeValue reads a timestamp field stored either natively or as text
+func timeValue(v interface{}) time.Time {
+	switch value := v.(type) {
+	case time.Time:
+		return value
+	case string:
+		t, err := time.Parse(time.RFC3339Nano, value)
+		if err == nil {
+			return t
+		}
+		// Drop the monotonic clock reading, like m=+0.007840407
+		if i := strings.Index(value, " m="); i > 0 {
+			value = value[:i]
+		}
+		t, err = time.Parse(legacyTimeLayout, value)
+		if err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func encodeCursor(c cursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	c := cursor{}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	err = json.Unmarshal(raw, &c)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+	return c, nil
+}
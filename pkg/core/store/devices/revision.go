@@ -0,0 +1,62 @@
+package devices
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// AnyRevision skips the revision check of UpdateDevice
+const AnyRevision int64 = -1
+
+// ExistingRevision skips the revision check of existing devices but fails on missing ones,
+// like If-Match: *
+const ExistingRevision int64 = -2
+
+// revisionField holds the revision in the stored device, bumped by every write
+const revisionField = "revision"
+
+// ConflictError is returned by UpdateDevice when the device moved past the expected revision
+type ConflictError struct {
+	DeviceID string
+	Expected int64
+	Current  int64
+}
+
+func (e *ConflictError) Error() string {
+	if e.Expected == ExistingRevision {
+		return fmt.Sprintf("device %s doesn't exist", e.DeviceID)
+	}
+	return fmt.Sprintf("device %s is at revision %d, expected %d", e.DeviceID, e.Current, e.Expected)
+}
+
+// IsConflict tells if err is a revision conflict
+func IsConflict(err error) bool {
+	_, ok := err.(*ConflictError)
+	return ok
+}
+
+// checkRevision compares the expected revision to the current one, missing devices are at revision 0
+func checkRevision(id string, expected, current int64, exists bool) error {
+	if expected == AnyRevision || expected == current || (expected == ExistingRevision && exists) {
+		return nil
+	}
+	return &ConflictError{DeviceID: id, Expected: expected, Current: current}
+}
+
+// revisionValue reads a stored revision, local stores keep it as text
+func revisionValue(v interface{}) int64 {
+	switch value := v.(type) {
+	case int64:
+		return value
+	case int:
+		return int64(value)
+	case int32:
+		return int64(value)
+	case float64:
+		return int64(value)
+	case string:
+		n, _ := strconv.ParseInt(value, 10, 64)
+		return n
+	}
+	return 0
+}
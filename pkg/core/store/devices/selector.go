@@ -0,0 +1,168 @@
+package devices
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Selector operators, exists and not exists are written as a bare key and !key
+const (
+	SelectEquals    = "="
+	SelectNotEquals = "!="
+	SelectIn        = "in"
+	SelectNotIn     = "notin"
+	SelectExists    = "exists"
+	SelectNotExists = "!"
+)
+
+// Requirement is a predicate on a device label
+type Requirement struct {
+	Key    string   `json:"key"`
+	Op     string   `json:"op"`
+	Values []string `json:"values,omitempty"`
+}
+
+// Selector matches devices whose labels satisfy every requirement, like env=prod,site in (a,b).
+// The empty selector matches every device
+type Selector []Requirement
+
+// ParseSelector parses comma separated requirements: key=value, key!=value, key in (a,b),
+// key notin (a,b), key and !key
+func ParseSelector(expr string) (Selector, error) {
+	selector := Selector{}
+	for _, term := range splitTerms(expr) {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		requirement, err := parseRequirement(term)
+		if err != nil {
+			return nil, err
+		}
+		selector = append(selector, requirement)
+	}
+	return selector, nil
+}
+
+// splitTerms splits on commas outside of value sets
+func splitTerms(expr string) []string {
+	terms := make([]string, 0)
+	depth, start := 0, 0
+	for i, r := range expr {
+		switch r {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				terms = append(terms, expr[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(terms, expr[start:])
+}
+
+func parseRequirement(term string) (Requirement, error) {
+	if strings.HasPrefix(term, "!") && !strings.Contains(term, "=") {
+		key := strings.TrimSpace(term[1:])
+		return Requirement{Key: key, Op: SelectNotExists}, validateLabelKey(key)
+	}
+
+	if i := strings.Index(term, "("); i > 0 {
+		fields := strings.Fields(term[:i])
+		if len(fields) != 2 || (fields[1] != SelectIn && fields[1] != SelectNotIn) || !strings.HasSuffix(term, ")") {
+			return Requirement{}, fmt.Errorf("invalid selector %q, expected <key> in (<values>) or <key> notin (<values>)", term)
+		}
+		requirement := Requirement{Key: fields[0], Op: fields[1]}
+		for _, value := range strings.Split(term[i+1:len(term)-1], ",") {
+			requirement.Values = append(requirement.Values, strings.TrimSpace(value))
+		}
+		return requirement, requirement.validate()
+	}
+
+	for _, op := range []string{"!=", "==", "="} {
+		if i := strings.Index(term, op); i > 0 {
+			requirement := Requirement{
+				Key:    strings.TrimSpace(term[:i]),
+				Op:     SelectEquals,
+				Values: []string{strings.TrimSpace(term[i+len(op):])},
+			}
+			if op == "!=" {
+				requirement.Op = SelectNotEquals
+			}
+			return requirement, requirement.validate()
+		}
+	}
+
+	requirement := Requirement{Key: term, Op: SelectExists}
+	return requirement, requirement.validate()
+}
+
+func (r Requirement) validate() error {
+	err := validateLabelKey(r.Key)
+	if err != nil {
+		return err
+	}
+	for _, value := range r.Values {
+		err = validateLabelValue(value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Matches tells if the labels satisfy the requirement, missing labels only satisfy
+// negative operators
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+	switch r.Op {
+	case SelectEquals, SelectIn:
+		return ok && contains(r.Values, value)
+	case SelectNotEquals, SelectNotIn:
+		return !ok || !contains(r.Values, value)
+	case SelectExists:
+		return ok
+	case SelectNotExists:
+		return !ok
+	}
+	return false
+}
+
+// Matches tells if the labels satisfy every requirement
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (s Selector) String() string {
+	terms := make([]string, len(s))
+	for i, r := range s {
+		switch r.Op {
+		case SelectIn, SelectNotIn:
+			terms[i] = r.Key + " " + r.Op + " (" + strings.Join(r.Values, ",") + ")"
+		case SelectExists:
+			terms[i] = r.Key
+		case SelectNotExists:
+			terms[i] = "!" + r.Key
+		default:
+			terms[i] = r.Key + r.Op + strings.Join(r.Values, ",")
+		}
+	}
+	return strings.Join(terms, ",")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
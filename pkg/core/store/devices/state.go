@@ -0,0 +1,125 @@
+package devices
+
+import (
+	"strings"
+	"time"
+)
+
+// snapshotEvery is the number of revisions between two snapshots of a device,
+// rebuilding a past state replays at most that many change entries
+const snapshotEvery = 100
+
+// snapshot is the whole state of a device at a revision
+type snapshot struct {
+	ID        string                 `json:"id" docstore:"id"`
+	DeviceID  string                 `json:"deviceID" docstore:"deviceID"`
+	ProjectID string                 `json:"projectID,omitempty" docstore:"projectID"`
+	Revision  int64                  `json:"revision" docstore:"revision"`
+	Time      time.Time              `json:"time" docstore:"time"`
+	Data      map[string]interface{} `json:"data" docstore:"data"`
+	Nanos     int64                  `json:"-" docstore:"t"`
+}
+
+func newSnapshot(projectID string, device *Device, t time.Time) *snapshot {
+	return &snapshot{
+		DeviceID:  device.ID,
+		ProjectID: projectID,
+		Revision:  device.Revision,
+		Time:      t,
+		Data:      device.Data,
+		Nanos:     changeNanos(t),
+	}
+}
+
+// snapshotsDue lists the states to snapshot around a change. Devices written before
+// revisions and change entries existed get their prior state kept, as it can't be replayed
+func snapshotsDue(projectID string, old, new *Device, t time.Time) []*snapshot {
+	due := make([]*snapshot, 0)
+	if old != nil && old.Revision == 0 {
+		due = append(due, newSnapshot(projectID, old, t.Add(-time.Nanosecond)))
+	}
+	oldRevision := int64(0)
+	if old != nil {
+		oldRevision = old.Revision
+	}
+	if new != nil && new.Revision/snapshotEvery > oldRevision/snapshotEvery {
+		due = append(due, newSnapshot(projectID, new, t))
+	}
+	return due
+}
+
+// rebuildState replays change entries onto a snapshot, or onto a missing device without one.
+// Nil is returned when the device did not exist at the time of the last entry
+func rebuildState(projectID, id string, base *snapshot, changes []*Change) *Device {
+	data := make(map[string]interface{})
+	revision := int64(0)
+	if base != nil {
+		data = copyState(base.Data)
+		revision = base.Revision
+	}
+
+	for _, change := range changes {
+		for _, pathChange := range change.Changes {
+			if pathChange.New == nil {
+				deletePath(data, strings.Split(pathChange.Path, "."))
+			} else {
+				setPath(data, strings.Split(pathChange.Path, "."), pathChange.New)
+			}
+		}
+		revision = change.Revision
+	}
+
+	// Fields bumped by every write are not replayed
+	for field := range unchangedFields {
+		delete(data, field)
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	return &Device{
+		ID:        id,
+		ProjectID: projectID,
+		Revision:  revision,
+		Data:      data,
+	}
+}
+
+func copyState(state map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(state))
+	for k, v := range state {
+		if nested, ok := v.(map[string]interface{}); ok {
+			v = copyState(nested)
+		}
+		copied[k] = v
+	}
+	return copied
+}
+
+func setPath(data map[string]interface{}, path []string, value interface{}) {
+	for _, part := range path[:len(path)-1] {
+		nested, ok := data[part].(map[string]interface{})
+		if !ok {
+			nested = make(map[string]interface{})
+			data[part] = nested
+		}
+		data = nested
+	}
+	data[path[len(path)-1]] = value
+}
+
+// deletePath removes a value and the maps it leaves empty
+func deletePath(data map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(data, path[0])
+		return
+	}
+	nested, ok := data[path[0]].(map[string]interface{})
+	if !ok {
+		return
+	}
+	deletePath(nested, path[1:])
+	if len(nested) == 0 {
+		delete(data, path[0])
+	}
+}
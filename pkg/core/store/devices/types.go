@@ -2,19 +2,82 @@ package devices
 
 import (
 	"context"
+	"errors"
 	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
 )
 
+// ErrDeviceExists is returned when moving a device to a project already having a device with its ID
+var ErrDeviceExists = errors.New("device already exists in project")
+
+// DeviceStore keeps devices namespaced by project, the same ID may be used by devices of
+// different projects. Devices not registered yet belong to tenancy.Unassigned
 type DeviceStore interface {
-	GetDeviceByID(ctx context.Context, id string) (*Device, error)
-	CreateDevice(ctx context.Context, id string, data map[string]interface{}) error
-	UpsertDevice(ctx context.Context, id string, updated time.Time, updates map[string]interface{}) error
-	RegisterDeviceToProject(ctx context.Context, deviceID, projectID string) error
+	GetDeviceByID(ctx context.Context, projectID, id string) (*Device, error)
+	CreateDevice(ctx context.Context, projectID, id string, data map[string]interface{}) error
+	UpsertDevice(ctx context.Context, projectID, id string, updated time.Time, updates map[string]interface{}) error
+	// UpdateDevice upserts only when the device is at the given revision, or AnyRevision.
+	// ExistingRevision updates existing devices only. A *ConflictError is returned otherwise
+	UpdateDevice(ctx context.Context, projectID, id string, revision int64, updated time.Time, updates map[string]interface{}) (*Device, error)
+	// MoveDevice moves the state of a device to another project, nil is returned when missing.
+	// History and change entries stay with the project they were recorded in
+	MoveDevice(ctx context.Context, fromProjectID, id, toProjectID string, revision int64) (*Device, error)
+	// UpdateDeviceMetadata patches the metadata at the given revision, or AnyRevision. The state and
+	// its change log are left as is, nil is returned when the device is missing
+	UpdateDeviceMetadata(ctx context.Context, projectID, id string, revision int64, patch *MetadataPatch) (*Device, error)
 	ListDevicesForProject(ctx context.Context, projectID string) ([]*Device, error)
+	QueryDevicesForProject(ctx context.Context, projectID string, query DeviceQuery) (*DevicePage, error)
+	ListDevices(ctx context.Context) ([]*Device, error)
+	// ListDeviceProjects lists the projects having a device with the ID, for gateways to resolve uplinks
+	ListDeviceProjects(ctx context.Context, id string) ([]string, error)
+	DeleteDevice(ctx context.Context, projectID, id string) error
+	// ListDeviceChanges returns the change log of a device between start and end, oldest first
+	ListDeviceChanges(ctx context.Context, projectID, id string, start, end time.Time) ([]*Change, error)
+	// DeviceStateAt rebuilds the state of a device at a past time, nil when it did not exist
+	DeviceStateAt(ctx context.Context, projectID, id string, asOf time.Time) (*Device, error)
+	// DeleteChangesForProject deletes the change log and snapshots of every device of the project,
+	// deleted devices included
+	DeleteChangesForProject(ctx context.Context, projectID string) error
 }
 
 type Device struct {
 	ID        string                 `json:"id"`
 	ProjectID string                 `json:"projectID"`
+	Revision  int64                  `json:"revision"`
 	Data      map[string]interface{} `json:"data"`
+	Metadata  *Metadata              `json:"metadata,omitempty"`
+}
+
+// ParentField links a child device to the gateway relaying its uplinks
+const ParentField = "parentID"
+
+// ParentID is the gateway relaying the uplinks of the device, empty unless registered as a child
+func (d *Device) ParentID() string {
+	parentID, _ := d.Data[ParentField].(string)
+	return parentID
+}
+
+// TemplateField assigns a device the template describing its fields and commands
+const TemplateField = "templateID"
+
+// TemplateID is the template of the device, empty when it has none
+func (d *Device) TemplateID() string {
+	templateID, _ := d.Data[TemplateField].(string)
+	return templateID
+}
+
+// keyField identifies device documents by namespaced ID, device IDs are only unique within a project
+const keyField = "key"
+
+// setProjectField keeps the projectID field of the state in line with the namespace, whatever
+// the updates carry. The key and the metadata are maintained by the stores
+func setProjectField(updates map[string]interface{}, projectID string) {
+	delete(updates, keyField)
+	delete(updates, metadataField)
+	if projectID == tenancy.Unassigned {
+		delete(updates, "projectID")
+		return
+	}
+	updates["projectID"] = projectID
 }
@@ -0,0 +1,314 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"com.aviebrantz.coap-demo/pkg/config"
+	"com.aviebrantz.coap-demo/pkg/core/store/alarms"
+	"com.aviebrantz.coap-demo/pkg/core/store/apikeys"
+	"com.aviebrantz.coap-demo/pkg/core/store/backup"
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store/groups"
+	"com.aviebrantz.coap-demo/pkg/core/store/historical"
+	"com.aviebrantz.coap-demo/pkg/core/store/integrations"
+	"com.aviebrantz.coap-demo/pkg/core/store/projects"
+	"com.aviebrantz.coap-demo/pkg/core/store/rules"
+	"com.aviebrantz.coap-demo/pkg/core/store/templates"
+	"com.aviebrantz.coap-demo/pkg/core/store/users"
+	bolt "go.etcd.io/bbolt"
+	"gocloud.dev/docstore"
+	"gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/docstore/mongodocstore"
+)
+
+// Storage backends selectable with StorageConfig.Type
+const (
+	TypeLocal   = "local"
+	TypeMongoDB = "mongodb"
+	TypeMem     = "mem"
+)
+
+const defaultDatabase = "iot-coap-platform"
+
+// Stores groups every store of the platform, built for a single backend
+type Stores struct {
+	Devices    devices.DeviceStore
+	Projects   projects.ProjectStore
+	TimeSeries historical.TimeSeriesStore
+	Rules      rules.RuleStore
+	Alarms     alarms.AlarmStore
+	Webhooks   integrations.WebhookStore
+	Groups     groups.GroupStore
+	Templates  templates.TemplateStore
+	APIKeys    apikeys.APIKeyStore
+	Users      users.UserStore
+
+	// db is only set for local storage
+	db      *bolt.DB
+	closers []func() error
+}
+
+// Open builds all stores for the backend selected in the storage config
+func Open(ctx context.Context, cfg config.StorageConfig) (*Stores, error) {
+	switch cfg.TimeSeries.Layout {
+	case "", historical.LayoutDocument, historical.LayoutField:
+	case historical.LayoutCompressed:
+		if cfg.Type != TypeLocal && cfg.Type != "" {
+			return nil, fmt.Errorf("time series layout %s requires %s storage", historical.LayoutCompressed, TypeLocal)
+		}
+	default:
+		return nil, fmt.Errorf("unknown time series layout %q", cfg.TimeSeries.Layout)
+	}
+
+	switch cfg.Type {
+	case TypeLocal, "":
+		return openLocal(cfg)
+	case TypeMongoDB:
+		return openMongo(ctx, cfg)
+	case TypeMem:
+		return openDocStores(cfg, func(name, idField string) (*docstore.Collection, error) {
+			return memdocstore.OpenCollection(idField, nil)
+		})
+	}
+	return nil, fmt.Errorf("unknown storage type %q", cfg.Type)
+}
+
+// Close releases every collection and connection, reporting the first error
+func (s *Stores) Close() error {
+	var firstErr error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		err := s.closers[i]()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.closers = nil
+	return firstErr
+}
+
+// Snapshot streams a consistent copy of the local database while it keeps serving
+func (s *Stores) Snapshot(w io.Writer) (int64, error) {
+	if s.db == nil {
+		return 0, backup.ErrSnapshotUnsupported
+	}
+	return backup.WriteSnapshot(s.db, w)
+}
+
+// Snapshotter is nil unless the storage supports snapshots
+func (s *Stores) Snapshotter() backup.Snapshotter {
+	if s.db == nil {
+		return nil
+	}
+	return s
+}
+
+// Backup returns the stores covered by logical exports and imports
+func (s *Stores) Backup() backup.Stores {
+	return backup.Stores{
+		Projects:   s.Projects,
+		Devices:    s.Devices,
+		TimeSeries: s.TimeSeries,
+	}
+}
+
+func openLocal(cfg config.StorageConfig) (*Stores, error) {
+	db, err := bolt.Open(cfg.URL, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	err = devices.EnsureDeviceIndex(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	timeseries := historical.NewTimeSeriesLocalStore(db)
+	switch cfg.TimeSeries.Layout {
+	case historical.LayoutField:
+		timeseries = historical.NewFieldTimeSeriesLocalStore(db)
+	case historical.LayoutCompressed:
+		timeseries = historical.NewCompressedTimeSeriesLocalStore(db)
+	}
+
+	return &Stores{
+		Devices:    devices.NewDeviceLocalStore(db),
+		Projects:   projects.NewProjectLocalStore(db),
+		TimeSeries: timeseries,
+		Rules:      rules.NewRuleLocalStore(db),
+		Alarms:     alarms.NewAlarmLocalStore(db),
+		Webhooks:   integrations.NewWebhookLocalStore(db),
+		Groups:     groups.NewGroupLocalStore(db),
+		Templates:  templates.NewTemplateLocalStore(db),
+		APIKeys:    apikeys.NewAPIKeyLocalStore(db),
+		Users:      users.NewUserLocalStore(db),
+		db:         db,
+		closers:    []func() error{db.Close},
+	}, nil
+}
+
+func openMongo(ctx context.Context, cfg config.StorageConfig) (*Stores, error) {
+	client, err := mongodocstore.Dial(ctx, cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	database := cfg.Database
+	if database == "" {
+		database = defaultDatabase
+	}
+	db := client.Database(database)
+
+	stores, err := openDocStores(cfg, func(name, idField string) (*docstore.Collection, error) {
+		return mongodocstore.OpenCollection(db.Collection(name), idField, nil)
+	})
+	if err != nil {
+		client.Disconnect(ctx)
+		return nil, err
+	}
+
+	// Collections are closed before disconnecting, closers run in reverse
+	stores.closers = append([]func() error{func() error {
+		return client.Disconnect(context.Background())
+	}}, stores.closers...)
+	return stores, nil
+}
+
+type collectionOpener func(name, idField string) (*docstore.Collection, error)
+
+func openDocStores(cfg config.StorageConfig, open collectionOpener) (*Stores, error) {
+	names := withDefaultCollections(cfg.Collections)
+	stores := &Stores{}
+
+	// coll opens collections until the first failure, which is kept in err
+	var err error
+	coll := func(name, idField string) *docstore.Collection {
+		if err != nil {
+			return nil
+		}
+		c, openErr := open(name, idField)
+		if openErr != nil {
+			err = fmt.Errorf("could not open %s collection: %v", name, openErr)
+			return nil
+		}
+		stores.closers = append(stores.closers, c.Close)
+		return c
+	}
+
+	devicesColl := coll(names.Devices, "key")
+	deviceChangesColl := coll(names.DeviceChanges, "id")
+	deviceSnapshotsColl := coll(names.DeviceSnapshots, "id")
+	projectsColl := coll(names.Projects, "projectID")
+	var historyColl *docstore.Collection
+	if cfg.TimeSeries.Layout == historical.LayoutField {
+		historyColl = coll(names.FieldHistory, "id")
+	} else {
+		historyColl = coll(names.History, "id")
+	}
+	rulesColl := coll(names.Rules, "id")
+	ruleStatesColl := coll(names.RuleStates, "id")
+	alertsColl := coll(names.Alerts, "id")
+	alarmsColl := coll(names.Alarms, "id")
+	webhooksColl := coll(names.Webhooks, "id")
+	deliveriesColl := coll(names.WebhookDeliveries, "id")
+	groupsColl := coll(names.Groups, "id")
+	templatesColl := coll(names.Templates, "id")
+	apiKeysColl := coll(names.APIKeys, "id")
+	usersColl := coll(names.Users, "email")
+	membersColl := coll(names.Members, "id")
+	if err != nil {
+		stores.Close()
+		return nil, err
+	}
+
+	stores.Devices = devices.NewDeviceDocStore(devicesColl, deviceChangesColl, deviceSnapshotsColl)
+	stores.Projects = projects.NewProjectDocStore(projectsColl)
+	stores.TimeSeries = historical.NewHistoricalDocStore(historyColl)
+	if cfg.TimeSeries.Layout == historical.LayoutField {
+		stores.TimeSeries = historical.NewFieldTimeSeriesDocStore(historyColl)
+	}
+	stores.Rules = rules.NewRuleDocStore(rulesColl, ruleStatesColl, alertsColl)
+	stores.Alarms = alarms.NewAlarmDocStore(alarmsColl)
+	stores.Webhooks = integrations.NewWebhookDocStore(webhooksColl, deliveriesColl)
+	stores.Groups = groups.NewGroupDocStore(groupsColl)
+	stores.Templates = templates.NewTemplateDocStore(templatesColl)
+	stores.APIKeys = apikeys.NewAPIKeyDocStore(apiKeysColl)
+	stores.Users = users.NewUserDocStore(usersColl, membersColl)
+	return stores, nil
+}
+
+func withDefaultCollections(c config.CollectionsConfig) config.CollectionsConfig {
+	defaults := config.CollectionsConfig{
+		Devices:           "devices",
+		DeviceChanges:     "device_changes",
+		DeviceSnapshots:   "device_snapshots",
+		Projects:          "projects",
+		History:           "device_history",
+		FieldHistory:      "device_field_history",
+		Rules:             "rules",
+		RuleStates:        "rule_states",
+		Alerts:            "alerts",
+		Alarms:            "alarms",
+		Webhooks:          "webhooks",
+		WebhookDeliveries: "webhook_deliveries",
+		Groups:            "device_groups",
+		Templates:         "device_templates",
+		APIKeys:           "api_keys",
+		Users:             "users",
+		Members:           "project_members",
+	}
+	if c.Devices == "" {
+		c.Devices = defaults.Devices
+	}
+	if c.DeviceChanges == "" {
+		c.DeviceChanges = defaults.DeviceChanges
+	}
+	if c.DeviceSnapshots == "" {
+		c.DeviceSnapshots = defaults.DeviceSnapshots
+	}
+	if c.Projects == "" {
+		c.Projects = defaults.Projects
+	}
+	if c.History == "" {
+		c.History = defaults.History
+	}
+	if c.FieldHistory == "" {
+		c.FieldHistory = defaults.FieldHistory
+	}
+	if c.Rules == "" {
+		c.Rules = defaults.Rules
+	}
+	if c.RuleStates == "" {
+		c.RuleStates = defaults.RuleStates
+	}
+	if c.Alerts == "" {
+		c.Alerts = defaults.Alerts
+	}
+	if c.Alarms == "" {
+		c.Alarms = defaults.Alarms
+	}
+	if c.Webhooks == "" {
+		c.Webhooks = defaults.Webhooks
+	}
+	if c.WebhookDeliveries == "" {
+		c.WebhookDeliveries = defaults.WebhookDeliveries
+	}
+	if c.Groups == "" {
+		c.Groups = defaults.Groups
+	}
+	if c.Templates == "" {
+		c.Templates = defaults.Templates
+	}
+	if c.APIKeys == "" {
+		c.APIKeys = defaults.APIKeys
+	}
+	if c.Users == "" {
+		c.Users = defaults.Users
+	}
+	if c.Members == "" {
+		c.Members = defaults.Members
+	}
+	return c
+}
@@ -0,0 +1,75 @@
+package groups
+
+import (
+	"context"
+	"io"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+type groupDocStore struct {
+	coll *docstore.Collection
+}
+
+// NewGroupDocStore create a group store using a goacloud.dev/docstore collection
+func NewGroupDocStore(coll *docstore.Collection) GroupStore {
+	return &groupDocStore{
+		coll: coll,
+	}
+}
+
+func (s *groupDocStore) GetGroupByID(ctx context.Context, projectID, id string) (*Group, error) {
+	group := &Group{ID: id}
+	err := s.coll.Get(ctx, group)
+	if err != nil {
+		code := gcerrors.Code(err)
+		if code == gcerrors.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if group.ProjectID != projectID {
+		return nil, nil
+	}
+
+	return group, nil
+}
+
+func (s *groupDocStore) CreateGroup(ctx context.Context, group *Group) error {
+	return s.coll.Create(ctx, group)
+}
+
+func (s *groupDocStore) UpdateGroup(ctx context.Context, group *Group) error {
+	return s.coll.Replace(ctx, group)
+}
+
+func (s *groupDocStore) DeleteGroup(ctx context.Context, projectID, id string) error {
+	group, err := s.GetGroupByID(ctx, projectID, id)
+	if err != nil || group == nil {
+		return err
+	}
+	return s.coll.Delete(ctx, group)
+}
+
+func (s *groupDocStore) ListGroupsForProject(ctx context.Context, projectID string) ([]*Group, error) {
+	iter := s.coll.
+		Query().
+		Where("projectID", "=", projectID).
+		Get(ctx)
+	defer iter.Stop()
+
+	groups := make([]*Group, 0)
+	for {
+		group := &Group{}
+		err := iter.Next(ctx, group)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+	return groups, nil
+}
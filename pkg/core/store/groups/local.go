@@ -0,0 +1,94 @@
+package groups
+
+import (
+	"context"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type groupLocalStore struct {
+	db *bolt.DB
+}
+
+const groupBucketPrefix = "groups_"
+
+func NewGroupLocalStore(db *bolt.DB) GroupStore {
+	return &groupLocalStore{
+		db: db,
+	}
+}
+
+func (s *groupLocalStore) GetGroupByID(ctx context.Context, projectID, id string) (*Group, error) {
+	var group *Group
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(groupBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+
+		v := buck.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+
+		group = &Group{}
+		return json.Unmarshal(v, group)
+	})
+	return group, err
+}
+
+func (s *groupLocalStore) CreateGroup(ctx context.Context, group *Group) error {
+	return s.putGroup(group)
+}
+
+func (s *groupLocalStore) UpdateGroup(ctx context.Context, group *Group) error {
+	return s.putGroup(group)
+}
+
+func (s *groupLocalStore) putGroup(group *Group) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck, err := tx.CreateBucketIfNotExists([]byte(groupBucketPrefix + group.ProjectID))
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(group)
+		if err != nil {
+			return err
+		}
+
+		return buck.Put([]byte(group.ID), value)
+	})
+}
+
+func (s *groupLocalStore) DeleteGroup(ctx context.Context, projectID, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(groupBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+		return buck.Delete([]byte(id))
+	})
+}
+
+func (s *groupLocalStore) ListGroupsForProject(ctx context.Context, projectID string) ([]*Group, error) {
+	groups := make([]*Group, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(groupBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+
+		return buck.ForEach(func(k, v []byte) error {
+			group := &Group{}
+			err := json.Unmarshal(v, group)
+			if err != nil {
+				return err
+			}
+			groups = append(groups, group)
+			return nil
+		})
+	})
+	return groups, err
+}
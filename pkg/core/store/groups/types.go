@@ -0,0 +1,142 @@
+package groups
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+)
+
+type GroupStore interface {
+	GetGroupByID(ctx context.Context, projectID, id string) (*Group, error)
+	CreateGroup(ctx context.Context, group *Group) error
+	UpdateGroup(ctx context.Context, group *Group) error
+	DeleteGroup(ctx context.Context, projectID, id string) error
+	ListGroupsForProject(ctx context.Context, projectID string) ([]*Group, error)
+}
+
+// Group organizes the devices of a project, like a site, a building or a floor.
+// Members are listed devices plus devices matching the selector, and members of subgroups
+type Group struct {
+	ID          string `json:"id" docstore:"id"`
+	ProjectID   string `json:"projectID" docstore:"projectID"`
+	Name        string `json:"name" docstore:"name"`
+	Description string `json:"description,omitempty" docstore:"description"`
+	// ParentID nests the group, empty for top level groups
+	ParentID string `json:"parentID,omitempty" docstore:"parentID"`
+	// DeviceIDs are the static members
+	DeviceIDs []string `json:"deviceIDs" docstore:"deviceIDs"`
+	// Selector adds the devices whose labels match it, e.g. `site=lisbon,floor in (1,2)`
+	Selector string    `json:"selector,omitempty" docstore:"selector"`
+	Created  time.Time `json:"created" docstore:"created"`
+	Updated  time.Time `json:"updated" docstore:"updated"`
+}
+
+// Validate checks the name and the selector of the group
+func (g *Group) Validate() error {
+	if g.Name == "" {
+		return fmt.Errorf("missing group name")
+	}
+	_, err := devices.ParseSelector(g.Selector)
+	return err
+}
+
+// HasDevice tells if the device is a static member
+func (g *Group) HasDevice(deviceID string) bool {
+	for _, id := range g.DeviceIDs {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// Subtree returns the group with the given ID followed by its nested groups, depth first.
+// Empty when the group is not in the list
+func Subtree(list []*Group, id string) []*Group {
+	byID := make(map[string]*Group, len(list))
+	children := make(map[string][]*Group)
+	for _, group := range list {
+		byID[group.ID] = group
+		children[group.ParentID] = append(children[group.ParentID], group)
+	}
+	for _, siblings := range children {
+		sort.Slice(siblings, func(i, j int) bool { return siblings[i].ID < siblings[j].ID })
+	}
+
+	tree := make([]*Group, 0)
+	visited := make(map[string]bool)
+	var walk func(group *Group)
+	walk = func(group *Group) {
+		// Cycles can't be created through the API, stores are not trusted for it
+		if visited[group.ID] {
+			return
+		}
+		visited[group.ID] = true
+		tree = append(tree, group)
+		for _, child := range children[group.ID] {
+			walk(child)
+		}
+	}
+	if root, ok := byID[id]; ok {
+		walk(root)
+	}
+	return tree
+}
+
+// CheckParent rejects parents missing from the list, and parents nesting the group in itself
+func CheckParent(list []*Group, id, parentID string) error {
+	if parentID == "" {
+		return nil
+	}
+	for _, group := range Subtree(list, id) {
+		if group.ID == parentID {
+			return fmt.Errorf("group %s can not be nested in itself", id)
+		}
+	}
+	for _, group := range list {
+		if group.ID == parentID {
+			return nil
+		}
+	}
+	return fmt.Errorf("parent group %s not found", parentID)
+}
+
+// Members selects the devices of the project belonging to any of the groups,
+// statically or through selectors, in the order of the device list
+func Members(tree []*Group, list []*devices.Device) ([]*devices.Device, error) {
+	static := make(map[string]bool)
+	selectors := make([]devices.Selector, 0, len(tree))
+	for _, group := range tree {
+		for _, id := range group.DeviceIDs {
+			static[id] = true
+		}
+		if group.Selector == "" {
+			continue
+		}
+		selector, err := devices.ParseSelector(group.Selector)
+		if err != nil {
+			return nil, err
+		}
+		selectors = append(selectors, selector)
+	}
+
+	members := make([]*devices.Device, 0)
+	for _, device := range list {
+		if static[device.ID] || matchesAny(selectors, device.Labels()) {
+			members = append(members, device)
+		}
+	}
+	return members, nil
+}
+
+func matchesAny(selectors []devices.Selector, labels map[string]string) bool {
+	for _, selector := range selectors {
+		if selector.Matches(labels) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,284 @@
+package historical
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Aggregation functions, percentiles are written p<rank> like p95 or p99.9
+const (
+	AggMean  = "mean"
+	AggMin   = "min"
+	AggMax   = "max"
+	AggSum   = "sum"
+	AggCount = "count"
+	AggFirst = "first"
+	AggLast  = "last"
+)
+
+const maxAggregationBuckets = 10000
+
+var aggregationFunctions = []string{AggMean, AggMin, AggMax, AggSum, AggCount, AggFirst, AggLast}
+
+// AggregationQuery buckets the points of a range by Step, applying each function to
+// each field. Empty Fields aggregates every numeric field
+type AggregationQuery struct {
+	Start     time.Time
+	End       time.Time
+	Step      time.Duration
+	Fields    []string
+	Functions []string
+}
+
+// AggregatedPoint holds the results of a bucket, by field path and then function
+type AggregatedPoint struct {
+	Time   time.Time                     `json:"time"`
+	Values map[string]map[string]float64 `json:"values"`
+}
+
+func (q *AggregationQuery) Validate() error {
+	if !q.End.After(q.Start) {
+		return fmt.Errorf("end must be after start")
+	}
+	if q.Step <= 0 {
+		return fmt.Errorf("step must be positive")
+	}
+	if buckets := q.End.Sub(q.Start) / q.Step; buckets > maxAggregationBuckets {
+		return fmt.Errorf("step too small, the range would have %d buckets, max %d", buckets, maxAggregationBuckets)
+	}
+	if len(q.Functions) == 0 {
+		return fmt.Errorf("missing aggregation function")
+	}
+	for _, fn := range q.Functions {
+		if !isAggregationFunction(fn) {
+			return fmt.Errorf("unknown aggregation %q, expected one of %s or a percentile like p95", fn, strings.Join(aggregationFunctions, ", "))
+		}
+	}
+	return nil
+}
+
+// AggregateSeries aggregates the series of several IDs as one, like the devices of a group.
+// Points of every series are pooled in time order, so each reading weighs the same
+func AggregateSeries(ctx context.Context, store TimeSeriesStore, projectID string, datatype string, ids []string, query AggregationQuery) ([]*AggregatedPoint, error) {
+	err := query.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]*DataPoint, 0)
+	for _, id := range ids {
+		series, err := store.GetDataPointsInRange(ctx, projectID, datatype, id, query.Start, query.End)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, series...)
+	}
+	sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+
+	agg := newAggregator(query)
+	for _, point := range points {
+		agg.add(point)
+	}
+	return agg.result(), nil
+}
+
+func isAggregationFunction(fn string) bool {
+	for _, known := range aggregationFunctions {
+		if fn == known {
+			return true
+		}
+	}
+	_, ok := percentileRank(fn)
+	return ok
+}
+
+func percentileRank(fn string) (float64, bool) {
+	if !strings.HasPrefix(fn, "p") {
+		return 0, false
+	}
+	rank, err := strconv.ParseFloat(fn[1:], 64)
+	if err != nil || rank < 0 || rank > 100 {
+		return 0, false
+	}
+	return rank, true
+}
+
+type fieldAccumulator struct {
+	stats       FieldStats
+	first, last float64
+	values      []float64
+}
+
+// aggregator consumes points in time order, finishing each bucket as soon as a later
+// point arrives so only the current bucket is kept in memory
+type aggregator struct {
+	query       AggregationQuery
+	percentiles bool
+	current     time.Time
+	fields      map[string]*fieldAccumulator
+	results     []*AggregatedPoint
+}
+
+func newAggregator(query AggregationQuery) *aggregator {
+	a := &aggregator{
+		query: query,
+	}
+	for _, fn := range query.Functions {
+		if _, ok := percentileRank(fn); ok {
+			a.percentiles = true
+		}
+	}
+	return a
+}
+
+// add accounts a data point, rollups contribute their stats and their average as sample
+func (a *aggregator) add(point *DataPoint) {
+	if point.Time.Before(a.query.Start) || point.Time.After(a.query.End) {
+		return
+	}
+
+	// Buckets are aligned to the range start, a point right at the end joins the last one
+	bucket := a.query.Start.Add(point.Time.Sub(a.query.Start) / a.query.Step * a.query.Step)
+	if !bucket.Before(a.query.End) {
+		bucket = bucket.Add(-a.query.Step)
+	}
+	if a.fields == nil || !bucket.Equal(a.current) {
+		a.flush()
+		a.current = bucket
+		a.fields = make(map[string]*fieldAccumulator)
+	}
+
+	if point.Stats != nil {
+		for field, stats := range point.Stats {
+			if a.wants(field) {
+				a.accumulate(field, stats)
+			}
+		}
+		return
+	}
+
+	for field, value := range a.values(point.Data) {
+		a.accumulate(field, &FieldStats{Min: value, Max: value, Avg: value, Count: 1})
+	}
+}
+
+func (a *aggregator) wants(field string) bool {
+	if len(a.query.Fields) == 0 {
+		return true
+	}
+	for _, f := range a.query.Fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *aggregator) values(data map[string]interface{}) map[string]float64 {
+	if len(a.query.Fields) == 0 {
+		return numericFields(data)
+	}
+
+	values := make(map[string]float64, len(a.query.Fields))
+	for _, field := range a.query.Fields {
+		if value, ok := numericValue(lookupPath(data, field)); ok {
+			values[field] = value
+		}
+	}
+	return values
+}
+
+func (a *aggregator) accumulate(field string, stats *FieldStats) {
+	acc, ok := a.fields[field]
+	if !ok {
+		acc = &fieldAccumulator{first: stats.Avg}
+		a.fields[field] = acc
+	}
+	acc.stats.Merge(stats)
+	acc.last = stats.Avg
+	if a.percentiles {
+		acc.values = append(acc.values, stats.Avg)
+	}
+}
+
+func (a *aggregator) flush() {
+	if len(a.fields) == 0 {
+		return
+	}
+
+	point := &AggregatedPoint{
+		Time:   a.current,
+		Values: make(map[string]map[string]float64, len(a.fields)),
+	}
+	for field, acc := range a.fields {
+		results := make(map[string]float64, len(a.query.Functions))
+		for _, fn := range a.query.Functions {
+			results[fn] = acc.result(fn)
+		}
+		point.Values[field] = results
+	}
+	a.results = append(a.results, point)
+	a.fields = nil
+}
+
+func (a *aggregator) result() []*AggregatedPoint {
+	a.flush()
+	if a.results == nil {
+		return make([]*AggregatedPoint, 0)
+	}
+	return a.results
+}
+
+func (acc *fieldAccumulator) result(fn string) float64 {
+	switch fn {
+	case AggMean:
+		return acc.stats.Avg
+	case AggMin:
+		return acc.stats.Min
+	case AggMax:
+		return acc.stats.Max
+	case AggSum:
+		return acc.stats.Avg * float64(acc.stats.Count)
+	case AggCount:
+		return float64(acc.stats.Count)
+	case AggFirst:
+		return acc.first
+	case AggLast:
+		return acc.last
+	}
+
+	rank, _ := percentileRank(fn)
+	return percentile(acc.values, rank)
+}
+
+// percentile interpolates linearly between the closest ranks
+func percentile(values []float64, rank float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sort.Float64s(values)
+	pos := rank / 100 * float64(len(values)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	if lower == upper {
+		return values[lower]
+	}
+	return values[lower] + (values[upper]-values[lower])*(pos-float64(lower))
+}
+
+func lookupPath(data map[string]interface{}, path string) interface{} {
+	var current interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return current
+}
@@ -0,0 +1,180 @@
+package historical_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/historical"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Benchmarks compare the local layouts on a synthetic fleet of environment sensors:
+//
+//	go test ./pkg/core/store/historical -run '^$' -bench .
+const (
+	benchProject  = "bench"
+	benchDevices  = 4
+	benchPoints   = 5000
+	benchInterval = 10 * time.Second
+	benchWindow   = time.Hour
+)
+
+var benchStart = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var benchLayouts = []struct {
+	name string
+	open func(db *bolt.DB) historical.TimeSeriesStore
+}{
+	{historical.LayoutDocument, historical.NewTimeSeriesLocalStore},
+	{historical.LayoutField, historical.NewFieldTimeSeriesLocalStore},
+	{historical.LayoutCompressed, historical.NewCompressedTimeSeriesLocalStore},
+}
+
+// openBenchDB opens an empty database, without fsync so runs measure the layouts rather than the disk
+func openBenchDB(b *testing.B) *bolt.DB {
+	dir, err := ioutil.TempDir("", "tsbench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	db, err := bolt.Open(filepath.Join(dir, "bench.db"), 0600, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	db.NoSync = true
+	return db
+}
+
+// benchReading mimics an environment sensor: slow moving temperature and humidity,
+// a draining battery and a status text
+func benchReading(rnd *rand.Rand, i int) map[string]interface{} {
+	phase := float64(i) / 360
+	return map[string]interface{}{
+		"temperature": math.Round((21+4*math.Sin(phase)+rnd.Float64()*0.3)*10) / 10,
+		"humidity":    float64(55 + rnd.Intn(5)),
+		"power": map[string]interface{}{
+			"battery": float64(100 - i%benchPoints*100/benchPoints),
+		},
+		"status": "ok",
+	}
+}
+
+// benchTime is when point i is reported, gateways stamp readings on arrival with a few milliseconds of jitter
+func benchTime(rnd *rand.Rand, i int) time.Time {
+	return benchStart.Add(time.Duration(i) * benchInterval).Add(time.Duration(rnd.Intn(5)) * time.Millisecond)
+}
+
+// loadFleet inserts benchPoints points for each of benchDevices devices
+func loadFleet(b *testing.B, store historical.TimeSeriesStore) {
+	ctx := context.Background()
+	rnd := rand.New(rand.NewSource(1))
+	for d := 0; d < benchDevices; d++ {
+		id := fmt.Sprintf("sensor-%d", d)
+		for i := 0; i < benchPoints; i++ {
+			err := store.InsertDataPoint(ctx, benchProject, "device", id, benchTime(rnd, i), benchReading(rnd, i))
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
+
+func BenchmarkInsert(b *testing.B) {
+	for _, layout := range benchLayouts {
+		b.Run(layout.name, func(b *testing.B) {
+			db := openBenchDB(b)
+			defer db.Close()
+			store := layout.open(db)
+			ctx := context.Background()
+			rnd := rand.New(rand.NewSource(1))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := store.InsertDataPoint(ctx, benchProject, "device", "sensor", benchTime(rnd, i), benchReading(rnd, i))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkDiskSize loads the fleet into a new database every iteration and reports the
+// size of the database, per point and in total. The file itself grows in large steps
+func BenchmarkDiskSize(b *testing.B) {
+	for _, layout := range benchLayouts {
+		b.Run(layout.name, func(b *testing.B) {
+			var size int64
+			for i := 0; i < b.N; i++ {
+				db := openBenchDB(b)
+				loadFleet(b, layout.open(db))
+				err := db.View(func(tx *bolt.Tx) error {
+					size = tx.Size()
+					return nil
+				})
+				if err != nil {
+					b.Fatal(err)
+				}
+				db.Close()
+			}
+			b.ReportMetric(float64(size)/(benchDevices*benchPoints), "B/point")
+			b.ReportMetric(float64(size)/(1<<20), "MB")
+		})
+	}
+}
+
+func BenchmarkRangeQuery(b *testing.B) {
+	benchQuery(b, func(ctx context.Context, store historical.TimeSeriesStore, id string, from, to time.Time) error {
+		_, err := store.GetDataPointsInRange(ctx, benchProject, "device", id, from, to)
+		return err
+	})
+}
+
+func BenchmarkAggregateQuery(b *testing.B) {
+	benchQuery(b, func(ctx context.Context, store historical.TimeSeriesStore, id string, from, to time.Time) error {
+		_, err := store.AggregateDataPoints(ctx, benchProject, "device", id, historical.AggregationQuery{
+			Start:     from,
+			End:       to,
+			Step:      10 * time.Minute,
+			Fields:    []string{"temperature"},
+			Functions: []string{historical.AggMean, historical.AggMax},
+		})
+		return err
+	})
+}
+
+// benchQuery times queries of benchWindow at random times and devices of the fleet
+func benchQuery(b *testing.B, query func(ctx context.Context, store historical.TimeSeriesStore, id string, from, to time.Time) error) {
+	for _, layout := range benchLayouts {
+		b.Run(layout.name, func(b *testing.B) {
+			db := openBenchDB(b)
+			defer db.Close()
+			store := layout.open(db)
+			loadFleet(b, store)
+
+			ctx := context.Background()
+			rnd := rand.New(rand.NewSource(2))
+			span := int64(benchPoints*benchInterval - benchWindow)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				id := fmt.Sprintf("sensor-%d", rnd.Intn(benchDevices))
+				from := benchStart.Add(time.Duration(rnd.Int63n(span)))
+				err := query(ctx, store, id, from, from.Add(benchWindow))
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
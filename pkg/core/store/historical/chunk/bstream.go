@@ -0,0 +1,57 @@
+package chunk
+
+import "io"
+
+// bstream is an append only stream of bits, most significant bit first
+type bstream struct {
+	data  []byte
+	count uint8 // bits still free in the last byte
+}
+
+func (b *bstream) writeBit(bit bool) {
+	if b.count == 0 {
+		b.data = append(b.data, 0)
+		b.count = 8
+	}
+	if bit {
+		b.data[len(b.data)-1] |= 1 << (b.count - 1)
+	}
+	b.count--
+}
+
+func (b *bstream) writeBits(value uint64, nbits int) {
+	for nbits > 0 {
+		nbits--
+		b.writeBit((value>>uint(nbits))&1 == 1)
+	}
+}
+
+// breader reads a bstream from a byte slice
+type breader struct {
+	data []byte
+	pos  int // next bit
+}
+
+func (r *breader) readBit() (bool, error) {
+	if r.pos >= len(r.data)*8 {
+		return false, io.ErrUnexpectedEOF
+	}
+	bit := r.data[r.pos/8]&(1<<uint(7-r.pos%8)) != 0
+	r.pos++
+	return bit, nil
+}
+
+func (r *breader) readBits(nbits int) (uint64, error) {
+	var value uint64
+	for i := 0; i < nbits; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value <<= 1
+		if bit {
+			value |= 1
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,368 @@
+// Package chunk compresses series of (timestamp, float) samples the way Facebook's Gorilla
+// does: delta of delta encoded timestamps and XOR encoded values. Timestamps are
+// milliseconds and must not decrease within a chunk
+package chunk
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// headerSize holds the number of samples of the chunk
+const headerSize = 2
+
+// MaxSamples bounds a chunk, appends beyond it fail with ErrChunkFull
+const MaxSamples = math.MaxUint16
+
+var (
+	ErrChunkFull    = errors.New("chunk is full")
+	ErrOutOfOrder   = errors.New("timestamp before the last sample of the chunk")
+	ErrInvalidChunk = errors.New("invalid chunk")
+)
+
+// Chunk is an encoded block of samples
+type Chunk struct {
+	stream bstream
+}
+
+func New() *Chunk {
+	return &Chunk{
+		stream: bstream{data: make([]byte, headerSize)},
+	}
+}
+
+// FromBytes copies encoded chunk data, as returned by Bytes
+func FromBytes(data []byte) (*Chunk, error) {
+	if len(data) < headerSize {
+		return nil, ErrInvalidChunk
+	}
+	// The free bits of the last byte are unknown, the appender restores them by replaying
+	return &Chunk{
+		stream: bstream{data: append([]byte{}, data...)},
+	}, nil
+}
+
+// View wraps encoded chunk data without copying it, for reads only. The chunk must not
+// be appended to, the data may be read-only memory like a bbolt value
+func View(data []byte) (*Chunk, error) {
+	if len(data) < headerSize {
+		return nil, ErrInvalidChunk
+	}
+	return &Chunk{
+		stream: bstream{data: data},
+	}, nil
+}
+
+func (c *Chunk) Bytes() []byte {
+	return c.stream.data
+}
+
+func (c *Chunk) NumSamples() int {
+	return int(binary.BigEndian.Uint16(c.stream.data))
+}
+
+// Appender returns an appender continuing the chunk, replaying its samples to restore
+// the encoder state
+func (c *Chunk) Appender() (*Appender, error) {
+	it := c.Iterator()
+	for it.Next() {
+	}
+	if it.Err() != nil {
+		return nil, it.Err()
+	}
+
+	// Drop bits past the last sample, they would be read as garbage otherwise
+	used := it.r.pos
+	c.stream.data = c.stream.data[:(used+7)/8]
+	c.stream.count = uint8((8 - used%8) % 8)
+	if c.stream.count > 0 {
+		c.stream.data[len(c.stream.data)-1] &^= 1<<c.stream.count - 1
+	}
+
+	return &Appender{
+		chunk:    c,
+		t:        it.t,
+		delta:    it.delta,
+		v:        it.v,
+		leading:  it.leading,
+		trailing: it.trailing,
+	}, nil
+}
+
+func (c *Chunk) Iterator() *Iterator {
+	return &Iterator{
+		r:       breader{data: c.stream.data, pos: headerSize * 8},
+		total:   c.NumSamples(),
+		leading: noWindow,
+	}
+}
+
+// Appender encodes samples at the end of a chunk
+type Appender struct {
+	chunk    *Chunk
+	t        int64
+	delta    int64
+	v        float64
+	leading  uint8
+	trailing uint8
+}
+
+// LastTimestamp returns the timestamp of the last sample of the chunk
+func (a *Appender) LastTimestamp() int64 {
+	return a.t
+}
+
+func (a *Appender) Append(t int64, v float64) error {
+	num := a.chunk.NumSamples()
+	if num == MaxSamples {
+		return ErrChunkFull
+	}
+	if num > 0 && t < a.t {
+		return ErrOutOfOrder
+	}
+
+	s := &a.chunk.stream
+	switch num {
+	case 0:
+		buf := make([]byte, binary.MaxVarintLen64)
+		for _, b := range buf[:binary.PutVarint(buf, t)] {
+			s.writeBits(uint64(b), 8)
+		}
+		s.writeBits(math.Float64bits(v), 64)
+	case 1:
+		a.delta = t - a.t
+		buf := make([]byte, binary.MaxVarintLen64)
+		for _, b := range buf[:binary.PutUvarint(buf, uint64(a.delta))] {
+			s.writeBits(uint64(b), 8)
+		}
+		a.writeValue(v)
+	default:
+		delta := t - a.t
+		a.writeDeltaOfDelta(delta - a.delta)
+		a.delta = delta
+		a.writeValue(v)
+	}
+
+	a.t = t
+	a.v = v
+	binary.BigEndian.PutUint16(s.data, uint16(num+1))
+	return nil
+}
+
+// noWindow marks that no XOR window was written yet
+const noWindow = 0xff
+
+// dodBuckets are the bit sizes of delta of delta values, after their prefix of ones
+var dodBuckets = []int{14, 17, 20}
+
+func (a *Appender) writeDeltaOfDelta(dod int64) {
+	s := &a.chunk.stream
+	if dod == 0 {
+		s.writeBit(false)
+		return
+	}
+	for i, nbits := range dodBuckets {
+		if fitsInBits(dod, nbits) {
+			s.writeBits(1<<uint(i+2)-2, i+2)
+			s.writeBits(uint64(dod), nbits)
+			return
+		}
+	}
+	s.writeBits(0xf, 4)
+	s.writeBits(uint64(dod), 64)
+}
+
+func fitsInBits(value int64, nbits int) bool {
+	return -(1<<uint(nbits-1)) <= value && value <= (1<<uint(nbits-1))-1
+}
+
+func (a *Appender) writeValue(v float64) {
+	s := &a.chunk.stream
+	xor := math.Float64bits(v) ^ math.Float64bits(a.v)
+	if xor == 0 {
+		s.writeBit(false)
+		return
+	}
+	s.writeBit(true)
+
+	leading := uint8(bits.LeadingZeros64(xor))
+	trailing := uint8(bits.TrailingZeros64(xor))
+	// Leading zeros are written on 5 bits
+	if leading >= 32 {
+		leading = 31
+	}
+
+	// Reuse the previous window when the meaningful bits fit in it
+	if a.leading != noWindow && leading >= a.leading && trailing >= a.trailing {
+		s.writeBit(false)
+		s.writeBits(xor>>a.trailing, 64-int(a.leading)-int(a.trailing))
+		return
+	}
+
+	a.leading, a.trailing = leading, trailing
+	s.writeBit(true)
+	s.writeBits(uint64(leading), 5)
+	sigbits := 64 - leading - trailing
+	// 64 significant bits don't fit on 6 bits, they are written as 0
+	s.writeBits(uint64(sigbits), 6)
+	s.writeBits(xor>>trailing, int(sigbits))
+}
+
+// Iterator decodes the samples of a chunk in order
+type Iterator struct {
+	r        breader
+	total    int
+	read     int
+	t        int64
+	delta    int64
+	v        float64
+	leading  uint8
+	trailing uint8
+	err      error
+}
+
+func (it *Iterator) At() (int64, float64) {
+	return it.t, it.v
+}
+
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+func (it *Iterator) Next() bool {
+	if it.err != nil || it.read == it.total {
+		return false
+	}
+
+	switch it.read {
+	case 0:
+		t, err := it.readVarint()
+		if err != nil {
+			return it.fail(err)
+		}
+		v, err := it.r.readBits(64)
+		if err != nil {
+			return it.fail(err)
+		}
+		it.t, it.v = t, math.Float64frombits(v)
+	case 1:
+		delta, err := it.readUvarint()
+		if err != nil {
+			return it.fail(err)
+		}
+		it.delta = int64(delta)
+		it.t += it.delta
+		if err := it.readValue(); err != nil {
+			return it.fail(err)
+		}
+	default:
+		dod, err := it.readDeltaOfDelta()
+		if err != nil {
+			return it.fail(err)
+		}
+		it.delta += dod
+		it.t += it.delta
+		if err := it.readValue(); err != nil {
+			return it.fail(err)
+		}
+	}
+
+	it.read++
+	return true
+}
+
+func (it *Iterator) fail(err error) bool {
+	it.err = err
+	return false
+}
+
+func (it *Iterator) readByte() (byte, error) {
+	b, err := it.r.readBits(8)
+	return byte(b), err
+}
+
+func (it *Iterator) readVarint() (int64, error) {
+	return binary.ReadVarint(byteReader{it})
+}
+
+func (it *Iterator) readUvarint() (uint64, error) {
+	return binary.ReadUvarint(byteReader{it})
+}
+
+type byteReader struct{ it *Iterator }
+
+func (br byteReader) ReadByte() (byte, error) {
+	return br.it.readByte()
+}
+
+func (it *Iterator) readDeltaOfDelta() (int64, error) {
+	// Count the prefix of ones, up to four
+	prefix := 0
+	for prefix < 4 {
+		bit, err := it.r.readBit()
+		if err != nil {
+			return 0, err
+		}
+		if !bit {
+			break
+		}
+		prefix++
+	}
+
+	if prefix == 0 {
+		return 0, nil
+	}
+	nbits := 64
+	if prefix <= len(dodBuckets) {
+		nbits = dodBuckets[prefix-1]
+	}
+	value, err := it.r.readBits(nbits)
+	if err != nil {
+		return 0, err
+	}
+	if nbits < 64 && value >= 1<<uint(nbits-1) {
+		// Sign extend negative values
+		return int64(value) - 1<<uint(nbits), nil
+	}
+	return int64(value), nil
+}
+
+func (it *Iterator) readValue() error {
+	bit, err := it.r.readBit()
+	if err != nil {
+		return err
+	}
+	if !bit {
+		return nil
+	}
+
+	newWindow, err := it.r.readBit()
+	if err != nil {
+		return err
+	}
+	if newWindow {
+		leading, err := it.r.readBits(5)
+		if err != nil {
+			return err
+		}
+		sigbits, err := it.r.readBits(6)
+		if err != nil {
+			return err
+		}
+		if sigbits == 0 {
+			sigbits = 64
+		}
+		it.leading = uint8(leading)
+		it.trailing = uint8(64 - leading - sigbits)
+	}
+
+	sigbits := 64 - int(it.leading) - int(it.trailing)
+	xor, err := it.r.readBits(sigbits)
+	if err != nil {
+		return err
+	}
+	it.v = math.Float64frombits(math.Float64bits(it.v) ^ xor<<it.trailing)
+	return nil
+}
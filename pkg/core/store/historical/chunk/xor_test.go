@@ -0,0 +1,229 @@
+package chunk
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+type testSample struct {
+	t int64
+	v float64
+}
+
+func appendAll(t *testing.T, c *Chunk, samples []testSample) {
+	t.Helper()
+	app, err := c.Appender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range samples {
+		if err := app.Append(s.t, s.v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkSamples(t *testing.T, c *Chunk, expected []testSample) {
+	t.Helper()
+	if c.NumSamples() != len(expected) {
+		t.Fatalf("expected %d samples, got %d", len(expected), c.NumSamples())
+	}
+
+	it := c.Iterator()
+	for i := 0; it.Next(); i++ {
+		ts, v := it.At()
+		// Bits are compared so NaN and negative zero round trip too
+		if ts != expected[i].t || math.Float64bits(v) != math.Float64bits(expected[i].v) {
+			t.Fatalf("sample %d: expected %v, got {%d %v}", i, expected[i], ts, v)
+		}
+	}
+	if it.Err() != nil {
+		t.Fatal(it.Err())
+	}
+}
+
+// randomSamples mixes steady intervals, jitter, repeated timestamps and values, and
+// deltas of delta from every bucket, negative ones included
+func randomSamples(rnd *rand.Rand, n int) []testSample {
+	samples := make([]testSample, n)
+	t := int64(1600000000000)
+	v := 21.5
+	for i := range samples {
+		switch rnd.Intn(6) {
+		case 0:
+			t += 10000
+		case 1:
+			t += 10000 + rnd.Int63n(50)
+		case 2:
+			// Same timestamp as the previous sample
+		case 3:
+			t += rnd.Int63n(1 << 19)
+		case 4:
+			t += rnd.Int63n(1 << 40)
+		default:
+			t += 1
+		}
+		switch rnd.Intn(4) {
+		case 0:
+			// Repeated value
+		case 1:
+			v += float64(rnd.Intn(10)-5) / 10
+		case 2:
+			v = rnd.NormFloat64() * 1e6
+		default:
+			v = float64(rnd.Intn(100))
+		}
+		samples[i] = testSample{t: t, v: v}
+	}
+	return samples
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []testSample
+	}{
+		{"empty", nil},
+		{"single", []testSample{{t: -5, v: 1.5}}},
+		{"repeated values", []testSample{{1000, 7}, {2000, 7}, {3000, 7}, {4000, 7}}},
+		{"repeated timestamps", []testSample{{1000, 1}, {1000, 2}, {1000, 3}, {1001, 3}}},
+		{"negative timestamps", []testSample{{-3000, 1}, {-2000, 2}, {-1000, 3}, {0, 4}}},
+		{"large deltas", []testSample{{0, 1}, {1, 2}, {1 << 40, 3}, {1<<40 + 1, 4}, {1 << 62, 5}}},
+		{"negative delta of delta", []testSample{{0, 1}, {100000, 2}, {100001, 3}, {200000, 4}, {200001, 5}}},
+		{"special values", []testSample{
+			{1, 0}, {2, math.Copysign(0, -1)}, {3, math.Inf(1)}, {4, math.Inf(-1)},
+			{5, math.NaN()}, {6, math.MaxFloat64}, {7, math.SmallestNonzeroFloat64}, {8, -1},
+		}},
+		{"random", randomSamples(rand.New(rand.NewSource(1)), 5000)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := New()
+			appendAll(t, c, test.samples)
+			checkSamples(t, c, test.samples)
+
+			// Encoded data decodes the same once copied or wrapped
+			copied, err := FromBytes(c.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSamples(t, copied, test.samples)
+			view, err := View(c.Bytes())
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkSamples(t, view, test.samples)
+		})
+	}
+}
+
+func TestReopenedAppender(t *testing.T) {
+	samples := randomSamples(rand.New(rand.NewSource(2)), 2000)
+
+	whole := New()
+	appendAll(t, whole, samples)
+
+	// Reopen the chunk from its bytes between batches of every size, the way stores append
+	rnd := rand.New(rand.NewSource(3))
+	data := New().Bytes()
+	for i := 0; i < len(samples); {
+		n := 1 + rnd.Intn(10)
+		if i+n > len(samples) {
+			n = len(samples) - i
+		}
+		c, err := FromBytes(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app, err := c.Appender()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 && app.LastTimestamp() != samples[i-1].t {
+			t.Fatalf("expected last timestamp %d, got %d", samples[i-1].t, app.LastTimestamp())
+		}
+		appendAll(t, c, samples[i:i+n])
+		data = c.Bytes()
+		i += n
+	}
+
+	reopened, err := FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSamples(t, reopened, samples)
+	if !bytes.Equal(data, whole.Bytes()) {
+		t.Fatal("expected reopened appends to encode like a single appender")
+	}
+}
+
+func TestReopenedAppenderDropsTrailingBits(t *testing.T) {
+	c := New()
+	appendAll(t, c, []testSample{{1000, 1}, {2000, 2}, {3000, 2.5}})
+
+	// Free bits of the last byte are garbage once stored
+	data := append([]byte{}, c.Bytes()...)
+	data[len(data)-1] |= 0xff >> (8 - c.stream.count)
+
+	reopened, err := FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendAll(t, reopened, []testSample{{4000, 3}, {5000, 3}})
+	checkSamples(t, reopened, []testSample{{1000, 1}, {2000, 2}, {3000, 2.5}, {4000, 3}, {5000, 3}})
+}
+
+func TestAppendErrors(t *testing.T) {
+	c := New()
+	app, err := c.Appender()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Append(1000, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Append(999, 1); err != ErrOutOfOrder {
+		t.Fatalf("expected ErrOutOfOrder, got %v", err)
+	}
+
+	for i := 1; i < MaxSamples; i++ {
+		if err := app.Append(1000+int64(i), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := app.Append(1000+MaxSamples, 1); err != ErrChunkFull {
+		t.Fatalf("expected ErrChunkFull, got %v", err)
+	}
+	if c.NumSamples() != MaxSamples {
+		t.Fatalf("expected %d samples, got %d", MaxSamples, c.NumSamples())
+	}
+}
+
+func TestInvalidChunk(t *testing.T) {
+	if _, err := FromBytes([]byte{0}); err != ErrInvalidChunk {
+		t.Fatalf("expected ErrInvalidChunk, got %v", err)
+	}
+	if _, err := View(nil); err != ErrInvalidChunk {
+		t.Fatalf("expected ErrInvalidChunk, got %v", err)
+	}
+
+	c := New()
+	appendAll(t, c, []testSample{{1000, 1}, {2000, 2}, {3000, 3}})
+	truncated, err := View(c.Bytes()[:len(c.Bytes())-2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	it := truncated.Iterator()
+	for it.Next() {
+	}
+	if it.Err() != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", it.Err())
+	}
+	if _, err := truncated.Appender(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected io.ErrUnexpectedEOF from the appender, got %v", err)
+	}
+}
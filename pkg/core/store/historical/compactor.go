@@ -0,0 +1,113 @@
+package historical
+
+import (
+	"context"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store/projects"
+	"github.com/apex/log"
+)
+
+const defaultCompactionInterval = time.Hour
+
+// Compactor applies the retention policy of each project to the history of its devices
+type Compactor struct {
+	tsStore      TimeSeriesStore
+	projectStore projects.ProjectStore
+	deviceStore  devices.DeviceStore
+	interval     time.Duration
+	logger       *log.Entry
+}
+
+func NewCompactor(tsStore TimeSeriesStore, projectStore projects.ProjectStore, deviceStore devices.DeviceStore, interval time.Duration) *Compactor {
+	if interval <= 0 {
+		interval = defaultCompactionInterval
+	}
+	logger := log.WithField("module", "history-compactor")
+	return &Compactor{
+		tsStore:      tsStore,
+		projectStore: projectStore,
+		deviceStore:  deviceStore,
+		interval:     interval,
+		logger:       logger,
+	}
+}
+
+// Start compacts right away and then on every interval
+func (c *Compactor) Start() {
+	rollupStore, ok := c.tsStore.(RollupStore)
+	if !ok {
+		c.logger.Warnf("Time series storage doesn't support compaction, retention policies are ignored")
+		return
+	}
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		err := c.Run(context.Background(), rollupStore, time.Now())
+		if err != nil {
+			c.logger.Errorf("Compaction failed: %v", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Run applies every project retention policy once, as of now
+func (c *Compactor) Run(ctx context.Context, rollupStore RollupStore, now time.Time) error {
+	list, err := c.projectStore.ListProjects(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, project := range list {
+		policy, err := project.Retention()
+		if err != nil {
+			c.logger.Warnf("Skipping project %s: %v", project.ID, err)
+			continue
+		}
+		if policy == nil || policy.RawDays == 0 {
+			continue
+		}
+
+		projectDevices, err := c.deviceStore.ListDevicesForProject(ctx, project.ID)
+		if err != nil {
+			return err
+		}
+		for _, device := range projectDevices {
+			err = c.compactDevice(ctx, rollupStore, policy, project.ID, device.ID, now)
+			if err != nil {
+				c.logger.Errorf("Compacting device %s: %v", device.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Compactor) compactDevice(ctx context.Context, rollupStore RollupStore, policy *projects.RetentionPolicy, projectID, deviceID string, now time.Time) error {
+	compacted, err := rollupStore.CompactDataPoints(ctx, projectID, "device", deviceID, policy.Cutoff(now, policy.RawDays))
+	if err != nil {
+		return err
+	}
+
+	expired := 0
+	days := map[string]int{
+		ResolutionMinute.Name: policy.MinuteDays,
+		ResolutionHour.Name:   policy.HourDays,
+	}
+	for _, res := range Resolutions {
+		if days[res.Name] == 0 {
+			continue
+		}
+		count, err := rollupStore.ExpireRollups(ctx, projectID, "device", deviceID, res, policy.Cutoff(now, days[res.Name]))
+		if err != nil {
+			return err
+		}
+		expired += count
+	}
+
+	if compacted > 0 || expired > 0 {
+		c.logger.Infof("Device %s: compacted %d points, expired %d rollups", deviceID, compacted, expired)
+	}
+	return nil
+}
@@ -0,0 +1,267 @@
+package historical
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/historical/chunk"
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
+	bolt "go.etcd.io/bbolt"
+)
+
+// LayoutCompressed keeps numeric fields in Gorilla compressed chunks, local storage only
+const LayoutCompressed = "compressed"
+
+// samplesPerChunk closes chunks once full, out of order writes may grow a chunk up to
+// twice this size before it's split
+const samplesPerChunk = 120
+
+// localCompressedStore keeps one bucket per device, with a nested bucket per field path
+// holding compressed chunks keyed by the millisecond timestamp of their first sample.
+// Chunks of a field never overlap, so a range only decodes the chunks it touches
+type localCompressedStore struct {
+	db *bolt.DB
+}
+
+func NewCompressedTimeSeriesLocalStore(db *bolt.DB) TimeSeriesStore {
+	return &localCompressedStore{
+		db: db,
+	}
+}
+
+func getChunkBucketName(projectID, datatype, id string) string {
+	return tenancy.Key(projectID, fmt.Sprintf("chunks_%s_%s", datatype, id))
+}
+
+type sample struct {
+	t int64
+	v float64
+}
+
+func toMillis(t time.Time) int64 {
+	return unixNanos(t) / int64(time.Millisecond)
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+func chunkKey(ms int64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(ms))
+	return key
+}
+
+// InsertDataPoint keeps the numeric fields of the payload with millisecond precision,
+// other values are dropped
+func (s *localCompressedStore) InsertDataPoint(ctx context.Context, projectID string, datatype string, id string, reportedTime time.Time, data map[string]interface{}) error {
+	values := numericFields(data)
+	if len(values) == 0 {
+		return nil
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck, err := tx.CreateBucketIfNotExists([]byte(getChunkBucketName(projectID, datatype, id)))
+		if err != nil {
+			return err
+		}
+
+		for field, value := range values {
+			fieldBuck, err := buck.CreateBucketIfNotExists([]byte(field))
+			if err != nil {
+				return err
+			}
+			err = insertSample(fieldBuck, sample{t: toMillis(reportedTime), v: value})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// insertSample appends to the last chunk when in order, otherwise rewrites the chunk covering the sample
+func insertSample(buck *bolt.Bucket, smp sample) error {
+	key := chunkKey(smp.t)
+	c := buck.Cursor()
+	k, v := seekChunk(c, key)
+	if k == nil {
+		return putSamples(buck, []sample{smp})
+	}
+	k = append([]byte{}, k...)
+	next, _ := c.Next()
+	isLast := next == nil
+
+	ch, err := chunk.FromBytes(v)
+	if err != nil {
+		return err
+	}
+	app, err := ch.Appender()
+	if err != nil {
+		return err
+	}
+
+	if isLast && smp.t >= app.LastTimestamp() {
+		if ch.NumSamples() >= samplesPerChunk && !bytes.Equal(k, key) {
+			return putSamples(buck, []sample{smp})
+		}
+		err = app.Append(smp.t, smp.v)
+		if err != nil {
+			return err
+		}
+		return buck.Put(k, ch.Bytes())
+	}
+
+	samples, err := decodeChunk(ch)
+	if err != nil {
+		return err
+	}
+	i := sort.Search(len(samples), func(i int) bool { return samples[i].t > smp.t })
+	samples = append(samples, sample{})
+	copy(samples[i+1:], samples[i:])
+	samples[i] = smp
+
+	err = buck.Delete(k)
+	if err != nil {
+		return err
+	}
+	if len(samples) > 2*samplesPerChunk {
+		half := len(samples) / 2
+		// Samples sharing a timestamp can't be split into chunks with distinct keys
+		if samples[half-1].t != samples[half].t {
+			err = putSamples(buck, samples[:half])
+			if err != nil {
+				return err
+			}
+			return putSamples(buck, samples[half:])
+		}
+	}
+	return putSamples(buck, samples)
+}
+
+// seekChunk positions the cursor on the last chunk starting at or before the key, the
+// one that may hold it. Keys before every chunk get the first chunk
+func seekChunk(c *bolt.Cursor, key []byte) ([]byte, []byte) {
+	k, v := c.Seek(key)
+	if k != nil && bytes.Equal(k, key) {
+		return k, v
+	}
+	if k == nil {
+		k, v = c.Last()
+	} else {
+		k, v = c.Prev()
+	}
+	if k == nil {
+		k, v = c.First()
+	}
+	return k, v
+}
+
+func putSamples(buck *bolt.Bucket, samples []sample) error {
+	ch := chunk.New()
+	app, err := ch.Appender()
+	if err != nil {
+		return err
+	}
+	for _, smp := range samples {
+		err = app.Append(smp.t, smp.v)
+		if err != nil {
+			return err
+		}
+	}
+	return buck.Put(chunkKey(samples[0].t), ch.Bytes())
+}
+
+func decodeChunk(ch *chunk.Chunk) ([]sample, error) {
+	samples := make([]sample, 0, ch.NumSamples()+1)
+	it := ch.Iterator()
+	for it.Next() {
+		t, v := it.At()
+		samples = append(samples, sample{t: t, v: v})
+	}
+	return samples, it.Err()
+}
+
+func (s *localCompressedStore) GetDataPointsInRange(ctx context.Context, projectID string, datatype string, id string, start time.Time, end time.Time) ([]*DataPoint, error) {
+	var points []*DataPoint
+	err := s.db.View(func(tx *bolt.Tx) error {
+		fields := storedFields(tx, getChunkBucketName(projectID, datatype, id))
+		points = pointsFromFields(id, fields, s.walker(tx, projectID, datatype, start, end))
+		return nil
+	})
+	return points, err
+}
+
+// AggregateDataPoints only decodes the chunks of the requested fields
+func (s *localCompressedStore) AggregateDataPoints(ctx context.Context, projectID string, datatype string, id string, query AggregationQuery) ([]*AggregatedPoint, error) {
+	err := query.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var points []*AggregatedPoint
+	err = s.db.View(func(tx *bolt.Tx) error {
+		fields := query.Fields
+		if len(fields) == 0 {
+			fields = storedFields(tx, getChunkBucketName(projectID, datatype, id))
+		}
+		points = aggregateFields(query, id, fields, s.walker(tx, projectID, datatype, query.Start, query.End))
+		return nil
+	})
+	return points, err
+}
+
+func (s *localCompressedStore) GetFieldSeries(ctx context.Context, projectID string, datatype string, query FieldQuery) ([]*FieldSeries, error) {
+	var series []*FieldSeries
+	err := s.db.View(func(tx *bolt.Tx) error {
+		series = seriesFromFields(query, s.walker(tx, projectID, datatype, query.Start, query.End))
+		return nil
+	})
+	return series, err
+}
+
+func (s *localCompressedStore) walker(tx *bolt.Tx, projectID, datatype string, start, end time.Time) fieldWalker {
+	return func(id, field string, visit func(time.Time, float64)) {
+		walkChunks(tx, projectID, datatype, id, field, start, end, visit)
+	}
+}
+
+func walkChunks(tx *bolt.Tx, projectID, datatype, id, field string, start, end time.Time, visit func(time.Time, float64)) {
+	buck := tx.Bucket([]byte(getChunkBucketName(projectID, datatype, id)))
+	if buck == nil {
+		return
+	}
+	fieldBuck := buck.Bucket([]byte(field))
+	if fieldBuck == nil {
+		return
+	}
+
+	startMs, endMs := toMillis(start), toMillis(end)
+	startKey := chunkKey(startMs)
+
+	c := fieldBuck.Cursor()
+	for k, v := seekChunk(c, startKey); k != nil && int64(binary.BigEndian.Uint64(k)) <= endMs; k, v = c.Next() {
+		ch, err := chunk.View(v)
+		if err != nil {
+			continue
+		}
+		it := ch.Iterator()
+		for it.Next() {
+			t, value := it.At()
+			if t > endMs {
+				break
+			}
+			if t >= startMs {
+				visit(fromMillis(t), value)
+			}
+		}
+	}
+}
+
+func (s *localCompressedStore) DeleteSeriesForProject(ctx context.Context, projectID string) error {
+	return deleteLocalSeries(s.db, projectID)
+}
@@ -0,0 +1,161 @@
+package historical
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/historical/chunk"
+	bolt "go.etcd.io/bbolt"
+)
+
+// insertSamples inserts every sample into the chunks of a single field, one transaction each
+func insertSamples(t *testing.T, samples []sample) [][]sample {
+	t.Helper()
+	f, err := ioutil.TempFile("", "chunks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	for _, smp := range samples {
+		err = db.Update(func(tx *bolt.Tx) error {
+			buck, err := tx.CreateBucketIfNotExists([]byte("field"))
+			if err != nil {
+				return err
+			}
+			return insertSample(buck, smp)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chunks := make([][]sample, 0)
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte("field")).ForEach(func(k, v []byte) error {
+			ch, err := chunk.FromBytes(v)
+			if err != nil {
+				return err
+			}
+			decoded, err := decodeChunk(ch)
+			if err != nil {
+				return err
+			}
+			if first := int64(binary.BigEndian.Uint64(k)); first != decoded[0].t {
+				t.Errorf("chunk keyed %d starts at %d", first, decoded[0].t)
+			}
+			chunks = append(chunks, decoded)
+			return nil
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return chunks
+}
+
+// checkChunks checks that chunks hold the samples in time order, without overlapping
+func checkChunks(t *testing.T, chunks [][]sample, samples []sample) {
+	t.Helper()
+	expected := append([]sample{}, samples...)
+	sort.SliceStable(expected, func(i, j int) bool { return expected[i].t < expected[j].t })
+
+	got := make([]sample, 0, len(samples))
+	for i, ch := range chunks {
+		// Chunks only grow past the split size when their middle samples share a timestamp
+		if half := len(ch) / 2; len(ch) > 2*samplesPerChunk && ch[half-1].t != ch[half].t {
+			t.Errorf("chunk %d holds %d samples, more than %d", i, len(ch), 2*samplesPerChunk)
+		}
+		got = append(got, ch...)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d samples, got %d", len(expected), len(got))
+	}
+	for i := range got {
+		if got[i].t != expected[i].t {
+			t.Fatalf("sample %d: expected time %d, got %d", i, expected[i].t, got[i].t)
+		}
+	}
+}
+
+func TestInsertSampleInOrder(t *testing.T) {
+	samples := make([]sample, 3*samplesPerChunk+1)
+	for i := range samples {
+		samples[i] = sample{t: int64(1000 * i), v: float64(i)}
+	}
+
+	chunks := insertSamples(t, samples)
+	checkChunks(t, chunks, samples)
+	if len(chunks) != 4 || len(chunks[0]) != samplesPerChunk || len(chunks[3]) != 1 {
+		t.Fatalf("expected chunks closed at %d samples, got %d chunks", samplesPerChunk, len(chunks))
+	}
+}
+
+func TestInsertSampleOutOfOrder(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	samples := make([]sample, 1000)
+	for i := range samples {
+		samples[i] = sample{t: int64(1000 * (i + 1)), v: float64(i)}
+	}
+	rnd.Shuffle(len(samples), func(i, j int) { samples[i], samples[j] = samples[j], samples[i] })
+
+	chunks := insertSamples(t, samples)
+	checkChunks(t, chunks, samples)
+	for _, ch := range chunks {
+		for _, smp := range ch {
+			if smp.v != float64(smp.t/1000-1) {
+				t.Fatalf("sample at %d holds %v", smp.t, smp.v)
+			}
+		}
+	}
+}
+
+func TestInsertSampleBeforeFirstChunk(t *testing.T) {
+	samples := []sample{{t: 5000, v: 1}, {t: 6000, v: 2}, {t: 1000, v: 0}}
+	chunks := insertSamples(t, samples)
+	checkChunks(t, chunks, samples)
+	if len(chunks) != 1 {
+		t.Fatalf("expected the first chunk to be rewritten, got %d chunks", len(chunks))
+	}
+}
+
+func TestInsertSampleSplitsChunk(t *testing.T) {
+	// A full first chunk of even timestamps, then a chunk after it
+	samples := make([]sample, 0)
+	for i := 0; i < samplesPerChunk; i++ {
+		samples = append(samples, sample{t: int64(2 * i)})
+	}
+	samples = append(samples, sample{t: 1000000})
+	// Odd timestamps grow the first chunk past twice its size
+	for i := 0; i <= samplesPerChunk; i++ {
+		samples = append(samples, sample{t: int64(2*i + 1)})
+	}
+
+	chunks := insertSamples(t, samples)
+	checkChunks(t, chunks, samples)
+	if len(chunks) != 3 || len(chunks[0]) != samplesPerChunk || len(chunks[1]) != samplesPerChunk+1 {
+		t.Fatalf("expected the first chunk to be split in half, got %d chunks", len(chunks))
+	}
+}
+
+func TestInsertSampleRepeatedTimestamps(t *testing.T) {
+	// Samples sharing a timestamp stay in a single chunk, even past the split size
+	samples := make([]sample, 2*samplesPerChunk+10)
+	for i := range samples {
+		samples[i] = sample{t: 1000, v: float64(i)}
+	}
+	samples = append(samples, sample{t: 500})
+
+	chunks := insertSamples(t, samples)
+	checkChunks(t, chunks, samples)
+}
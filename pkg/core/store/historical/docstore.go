@@ -19,16 +19,19 @@ func NewHistoricalDocStore(coll *docstore.Collection) TimeSeriesStore {
 	}
 }
 
-func (s *historicalDocStore) InsertDataPoint(ctx context.Context, datatype string, id string, reportedTime time.Time, data map[string]interface{}) error {
+func (s *historicalDocStore) InsertDataPoint(ctx context.Context, projectID string, datatype string, id string, reportedTime time.Time, data map[string]interface{}) error {
+	data["projectID"] = projectID
 	data["deviceID"] = id
+	data["type"] = datatype
 	data["time"] = reportedTime.Format(time.RFC3339)
 	return s.coll.Actions().Create(data).Do(ctx)
 }
 
-func (s *historicalDocStore) GetDataPointsInRange(ctx context.Context, datatype string, id string, start time.Time, end time.Time) ([]*DataPoint, error) {
+func (s *historicalDocStore) GetDataPointsInRange(ctx context.Context, projectID string, datatype string, id string, start time.Time, end time.Time) ([]*DataPoint, error) {
 
 	iter := s.coll.
 		Query().
+		Where("projectID", "=", projectID).
 		Where("deviceID", "=", id).
 		Where("type", "=", datatype).
 		Where("time", ">=", start.Format(time.RFC3339)).
@@ -41,14 +44,14 @@ func (s *historicalDocStore) GetDataPointsInRange(ctx context.Context, datatype
 	points := make([]*DataPoint, 0)
 	for {
 		data := make(map[string]interface{})
-		err := iter.Next(ctx, &data)
+		err := iter.Next(ctx, data)
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return nil, nil
+			return nil, err
 		} else {
 			timeStr := data["time"].(string)
-			t, err := time.Parse(timeStr, time.RFC3339)
+			t, err := time.Parse(time.RFC3339, timeStr)
 			if err != nil {
 				continue
 			}
@@ -64,3 +67,101 @@ func (s *historicalDocStore) GetDataPointsInRange(ctx context.Context, datatype
 
 	return points, nil
 }
+
+// AggregateDataPoints streams the range in time order, only fetching the requested fields
+func (s *historicalDocStore) AggregateDataPoints(ctx context.Context, projectID string, datatype string, id string, query AggregationQuery) ([]*AggregatedPoint, error) {
+	err := query.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var fields []docstore.FieldPath
+	if len(query.Fields) > 0 {
+		fields = append(fields, "time")
+		for _, field := range query.Fields {
+			fields = append(fields, docstore.FieldPath(field))
+		}
+	}
+
+	iter := s.coll.
+		Query().
+		Where("projectID", "=", projectID).
+		Where("deviceID", "=", id).
+		Where("type", "=", datatype).
+		Where("time", ">=", query.Start.Format(time.RFC3339)).
+		Where("time", "<=", query.End.Format(time.RFC3339)).
+		OrderBy("time", "asc").
+		Get(ctx, fields...)
+
+	defer iter.Stop()
+
+	agg := newAggregator(query)
+	for {
+		data := make(map[string]interface{})
+		err := iter.Next(ctx, data)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		timeStr, _ := data["time"].(string)
+		t, err := time.Parse(time.RFC3339, timeStr)
+		if err != nil {
+			continue
+		}
+
+		// Bookkeeping fields are not part of the reported data
+		delete(data, "id")
+		delete(data, "projectID")
+		delete(data, "deviceID")
+		delete(data, "type")
+		delete(data, "time")
+		agg.add(&DataPoint{
+			Time: t,
+			Data: data,
+		})
+	}
+
+	return agg.result(), nil
+}
+
+func (s *historicalDocStore) DeleteSeriesForProject(ctx context.Context, projectID string) error {
+	return deleteProjectDocs(ctx, s.coll, projectID)
+}
+
+// deleteBatchSize bounds the actions sent at once when deleting a project history
+const deleteBatchSize = 500
+
+// deleteProjectDocs deletes the documents of the project in the collection, by batches
+func deleteProjectDocs(ctx context.Context, coll *docstore.Collection, projectID string) error {
+	iter := coll.
+		Query().
+		Where("projectID", "=", projectID).
+		Get(ctx, "id")
+	defer iter.Stop()
+
+	actions := coll.Actions()
+	pending := 0
+	for {
+		doc := make(map[string]interface{})
+		err := iter.Next(ctx, doc)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+
+		actions = actions.Delete(doc)
+		pending++
+		if pending == deleteBatchSize {
+			err = actions.Do(ctx)
+			if err != nil {
+				return err
+			}
+			actions = coll.Actions()
+			pending = 0
+		}
+	}
+	return actions.Do(ctx)
+}
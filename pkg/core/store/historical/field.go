@@ -0,0 +1,202 @@
+package historical
+
+import (
+	"context"
+	"encoding/binary"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/nqd/flat"
+)
+
+// Time series layouts selectable with the storage config. The document layout keeps each
+// payload as a whole, the field layout keeps a numeric series per device and field path
+const (
+	LayoutDocument = "document"
+	LayoutField    = "field"
+)
+
+// FieldSeriesStore is implemented by stores able to read single fields without decoding
+// whole payloads, like the field layout
+type FieldSeriesStore interface {
+	GetFieldSeries(ctx context.Context, projectID string, datatype string, query FieldQuery) ([]*FieldSeries, error)
+}
+
+// FieldQuery selects numeric field paths of several devices over a range
+type FieldQuery struct {
+	IDs    []string
+	Fields []string
+	Start  time.Time
+	End    time.Time
+}
+
+// FieldSeries holds the values of a field path of a device, in time order
+type FieldSeries struct {
+	ID     string        `json:"id"`
+	Field  string        `json:"field"`
+	Points []*FieldValue `json:"points"`
+}
+
+type FieldValue struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// QueryFieldSeries reads field series from any store, stores without a field layout
+// have their payloads decoded device by device
+func QueryFieldSeries(ctx context.Context, store TimeSeriesStore, projectID string, datatype string, query FieldQuery) ([]*FieldSeries, error) {
+	if fieldStore, ok := store.(FieldSeriesStore); ok {
+		return fieldStore.GetFieldSeries(ctx, projectID, datatype, query)
+	}
+
+	series := make([]*FieldSeries, 0)
+	for _, id := range query.IDs {
+		points, err := store.GetDataPointsInRange(ctx, projectID, datatype, id, query.Start, query.End)
+		if err != nil {
+			return nil, err
+		}
+		sort.SliceStable(points, func(i, j int) bool { return points[i].Time.Before(points[j].Time) })
+
+		for _, field := range query.Fields {
+			s := &FieldSeries{
+				ID:     id,
+				Field:  field,
+				Points: make([]*FieldValue, 0),
+			}
+			for _, point := range points {
+				value, ok := numericValue(lookupPath(point.Data, field))
+				if ok {
+					s.Points = append(s.Points, &FieldValue{Time: point.Time, Value: value})
+				}
+			}
+			series = append(series, s)
+		}
+	}
+	return series, nil
+}
+
+func encodeFloat(value float64) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, math.Float64bits(value))
+	return buf
+}
+
+func decodeFloat(buf []byte) (float64, bool) {
+	if len(buf) != 8 {
+		return 0, false
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(buf)), true
+}
+
+// fieldPoints joins field values reported at the same instant back into data points
+type fieldPoints map[int64]map[string]interface{}
+
+func (fp fieldPoints) add(t time.Time, field string, value float64) {
+	values, ok := fp[t.UnixNano()]
+	if !ok {
+		values = make(map[string]interface{})
+		fp[t.UnixNano()] = values
+	}
+	values[field] = value
+}
+
+func (fp fieldPoints) dataPoints() []*DataPoint {
+	times := make([]int64, 0, len(fp))
+	for t := range fp {
+		times = append(times, t)
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+
+	points := make([]*DataPoint, 0, len(times))
+	for _, t := range times {
+		data, err := flat.Unflatten(fp[t], nil)
+		if err != nil {
+			data = fp[t]
+		}
+		points = append(points, &DataPoint{
+			Time: time.Unix(0, t).UTC(),
+			Data: data,
+		})
+	}
+	return points
+}
+
+// fieldWalker visits the values of a field of a series in time order
+type fieldWalker func(id, field string, visit func(time.Time, float64))
+
+// pointsFromFields joins the given fields of a series back into data points
+func pointsFromFields(id string, fields []string, walk fieldWalker) []*DataPoint {
+	points := make(fieldPoints)
+	for _, field := range fields {
+		field := field
+		walk(id, field, func(t time.Time, value float64) {
+			points.add(t, field, value)
+		})
+	}
+	return points.dataPoints()
+}
+
+// aggregateFields runs an aggregator per field and merges their buckets
+func aggregateFields(query AggregationQuery, id string, fields []string, walk fieldWalker) []*AggregatedPoint {
+	results := make([][]*AggregatedPoint, 0, len(fields))
+	for _, field := range fields {
+		field := field
+		agg := newAggregator(query)
+		walk(id, field, func(t time.Time, value float64) {
+			agg.add(valuePoint(t, field, value))
+		})
+		results = append(results, agg.result())
+	}
+	return mergeAggregations(results)
+}
+
+func seriesFromFields(query FieldQuery, walk fieldWalker) []*FieldSeries {
+	series := make([]*FieldSeries, 0, len(query.IDs)*len(query.Fields))
+	for _, id := range query.IDs {
+		for _, field := range query.Fields {
+			fs := &FieldSeries{
+				ID:     id,
+				Field:  field,
+				Points: make([]*FieldValue, 0),
+			}
+			walk(id, field, func(t time.Time, value float64) {
+				fs.Points = append(fs.Points, &FieldValue{Time: t, Value: value})
+			})
+			series = append(series, fs)
+		}
+	}
+	return series
+}
+
+// valuePoint feeds a single field value to an aggregator
+func valuePoint(t time.Time, field string, value float64) *DataPoint {
+	return &DataPoint{
+		Time:  t,
+		Stats: map[string]*FieldStats{field: {Min: value, Max: value, Avg: value, Count: 1}},
+	}
+}
+
+// mergeAggregations joins per field aggregation results by bucket
+func mergeAggregations(results [][]*AggregatedPoint) []*AggregatedPoint {
+	byTime := make(map[int64]*AggregatedPoint)
+	for _, points := range results {
+		for _, point := range points {
+			merged, ok := byTime[point.Time.UnixNano()]
+			if !ok {
+				byTime[point.Time.UnixNano()] = point
+				continue
+			}
+			for field, values := range point.Values {
+				merged.Values[field] = values
+			}
+		}
+	}
+
+	merged := make([]*AggregatedPoint, 0, len(byTime))
+	for _, point := range byTime {
+		merged = append(merged, point)
+	}
+	sort.Slice(merged, func(i, j int) bool { return merged[i].Time.Before(merged[j].Time) })
+	return merged
+}
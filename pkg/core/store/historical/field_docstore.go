@@ -0,0 +1,167 @@
+package historical
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"gocloud.dev/docstore"
+)
+
+// fieldDocStore keeps one document per device, field path and instant, times are
+// stored as unix nanoseconds so they compare with sub-second precision
+type fieldDocStore struct {
+	coll *docstore.Collection
+}
+
+// NewFieldTimeSeriesDocStore create a field layout historical store using a goacloud.dev/docstore collection
+func NewFieldTimeSeriesDocStore(coll *docstore.Collection) TimeSeriesStore {
+	return &fieldDocStore{
+		coll: coll,
+	}
+}
+
+// InsertDataPoint keeps the numeric fields of the payload, other values are dropped
+func (s *fieldDocStore) InsertDataPoint(ctx context.Context, projectID string, datatype string, id string, reportedTime time.Time, data map[string]interface{}) error {
+	values := numericFields(data)
+	if len(values) == 0 {
+		return nil
+	}
+
+	actions := s.coll.Actions()
+	for field, value := range values {
+		actions = actions.Create(map[string]interface{}{
+			"projectID": projectID,
+			"deviceID":  id,
+			"type":      datatype,
+			"field":     field,
+			"t":         reportedTime.UnixNano(),
+			"value":     value,
+		})
+	}
+	return actions.Do(ctx)
+}
+
+func (s *fieldDocStore) GetDataPointsInRange(ctx context.Context, projectID string, datatype string, id string, start time.Time, end time.Time) ([]*DataPoint, error) {
+	points := make(fieldPoints)
+	err := s.walk(ctx, projectID, datatype, id, "", start, end, func(field string, t time.Time, value float64) {
+		points.add(t, field, value)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return points.dataPoints(), nil
+}
+
+// AggregateDataPoints queries each requested field on its own, or every field in time order
+func (s *fieldDocStore) AggregateDataPoints(ctx context.Context, projectID string, datatype string, id string, query AggregationQuery) ([]*AggregatedPoint, error) {
+	err := query.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(query.Fields) == 0 {
+		agg := newAggregator(query)
+		err = s.walk(ctx, projectID, datatype, id, "", query.Start, query.End, func(field string, t time.Time, value float64) {
+			agg.add(valuePoint(t, field, value))
+		})
+		if err != nil {
+			return nil, err
+		}
+		return agg.result(), nil
+	}
+
+	results := make([][]*AggregatedPoint, 0, len(query.Fields))
+	for _, field := range query.Fields {
+		agg := newAggregator(query)
+		err = s.walk(ctx, projectID, datatype, id, field, query.Start, query.End, func(field string, t time.Time, value float64) {
+			agg.add(valuePoint(t, field, value))
+		})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, agg.result())
+	}
+	return mergeAggregations(results), nil
+}
+
+func (s *fieldDocStore) GetFieldSeries(ctx context.Context, projectID string, datatype string, query FieldQuery) ([]*FieldSeries, error) {
+	series := make([]*FieldSeries, 0)
+	for _, id := range query.IDs {
+		for _, field := range query.Fields {
+			fs := &FieldSeries{
+				ID:     id,
+				Field:  field,
+				Points: make([]*FieldValue, 0),
+			}
+			err := s.walk(ctx, projectID, datatype, id, field, query.Start, query.End, func(field string, t time.Time, value float64) {
+				fs.Points = append(fs.Points, &FieldValue{Time: t, Value: value})
+			})
+			if err != nil {
+				return nil, err
+			}
+			series = append(series, fs)
+		}
+	}
+	return series, nil
+}
+
+// walk visits values of a device in time order, of every field when field is empty
+func (s *fieldDocStore) walk(ctx context.Context, projectID, datatype, id, field string, start, end time.Time, visit func(string, time.Time, float64)) error {
+	q := s.coll.
+		Query().
+		Where("projectID", "=", projectID).
+		Where("deviceID", "=", id).
+		Where("type", "=", datatype)
+	if field != "" {
+		q = q.Where("field", "=", field)
+	}
+
+	iter := q.
+		Where("t", ">=", unixNanos(start)).
+		Where("t", "<=", unixNanos(end)).
+		OrderBy("t", "asc").
+		Get(ctx, "field", "t", "value")
+
+	defer iter.Stop()
+
+	for {
+		doc := make(map[string]interface{})
+		err := iter.Next(ctx, doc)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		docField, _ := doc["field"].(string)
+		nanos, ok := int64Value(doc["t"])
+		if !ok {
+			continue
+		}
+		value, ok := numericValue(doc["value"])
+		if !ok {
+			continue
+		}
+		visit(docField, time.Unix(0, nanos).UTC(), value)
+	}
+}
+
+// int64Value reads nanoseconds without the precision loss of a float64
+func int64Value(v interface{}) (int64, bool) {
+	switch value := v.(type) {
+	case int64:
+		return value, true
+	case int:
+		return int64(value), true
+	case int32:
+		return int64(value), true
+	case float64:
+		return int64(value), true
+	}
+	return 0, false
+}
+
+func (s *fieldDocStore) DeleteSeriesForProject(ctx context.Context, projectID string) error {
+	return deleteProjectDocs(ctx, s.coll, projectID)
+}
@@ -0,0 +1,144 @@
+package historical
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
+	bolt "go.etcd.io/bbolt"
+)
+
+// localFieldStore keeps one bucket per device, with a nested bucket of numeric values per field path
+type localFieldStore struct {
+	db *bolt.DB
+}
+
+func NewFieldTimeSeriesLocalStore(db *bolt.DB) TimeSeriesStore {
+	return &localFieldStore{
+		db: db,
+	}
+}
+
+func getFieldBucketName(projectID, datatype, id string) string {
+	return tenancy.Key(projectID, fmt.Sprintf("fields_%s_%s", datatype, id))
+}
+
+// InsertDataPoint keeps the numeric fields of the payload, other values are dropped
+func (s *localFieldStore) InsertDataPoint(ctx context.Context, projectID string, datatype string, id string, reportedTime time.Time, data map[string]interface{}) error {
+	values := numericFields(data)
+	if len(values) == 0 {
+		return nil
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck, err := tx.CreateBucketIfNotExists([]byte(getFieldBucketName(projectID, datatype, id)))
+		if err != nil {
+			return err
+		}
+
+		for field, value := range values {
+			fieldBuck, err := buck.CreateBucketIfNotExists([]byte(field))
+			if err != nil {
+				return err
+			}
+			seq, err := fieldBuck.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = fieldBuck.Put(pointKey(reportedTime, seq), encodeFloat(value))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *localFieldStore) GetDataPointsInRange(ctx context.Context, projectID string, datatype string, id string, start time.Time, end time.Time) ([]*DataPoint, error) {
+	var points []*DataPoint
+	err := s.db.View(func(tx *bolt.Tx) error {
+		fields := storedFields(tx, getFieldBucketName(projectID, datatype, id))
+		points = pointsFromFields(id, fields, s.walker(tx, projectID, datatype, start, end))
+		return nil
+	})
+	return points, err
+}
+
+// AggregateDataPoints only reads the series of the requested fields
+func (s *localFieldStore) AggregateDataPoints(ctx context.Context, projectID string, datatype string, id string, query AggregationQuery) ([]*AggregatedPoint, error) {
+	err := query.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	var points []*AggregatedPoint
+	err = s.db.View(func(tx *bolt.Tx) error {
+		fields := query.Fields
+		if len(fields) == 0 {
+			fields = storedFields(tx, getFieldBucketName(projectID, datatype, id))
+		}
+		points = aggregateFields(query, id, fields, s.walker(tx, projectID, datatype, query.Start, query.End))
+		return nil
+	})
+	return points, err
+}
+
+func (s *localFieldStore) GetFieldSeries(ctx context.Context, projectID string, datatype string, query FieldQuery) ([]*FieldSeries, error) {
+	var series []*FieldSeries
+	err := s.db.View(func(tx *bolt.Tx) error {
+		series = seriesFromFields(query, s.walker(tx, projectID, datatype, query.Start, query.End))
+		return nil
+	})
+	return series, err
+}
+
+func (s *localFieldStore) walker(tx *bolt.Tx, projectID, datatype string, start, end time.Time) fieldWalker {
+	return func(id, field string, visit func(time.Time, float64)) {
+		walkField(tx, projectID, datatype, id, field, start, end, visit)
+	}
+}
+
+// storedFields lists the field paths of a series, kept as nested buckets
+func storedFields(tx *bolt.Tx, bucketName string) []string {
+	fields := make([]string, 0)
+	buck := tx.Bucket([]byte(bucketName))
+	if buck == nil {
+		return fields
+	}
+	buck.ForEach(func(k, v []byte) error {
+		// Nested buckets have nil values
+		if v == nil {
+			fields = append(fields, string(k))
+		}
+		return nil
+	})
+	return fields
+}
+
+func walkField(tx *bolt.Tx, projectID, datatype, id, field string, start, end time.Time, visit func(time.Time, float64)) {
+	buck := tx.Bucket([]byte(getFieldBucketName(projectID, datatype, id)))
+	if buck == nil {
+		return
+	}
+	fieldBuck := buck.Bucket([]byte(field))
+	if fieldBuck == nil {
+		return
+	}
+
+	c := fieldBuck.Cursor()
+	for k, v := c.Seek(pointKey(start, 0)); k != nil; k, v = c.Next() {
+		t, ok := pointKeyTime(k)
+		if !ok || t.After(end) {
+			break
+		}
+		value, ok := decodeFloat(v)
+		if ok {
+			visit(t, value)
+		}
+	}
+}
+
+func (s *localFieldStore) DeleteSeriesForProject(ctx context.Context, projectID string) error {
+	return deleteLocalSeries(s.db, projectID)
+}
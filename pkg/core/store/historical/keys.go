@@ -0,0 +1,253 @@
+package historical
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strings"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	historyBucketPrefix = "history_"
+	pointKeySize        = 16
+)
+
+// pointKey sorts points by time with nanosecond resolution, the sequence keeps points
+// reported at the same instant apart
+func pointKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, pointKeySize)
+	binary.BigEndian.PutUint64(key[:8], uint64(unixNanos(t)))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// unixNanos clamps times out of the int64 nanoseconds range, like a zero range start,
+// to the epoch and to the largest representable time
+func unixNanos(t time.Time) int64 {
+	if !t.After(time.Unix(0, 0)) {
+		return 0
+	}
+	if t.After(time.Unix(0, math.MaxInt64)) {
+		return math.MaxInt64
+	}
+	return t.UnixNano()
+}
+
+func pointKeyTime(key []byte) (time.Time, bool) {
+	if len(key) != pointKeySize {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8]))).UTC(), true
+}
+
+// legacyKeyTime parses keys written before points were keyed by pointKey. Legacy keys
+// are RFC3339 text, which sorts after every binary key until 2078
+func legacyKeyTime(key []byte) (time.Time, bool) {
+	if len(key) == pointKeySize {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, string(key))
+	return t, err == nil
+}
+
+// MigrateLegacyKeys rewrites RFC3339 keyed points of every history bucket to the
+// nanosecond keys, one transaction per bucket. It returns the number of migrated points
+func MigrateLegacyKeys(db *bolt.DB) (int, error) {
+	names := make([]string, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, buck *bolt.Bucket) error {
+			if isHistoryBucket(string(name)) {
+				names = append(names, string(name))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, name := range names {
+		err = db.Update(func(tx *bolt.Tx) error {
+			buck := tx.Bucket([]byte(name))
+			if buck == nil {
+				return nil
+			}
+
+			migrated, err := migrateBucket(buck)
+			total += migrated
+			return err
+		})
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// isHistoryBucket matches raw point buckets, namespaced or written before namespaces
+func isHistoryBucket(name string) bool {
+	if _, rest, ok := tenancy.SplitKey(name); ok {
+		name = rest
+	}
+	return strings.HasPrefix(name, historyBucketPrefix)
+}
+
+// migrateBucket rewrites the legacy keys of a history bucket inside the caller transaction
+func migrateBucket(buck *bolt.Bucket) (int, error) {
+	type legacyPoint struct {
+		key   []byte
+		t     time.Time
+		value []byte
+	}
+	legacy := make([]legacyPoint, 0)
+	// Seeking past the binary keys, at most one legacy key per second exists
+	c := buck.Cursor()
+	for k, v := c.Seek([]byte("0")); k != nil; k, v = c.Next() {
+		t, ok := legacyKeyTime(k)
+		if !ok {
+			continue
+		}
+		legacy = append(legacy, legacyPoint{
+			key:   append([]byte{}, k...),
+			t:     t,
+			value: append([]byte{}, v...),
+		})
+	}
+
+	for _, point := range legacy {
+		seq, err := buck.NextSequence()
+		if err != nil {
+			return 0, err
+		}
+		err = buck.Put(pointKey(point.t, seq), point.value)
+		if err != nil {
+			return 0, err
+		}
+		err = buck.Delete(point.key)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(legacy), nil
+}
+
+// NamespaceSeries moves the series buckets written before stores were namespaced by project
+// to the namespace projectOf gives, watermarks follow their series. It returns the number of moved buckets
+func NamespaceSeries(db *bolt.DB, projectOf func(datatype, id string) string) (int, error) {
+	moved, err := tenancy.RenameBuckets(db, func(name string) (string, bool) {
+		datatype, id, ok := legacySeries(name)
+		if !ok {
+			return "", false
+		}
+		return tenancy.Key(projectOf(datatype, id), name), true
+	})
+	if err != nil {
+		return moved, err
+	}
+
+	return moved, db.Update(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(watermarkBucket))
+		if buck == nil {
+			return nil
+		}
+
+		type entry struct{ key, value []byte }
+		legacy := make([]entry, 0)
+		err := buck.ForEach(func(k, v []byte) error {
+			if strings.Count(string(k), tenancy.Separator) == 1 {
+				legacy = append(legacy, entry{key: append([]byte{}, k...), value: append([]byte{}, v...)})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, e := range legacy {
+			series, _, _ := tenancy.SplitKey(string(e.key))
+			datatype, id, ok := legacySeries(series)
+			if !ok {
+				continue
+			}
+			err = buck.Put([]byte(tenancy.Key(projectOf(datatype, id), string(e.key))), e.value)
+			if err != nil {
+				return err
+			}
+			err = buck.Delete(e.key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// legacySeries parses the datatype and ID out of a series bucket name without namespace
+func legacySeries(name string) (datatype, id string, ok bool) {
+	if strings.Contains(name, tenancy.Separator) || name == watermarkBucket {
+		return "", "", false
+	}
+
+	var rest string
+	switch {
+	case strings.HasPrefix(name, historyBucketPrefix):
+		rest = strings.TrimPrefix(name, historyBucketPrefix)
+	case strings.HasPrefix(name, "fields_"):
+		rest = strings.TrimPrefix(name, "fields_")
+	case strings.HasPrefix(name, "chunks_"):
+		rest = strings.TrimPrefix(name, "chunks_")
+	case strings.HasPrefix(name, "rollup_"):
+		// Rollup names start with their resolution
+		parts := strings.SplitN(strings.TrimPrefix(name, "rollup_"), "_", 2)
+		if len(parts) != 2 {
+			return "", "", false
+		}
+		rest = parts[1]
+	default:
+		return "", "", false
+	}
+
+	parts := strings.SplitN(rest, "_", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// seriesBucketPrefixes start the bucket names of every local layout in a project namespace
+var seriesBucketPrefixes = []string{historyBucketPrefix, "rollup_", "fields_", "chunks_"}
+
+// deleteLocalSeries deletes the history of the project with its rollup watermarks. Layouts share
+// the database, so series written before switching layouts are deleted as well
+func deleteLocalSeries(db *bolt.DB, projectID string) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		_, err := tenancy.DeleteBuckets(tx, projectID, seriesBucketPrefixes...)
+		if err != nil {
+			return err
+		}
+
+		buck := tx.Bucket([]byte(watermarkBucket))
+		if buck == nil {
+			return nil
+		}
+
+		prefix := []byte(tenancy.Prefix(projectID))
+		keys := make([][]byte, 0)
+		c := buck.Cursor()
+		for k, _ := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte{}, k...))
+		}
+		for _, k := range keys {
+			err = buck.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"time"
 
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
 	bolt "go.etcd.io/bbolt"
 )
 
@@ -20,11 +21,12 @@ func NewTimeSeriesLocalStore(db *bolt.DB) TimeSeriesStore {
 	}
 }
 
-func getBucketName(datatype, id string) string {
-	return fmt.Sprintf("history_%s_%s", datatype, id)
+// getBucketName is namespaced by project, like every other bucket of a series
+func getBucketName(projectID, datatype, id string) string {
+	return tenancy.Key(projectID, fmt.Sprintf("%s%s_%s", historyBucketPrefix, datatype, id))
 }
 
-func (s *localTimeSeriesStore) InsertDataPoint(ctx context.Context, datatype string, id string, reportedTime time.Time, data map[string]interface{}) error {
+func (s *localTimeSeriesStore) InsertDataPoint(ctx context.Context, projectID string, datatype string, id string, reportedTime time.Time, data map[string]interface{}) error {
 	tx, err := s.db.Begin(true)
 	if err != nil {
 		return err
@@ -36,7 +38,7 @@ func (s *localTimeSeriesStore) InsertDataPoint(ctx context.Context, datatype str
 		}
 	}()
 
-	buck, err := tx.CreateBucketIfNotExists([]byte(getBucketName(datatype, id)))
+	buck, err := tx.CreateBucketIfNotExists([]byte(getBucketName(projectID, datatype, id)))
 	if err != nil {
 		return err
 	}
@@ -46,7 +48,12 @@ func (s *localTimeSeriesStore) InsertDataPoint(ctx context.Context, datatype str
 		return err
 	}
 
-	err = buck.Put([]byte(reportedTime.Format(time.RFC3339)), value)
+	seq, err := buck.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	err = buck.Put(pointKey(reportedTime, seq), value)
 	if err != nil {
 		return err
 	}
@@ -60,39 +67,342 @@ func (s *localTimeSeriesStore) InsertDataPoint(ctx context.Context, datatype str
 	return nil
 }
 
-func (s *localTimeSeriesStore) GetDataPointsInRange(ctx context.Context, datatype string, id string, start time.Time, end time.Time) ([]*DataPoint, error) {
+func (s *localTimeSeriesStore) GetDataPointsInRange(ctx context.Context, projectID string, datatype string, id string, start time.Time, end time.Time) ([]*DataPoint, error) {
 	points := make([]*DataPoint, 0)
-
 	err := s.db.View(func(tx *bolt.Tx) error {
-		min := []byte(start.Format(time.RFC3339))
-		max := []byte(end.Format(time.RFC3339))
+		walkRange(tx, projectID, datatype, id, start, end, func(point *DataPoint) {
+			points = append(points, point)
+		})
+		return nil
+	})
+	return points, err
+}
+
+// AggregateDataPoints streams the range through the aggregator in a single transaction,
+// without loading the whole range in memory
+func (s *localTimeSeriesStore) AggregateDataPoints(ctx context.Context, projectID string, datatype string, id string, query AggregationQuery) ([]*AggregatedPoint, error) {
+	err := query.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	agg := newAggregator(query)
+	err = s.db.View(func(tx *bolt.Tx) error {
+		walkRange(tx, projectID, datatype, id, query.Start, query.End, agg.add)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return agg.result(), nil
+}
+
+// walkRange visits points in time order, each part of the range from the finest data kept
+// for it: raw points, then minute rollups where raw points were compacted, then hour rollups.
+// The resolution doesn't depend on the size of the range, exports rely on getting everything kept
+func walkRange(tx *bolt.Tx, projectID, datatype string, id string, start time.Time, end time.Time, visit func(*DataPoint)) {
+	// Each resolution covers from its own expiry up to where the finer one starts
+	rawStart := watermark(tx, projectID, datatype, id, rawWatermark)
+	lowers := make([]time.Time, len(Resolutions))
+	uppers := make([]time.Time, len(Resolutions))
+	upper := rawStart
+	for i, res := range Resolutions {
+		lowers[i] = watermark(tx, projectID, datatype, id, res.Name)
+		uppers[i] = upper
+		upper = lowers[i]
+	}
+
+	for i := len(Resolutions) - 1; i >= 0; i-- {
+		from, to := lowers[i], uppers[i]
+		if start.After(from) {
+			from = start
+		}
+		if !to.After(from) {
+			continue
+		}
+		for _, point := range rollupPointsInRange(tx, projectID, datatype, id, Resolutions[i], from, to, end) {
+			visit(point)
+		}
+	}
+
+	buck := tx.Bucket([]byte(getBucketName(projectID, datatype, id)))
+	if buck == nil {
+		return
+	}
+
+	// Points not yet migrated from RFC3339 keys come first, they are the oldest
+	for _, point := range legacyPointsInRange(buck, start, end) {
+		visit(point)
+	}
+
+	if rawStart.After(start) {
+		start = rawStart
+	}
+	c := buck.Cursor()
+	for k, v := c.Seek(pointKey(start, 0)); k != nil; k, v = c.Next() {
+		t, ok := pointKeyTime(k)
+		if !ok || t.After(end) {
+			break
+		}
+
+		point, err := newDataPoint(t, v)
+		if err != nil {
+			continue
+		}
+		visit(point)
+	}
+}
+
+func legacyPointsInRange(buck *bolt.Bucket, start time.Time, end time.Time) []*DataPoint {
+	points := make([]*DataPoint, 0)
+	min := []byte(start.Format(time.RFC3339))
+	max := []byte(end.Format(time.RFC3339))
+
+	c := buck.Cursor()
+	for k, v := c.Seek(min); k != nil && bytes.Compare(k, max) <= 0; k, v = c.Next() {
+		t, ok := legacyKeyTime(k)
+		if !ok {
+			continue
+		}
+
+		point, err := newDataPoint(t, v)
+		if err != nil {
+			continue
+		}
+		points = append(points, point)
+	}
+	return points
+}
+
+func newDataPoint(t time.Time, value []byte) (*DataPoint, error) {
+	data := make(map[string]interface{})
+	err := json.Unmarshal(value, &data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DataPoint{
+		Time: t,
+		Data: data,
+	}, nil
+}
+
+const (
+	watermarkBucket = "rollup_watermarks"
+	rawWatermark    = "raw"
+)
+
+func getRollupBucketName(resolution Resolution, projectID, datatype, id string) string {
+	return tenancy.Key(projectID, fmt.Sprintf("rollup_%s_%s_%s", resolution.Name, datatype, id))
+}
+
+// watermark returns the time before which data of a series was removed for a resolution,
+// raw points being compacted and rollups expired. Zero when nothing was removed
+func watermark(tx *bolt.Tx, projectID, datatype, id, name string) time.Time {
+	buck := tx.Bucket([]byte(watermarkBucket))
+	if buck == nil {
+		return time.Time{}
+	}
+	t, _ := pointKeyTime(buck.Get([]byte(getBucketName(projectID, datatype, id) + "/" + name)))
+	return t
+}
+
+// setWatermark only moves watermarks forward
+func setWatermark(tx *bolt.Tx, projectID, datatype, id, name string, before time.Time) error {
+	if watermark(tx, projectID, datatype, id, name).After(before) {
+		return nil
+	}
+	buck, err := tx.CreateBucketIfNotExists([]byte(watermarkBucket))
+	if err != nil {
+		return err
+	}
+	return buck.Put([]byte(getBucketName(projectID, datatype, id)+"/"+name), pointKey(before, 0))
+}
+
+// rollupPointsInRange reads rollups whose bucket starts in [from, to), not after end
+func rollupPointsInRange(tx *bolt.Tx, projectID, datatype, id string, resolution Resolution, from, to, end time.Time) []*DataPoint {
+	points := make([]*DataPoint, 0)
+	buck := tx.Bucket([]byte(getRollupBucketName(resolution, projectID, datatype, id)))
+	if buck == nil {
+		return points
+	}
+
+	c := buck.Cursor()
+	for k, v := c.Seek(pointKey(from.Truncate(resolution.Duration), 0)); k != nil; k, v = c.Next() {
+		t, ok := pointKeyTime(k)
+		if !ok || !t.Before(to) || t.After(end) {
+			break
+		}
+
+		rollup := &Rollup{Time: t}
+		err := json.Unmarshal(v, &rollup.Fields)
+		if err != nil {
+			continue
+		}
+		points = append(points, rollup.toDataPoint(resolution))
+	}
+	return points
+}
 
-		buck := tx.Bucket([]byte(getBucketName(datatype, id)))
+func (s *localTimeSeriesStore) CompactDataPoints(ctx context.Context, projectID string, datatype string, id string, before time.Time) (int, error) {
+	count := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(getBucketName(projectID, datatype, id)))
 		if buck == nil {
 			return nil
 		}
 
+		// Legacy keys would otherwise never be compacted
+		_, err := migrateBucket(buck)
+		if err != nil {
+			return err
+		}
+
+		keys := make([][]byte, 0)
+		points := make([]*DataPoint, 0)
 		c := buck.Cursor()
-		for k, v := c.Seek(min); k != nil && bytes.Compare(k, max) <= 0; k, v = c.Next() {
-			t, err := time.Parse(time.RFC3339, string(k))
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			t, ok := pointKeyTime(k)
+			if !ok || !t.Before(before) {
+				break
+			}
+			keys = append(keys, append([]byte{}, k...))
+
+			point, err := newDataPoint(t, v)
 			if err != nil {
 				continue
 			}
+			points = append(points, point)
+		}
 
-			data := make(map[string]interface{})
-			err = json.Unmarshal(v, &data)
+		for _, res := range Resolutions {
+			err = putRollups(tx, projectID, datatype, id, res, rollupPoints(points, res), true)
 			if err != nil {
-				continue
+				return err
 			}
+		}
 
-			point := &DataPoint{
-				Time: t,
-				Data: data,
+		for _, k := range keys {
+			err = buck.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		count = len(keys)
+
+		return setWatermark(tx, projectID, datatype, id, rawWatermark, before)
+	})
+	return count, err
+}
+
+// putRollups writes rollups, merging them with the stored ones of the same bucket or replacing them
+func putRollups(tx *bolt.Tx, projectID, datatype, id string, resolution Resolution, rollups []*Rollup, merge bool) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	buck, err := tx.CreateBucketIfNotExists([]byte(getRollupBucketName(resolution, projectID, datatype, id)))
+	if err != nil {
+		return err
+	}
+
+	for _, rollup := range rollups {
+		key := pointKey(rollup.Time, 0)
+		if stored := buck.Get(key); stored != nil && merge {
+			fields := make(map[string]*FieldStats)
+			if json.Unmarshal(stored, &fields) == nil {
+				for field, stats := range rollup.Fields {
+					if existing, ok := fields[field]; ok {
+						stats.Merge(existing)
+					}
+				}
+				for field, stats := range fields {
+					if _, ok := rollup.Fields[field]; !ok {
+						rollup.Fields[field] = stats
+					}
+				}
+			}
+		}
+
+		value, err := json.Marshal(rollup.Fields)
+		if err != nil {
+			return err
+		}
+		err = buck.Put(key, value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreRollups moves the raw watermark past the end of the last rollup, the raw points it
+// covers were compacted. walkRange picks the resolution of a bucket by its start, finer
+// watermarks are only moved past the start of the last rollup
+func (s *localTimeSeriesStore) RestoreRollups(ctx context.Context, projectID string, datatype string, id string, resolution Resolution, rollups []*Rollup) error {
+	if len(rollups) == 0 {
+		return nil
+	}
+
+	last := rollups[0].Time
+	for _, rollup := range rollups {
+		if rollup.Time.After(last) {
+			last = rollup.Time
+		}
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := putRollups(tx, projectID, datatype, id, resolution, rollups, false)
+		if err != nil {
+			return err
+		}
+
+		err = setWatermark(tx, projectID, datatype, id, rawWatermark, last.Truncate(resolution.Duration).Add(resolution.Duration))
+		if err != nil {
+			return err
+		}
+		for _, res := range Resolutions {
+			if res.Duration >= resolution.Duration {
+				break
+			}
+			err = setWatermark(tx, projectID, datatype, id, res.Name, last.Add(time.Nanosecond))
+			if err != nil {
+				return err
 			}
-			points = append(points, point)
 		}
 		return nil
 	})
+}
 
-	return points, err
+func (s *localTimeSeriesStore) ExpireRollups(ctx context.Context, projectID string, datatype string, id string, resolution Resolution, before time.Time) (int, error) {
+	count := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(getRollupBucketName(resolution, projectID, datatype, id)))
+		if buck != nil {
+			keys := make([][]byte, 0)
+			c := buck.Cursor()
+			for k, _ := c.First(); k != nil; k, _ = c.Next() {
+				t, ok := pointKeyTime(k)
+				if !ok || !t.Before(before) {
+					break
+				}
+				keys = append(keys, append([]byte{}, k...))
+			}
+
+			for _, k := range keys {
+				err := buck.Delete(k)
+				if err != nil {
+					return err
+				}
+			}
+			count = len(keys)
+		}
+
+		return setWatermark(tx, projectID, datatype, id, resolution.Name, before)
+	})
+	return count, err
+}
+
+func (s *localTimeSeriesStore) DeleteSeriesForProject(ctx context.Context, projectID string) error {
+	return deleteLocalSeries(s.db, projectID)
 }
@@ -0,0 +1,117 @@
+package historical
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/jeremywohl/flatten"
+	"github.com/nqd/flat"
+)
+
+// Rollup holds the stats of every numeric field reported during a bucket
+type Rollup struct {
+	Time   time.Time
+	Fields map[string]*FieldStats
+}
+
+// Add accounts a value in the stats
+func (s *FieldStats) Add(value float64) {
+	s.Merge(&FieldStats{Min: value, Max: value, Avg: value, Count: 1})
+}
+
+// Merge combines stats of the same field, like two buckets of a finer resolution
+func (s *FieldStats) Merge(other *FieldStats) {
+	if other.Count == 0 {
+		return
+	}
+	if s.Count == 0 {
+		*s = *other
+		return
+	}
+	if other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if other.Max > s.Max {
+		s.Max = other.Max
+	}
+	total := s.Count + other.Count
+	s.Avg = (s.Avg*float64(s.Count) + other.Avg*float64(other.Count)) / float64(total)
+	s.Count = total
+}
+
+// rollupPoints buckets points by resolution, points must be in time order
+func rollupPoints(points []*DataPoint, resolution Resolution) []*Rollup {
+	rollups := make([]*Rollup, 0)
+	var current *Rollup
+	for _, point := range points {
+		bucket := point.Time.Truncate(resolution.Duration)
+		if current == nil || !current.Time.Equal(bucket) {
+			current = &Rollup{
+				Time:   bucket,
+				Fields: make(map[string]*FieldStats),
+			}
+			rollups = append(rollups, current)
+		}
+
+		for field, value := range numericFields(point.Data) {
+			stats, ok := current.Fields[field]
+			if !ok {
+				stats = &FieldStats{}
+				current.Fields[field] = stats
+			}
+			stats.Add(value)
+		}
+	}
+	return rollups
+}
+
+// numericFields flattens a point to dotted paths, keeping values readable as numbers
+func numericFields(data map[string]interface{}) map[string]float64 {
+	fields := make(map[string]float64)
+	flattened, err := flatten.Flatten(data, "", flatten.DotStyle)
+	if err != nil {
+		return fields
+	}
+
+	for field, v := range flattened {
+		if value, ok := numericValue(v); ok {
+			fields[field] = value
+		}
+	}
+	return fields
+}
+
+// numericValue reads JSON numbers and numeric text, payloads may carry either
+func numericValue(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case float64:
+		return value, true
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case string:
+		number, err := strconv.ParseFloat(value, 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+// toDataPoint exposes a rollup like a raw point, with the field averages as data
+func (r *Rollup) toDataPoint(resolution Resolution) *DataPoint {
+	averages := make(map[string]interface{}, len(r.Fields))
+	for field, stats := range r.Fields {
+		averages[field] = stats.Avg
+	}
+	data, err := flat.Unflatten(averages, nil)
+	if err != nil {
+		data = averages
+	}
+
+	return &DataPoint{
+		Time:       r.Time,
+		Data:       data,
+		Resolution: resolution.Name,
+		Stats:      r.Fields,
+	}
+}
@@ -6,11 +6,63 @@ import (
 )
 
 type TimeSeriesStore interface {
-	InsertDataPoint(ctx context.Context, datatype string, id string, time time.Time, data map[string]interface{}) error
-	GetDataPointsInRange(ctx context.Context, datatype string, id string, start time.Time, end time.Time) ([]*DataPoint, error)
+	InsertDataPoint(ctx context.Context, projectID string, datatype string, id string, time time.Time, data map[string]interface{}) error
+	GetDataPointsInRange(ctx context.Context, projectID string, datatype string, id string, start time.Time, end time.Time) ([]*DataPoint, error)
+	AggregateDataPoints(ctx context.Context, projectID string, datatype string, id string, query AggregationQuery) ([]*AggregatedPoint, error)
+	// DeleteSeriesForProject deletes the history of every series of the project
+	DeleteSeriesForProject(ctx context.Context, projectID string) error
+}
+
+// RollupStore is implemented by time series stores able to downsample their history.
+// Once compacted, GetDataPointsInRange answers older ranges from the finest rollups kept,
+// whatever the size of the range. Coarser data is requested with AggregateDataPoints
+type RollupStore interface {
+	// CompactDataPoints rolls raw points reported before the cutoff up to every
+	// resolution and deletes them, returning the number of compacted points
+	CompactDataPoints(ctx context.Context, projectID string, datatype string, id string, before time.Time) (int, error)
+	// ExpireRollups deletes rollups of a resolution older than the cutoff
+	ExpireRollups(ctx context.Context, projectID string, datatype string, id string, resolution Resolution, before time.Time) (int, error)
+	// RestoreRollups writes exported rollups of a resolution, replacing the stored ones of
+	// the same buckets. Reads answer their range from them, finer data of the range is hidden
+	RestoreRollups(ctx context.Context, projectID string, datatype string, id string, resolution Resolution, rollups []*Rollup) error
 }
 
 type DataPoint struct {
 	Time time.Time              `json:"time"`
 	Data map[string]interface{} `json:"data"`
+	// Resolution and Stats are set on rollups, Data then holds the average of each field
+	Resolution string                 `json:"resolution,omitempty"`
+	Stats      map[string]*FieldStats `json:"stats,omitempty"`
+}
+
+// Resolution is the bucket size of a rollup
+type Resolution struct {
+	Name     string
+	Duration time.Duration
+}
+
+var (
+	ResolutionMinute = Resolution{Name: "1m", Duration: time.Minute}
+	ResolutionHour   = Resolution{Name: "1h", Duration: time.Hour}
+)
+
+// Resolutions lists rollup resolutions from the finest
+var Resolutions = []Resolution{ResolutionMinute, ResolutionHour}
+
+// ResolutionByName finds a resolution of Resolutions by name
+func ResolutionByName(name string) (Resolution, bool) {
+	for _, res := range Resolutions {
+		if res.Name == name {
+			return res, true
+		}
+	}
+	return Resolution{}, false
+}
+
+// FieldStats aggregates the numeric values of a field over a rollup bucket
+type FieldStats struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+	Count int64   `json:"count"`
 }
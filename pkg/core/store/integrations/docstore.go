@@ -0,0 +1,145 @@
+package integrations
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+type webhookDocStore struct {
+	webhooksColl   *docstore.Collection
+	deliveriesColl *docstore.Collection
+}
+
+// NewWebhookDocStore create a webhook store using goacloud.dev/docstore collections
+func NewWebhookDocStore(webhooksColl, deliveriesColl *docstore.Collection) WebhookStore {
+	return &webhookDocStore{
+		webhooksColl:   webhooksColl,
+		deliveriesColl: deliveriesColl,
+	}
+}
+
+func (s *webhookDocStore) GetWebhookByID(ctx context.Context, projectID, id string) (*Webhook, error) {
+	webhook := &Webhook{ID: id}
+	err := s.webhooksColl.Get(ctx, webhook)
+	if err != nil {
+		code := gcerrors.Code(err)
+		if code == gcerrors.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if webhook.ProjectID != projectID {
+		return nil, nil
+	}
+
+	return webhook, nil
+}
+
+func (s *webhookDocStore) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	return s.webhooksColl.Create(ctx, webhook)
+}
+
+func (s *webhookDocStore) DeleteWebhook(ctx context.Context, projectID, id string) error {
+	webhook, err := s.GetWebhookByID(ctx, projectID, id)
+	if err != nil || webhook == nil {
+		return err
+	}
+
+	err = s.webhooksColl.Delete(ctx, webhook)
+	if err != nil {
+		return err
+	}
+	return s.deleteDeliveries(ctx, projectID, id, 0)
+}
+
+func (s *webhookDocStore) ListWebhooksForProject(ctx context.Context, projectID string) ([]*Webhook, error) {
+	iter := s.webhooksColl.
+		Query().
+		Where("projectID", "=", projectID).
+		Get(ctx)
+	defer iter.Stop()
+
+	webhooks := make([]*Webhook, 0)
+	for {
+		webhook := &Webhook{}
+		err := iter.Next(ctx, webhook)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+func (s *webhookDocStore) InsertDelivery(ctx context.Context, delivery *Delivery) error {
+	webhook, err := s.GetWebhookByID(ctx, delivery.ProjectID, delivery.WebhookID)
+	if err != nil || webhook == nil {
+		return err
+	}
+
+	err = s.deliveriesColl.Create(ctx, delivery)
+	if err != nil {
+		return err
+	}
+	return s.deleteDeliveries(ctx, delivery.ProjectID, delivery.WebhookID, deliveryLogSize)
+}
+
+// deleteDeliveries deletes the deliveries of the webhook, except the most recent keep ones
+func (s *webhookDocStore) deleteDeliveries(ctx context.Context, projectID, webhookID string, keep int) error {
+	deliveries, err := s.listDeliveries(ctx, projectID, webhookID)
+	if err != nil || len(deliveries) <= keep {
+		return err
+	}
+
+	actions := s.deliveriesColl.Actions()
+	for _, delivery := range deliveries[keep:] {
+		actions.Delete(delivery)
+	}
+	return actions.Do(ctx)
+}
+
+func (s *webhookDocStore) ListDeliveries(ctx context.Context, projectID, webhookID string, limit int) ([]*Delivery, error) {
+	deliveries, err := s.listDeliveries(ctx, projectID, webhookID)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+// listDeliveries loads the log of the webhook, most recent first. Logs are capped to deliveryLogSize,
+// they are sorted here as not every docstore driver orders by fields missing from the filters
+func (s *webhookDocStore) listDeliveries(ctx context.Context, projectID, webhookID string) ([]*Delivery, error) {
+	iter := s.deliveriesColl.
+		Query().
+		Where("projectID", "=", projectID).
+		Where("webhookID", "=", webhookID).
+		Get(ctx)
+	defer iter.Stop()
+
+	deliveries := make([]*Delivery, 0)
+	for {
+		delivery := &Delivery{}
+		err := iter.Next(ctx, delivery)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Time.After(deliveries[j].Time)
+	})
+	return deliveries, nil
+}
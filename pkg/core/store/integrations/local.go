@@ -0,0 +1,186 @@
+package integrations
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type webhookLocalStore struct {
+	db *bolt.DB
+}
+
+const (
+	webhookBucketPrefix  = "webhooks_"
+	deliveryBucketPrefix = "webhook_deliveries_"
+)
+
+func NewWebhookLocalStore(db *bolt.DB) WebhookStore {
+	return &webhookLocalStore{
+		db: db,
+	}
+}
+
+func (s *webhookLocalStore) GetWebhookByID(ctx context.Context, projectID, id string) (*Webhook, error) {
+	var webhook *Webhook
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(webhookBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+
+		v := buck.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+
+		webhook = &Webhook{}
+		return json.Unmarshal(v, webhook)
+	})
+	return webhook, err
+}
+
+func (s *webhookLocalStore) CreateWebhook(ctx context.Context, webhook *Webhook) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck, err := tx.CreateBucketIfNotExists([]byte(webhookBucketPrefix + webhook.ProjectID))
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(webhook)
+		if err != nil {
+			return err
+		}
+
+		return buck.Put([]byte(webhook.ID), value)
+	})
+}
+
+func (s *webhookLocalStore) DeleteWebhook(ctx context.Context, projectID, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(webhookBucketPrefix + projectID))
+		if buck != nil {
+			err := buck.Delete([]byte(id))
+			if err != nil {
+				return err
+			}
+		}
+
+		err := tx.DeleteBucket([]byte(deliveryBucketPrefix + id))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *webhookLocalStore) ListWebhooksForProject(ctx context.Context, projectID string) ([]*Webhook, error) {
+	webhooks := make([]*Webhook, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(webhookBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+
+		return buck.ForEach(func(k, v []byte) error {
+			webhook := &Webhook{}
+			err := json.Unmarshal(v, webhook)
+			if err != nil {
+				return err
+			}
+			webhooks = append(webhooks, webhook)
+			return nil
+		})
+	})
+	return webhooks, err
+}
+
+func (s *webhookLocalStore) InsertDelivery(ctx context.Context, delivery *Delivery) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		// Deliveries finishing after the webhook was deleted would create its log again
+		webhooks := tx.Bucket([]byte(webhookBucketPrefix + delivery.ProjectID))
+		if webhooks == nil || webhooks.Get([]byte(delivery.WebhookID)) == nil {
+			return nil
+		}
+
+		buck, err := tx.CreateBucketIfNotExists([]byte(deliveryBucketPrefix + delivery.WebhookID))
+		if err != nil {
+			return err
+		}
+
+		seq, err := buck.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(delivery)
+		if err != nil {
+			return err
+		}
+
+		err = buck.Put(deliveryKey(delivery.Time, seq), value)
+		if err != nil {
+			return err
+		}
+
+		return pruneDeliveries(buck)
+	})
+}
+
+// pruneDeliveries drops the oldest deliveries of the bucket beyond deliveryLogSize
+func pruneDeliveries(buck *bolt.Bucket) error {
+	c := buck.Cursor()
+	k, _ := c.Last()
+	for i := 0; k != nil && i < deliveryLogSize; i++ {
+		k, _ = c.Prev()
+	}
+
+	// Keys are collected first, deleting moves the cursor
+	keys := make([][]byte, 0)
+	for ; k != nil; k, _ = c.Prev() {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		err := buck.Delete(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *webhookLocalStore) ListDeliveries(ctx context.Context, projectID, webhookID string, limit int) ([]*Delivery, error) {
+	deliveries := make([]*Delivery, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(deliveryBucketPrefix + webhookID))
+		if buck == nil {
+			return nil
+		}
+
+		// Most recent first
+		c := buck.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(deliveries) >= limit {
+				break
+			}
+			delivery := &Delivery{}
+			err := json.Unmarshal(v, delivery)
+			if err != nil || delivery.ProjectID != projectID {
+				continue
+			}
+			deliveries = append(deliveries, delivery)
+		}
+		return nil
+	})
+	return deliveries, err
+}
+
+func deliveryKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
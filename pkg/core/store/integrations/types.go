@@ -0,0 +1,82 @@
+package integrations
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// deliveryLogSize is how many deliveries are kept per webhook, older ones are pruned on insert
+const deliveryLogSize = 500
+
+type WebhookStore interface {
+	GetWebhookByID(ctx context.Context, projectID, id string) (*Webhook, error)
+	CreateWebhook(ctx context.Context, webhook *Webhook) error
+	DeleteWebhook(ctx context.Context, projectID, id string) error
+	ListWebhooksForProject(ctx context.Context, projectID string) ([]*Webhook, error)
+
+	// InsertDelivery logs a delivery, keeping the last deliveryLogSize of its webhook.
+	// Deliveries of deleted webhooks are dropped
+	InsertDelivery(ctx context.Context, delivery *Delivery) error
+	ListDeliveries(ctx context.Context, projectID, webhookID string, limit int) ([]*Delivery, error)
+}
+
+// Webhook is an endpoint of a customer system receiving project events
+type Webhook struct {
+	ID        string `json:"id" docstore:"id"`
+	ProjectID string `json:"projectID" docstore:"projectID"`
+	URL       string `json:"url" docstore:"url"`
+	// Events filters delivered event types, "alarm" or "alarm.*" match every alarm event.
+	// An empty list delivers everything.
+	Events []string `json:"events" docstore:"events"`
+	// Devices filters the events of devices by device ID, events not about a device are always delivered.
+	// An empty list delivers the events of every device.
+	Devices []string `json:"devices,omitempty" docstore:"devices"`
+	// Secret signs every delivery with HMAC-SHA256
+	Secret  string    `json:"secret" docstore:"secret"`
+	Enabled bool      `json:"enabled" docstore:"enabled"`
+	Created time.Time `json:"created" docstore:"created"`
+}
+
+// Accepts reports whether the webhook wants events of the given type, about the device if any
+func (w *Webhook) Accepts(eventType, deviceID string) bool {
+	if !w.Enabled || !w.acceptsDevice(deviceID) {
+		return false
+	}
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, filter := range w.Events {
+		filter = strings.TrimSuffix(filter, ".*")
+		if filter == "*" || filter == eventType || strings.HasPrefix(eventType, filter+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is the outcome of sending one event to a webhook, after retries
+type Delivery struct {
+	ID         string        `json:"id" docstore:"id"`
+	ProjectID  string        `json:"projectID" docstore:"projectID"`
+	WebhookID  string        `json:"webhookID" docstore:"webhookID"`
+	EventType  string        `json:"eventType" docstore:"eventType"`
+	Time       time.Time     `json:"time" docstore:"time"`
+	Attempts   int           `json:"attempts" docstore:"attempts"`
+	StatusCode int           `json:"statusCode,omitempty" docstore:"statusCode"`
+	Success    bool          `json:"success" docstore:"success"`
+	Error      string        `json:"error,omitempty" docstore:"error"`
+	Duration   time.Duration `json:"duration" docstore:"duration"`
+}
+
+func (w *Webhook) acceptsDevice(deviceID string) bool {
+	if len(w.Devices) == 0 || deviceID == "" {
+		return true
+	}
+	for _, id := range w.Devices {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,32 @@
+package integrations
+
+import "testing"
+
+func TestWebhookAccepts(t *testing.T) {
+	tests := []struct {
+		name      string
+		webhook   Webhook
+		eventType string
+		deviceID  string
+		accepts   bool
+	}{
+		{"no filters", Webhook{Enabled: true}, "device.state.reported", "d1", true},
+		{"disabled", Webhook{}, "device.state.reported", "d1", false},
+		{"exact event", Webhook{Enabled: true, Events: []string{"device.registered"}}, "device.registered", "d1", true},
+		{"other event", Webhook{Enabled: true, Events: []string{"device.registered"}}, "device.state.reported", "d1", false},
+		{"event prefix", Webhook{Enabled: true, Events: []string{"alarm"}}, "alarm.raised", "d1", true},
+		{"event wildcard", Webhook{Enabled: true, Events: []string{"alarm.*"}}, "alarm.cleared", "d1", true},
+		{"prefix is not a segment", Webhook{Enabled: true, Events: []string{"alarm"}}, "alarms.raised", "d1", false},
+		{"any event", Webhook{Enabled: true, Events: []string{"*"}}, "device.registered", "d1", true},
+		{"listed device", Webhook{Enabled: true, Devices: []string{"d1", "d2"}}, "device.state.reported", "d2", true},
+		{"other device", Webhook{Enabled: true, Devices: []string{"d1"}}, "device.state.reported", "d3", false},
+		{"event without device", Webhook{Enabled: true, Devices: []string{"d1"}}, "rule.triggered", "", true},
+		{"both filters", Webhook{Enabled: true, Events: []string{"alarm"}, Devices: []string{"d1"}}, "device.state.reported", "d1", false},
+	}
+
+	for _, test := range tests {
+		if got := test.webhook.Accepts(test.eventType, test.deviceID); got != test.accepts {
+			t.Errorf("%s: expected %v, got %v", test.name, test.accepts, got)
+		}
+	}
+}
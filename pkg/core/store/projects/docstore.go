@@ -2,6 +2,7 @@ package projects
 
 import (
 	"context"
+	"io"
 	"time"
 
 	"gocloud.dev/docstore"
@@ -31,8 +32,10 @@ func (s *projectDocStore) GetProjectByID(ctx context.Context, id string) (*Proje
 		return nil, err
 	}
 
+	name, _ := projectDoc["name"].(string)
 	project := &Project{
 		ID:   id,
+		Name: name,
 		Data: projectDoc,
 	}
 
@@ -44,5 +47,57 @@ func (s *projectDocStore) CreateProject(ctx context.Context, name string) error
 	data["created"] = time.Now()
 	data["projectID"] = name
 	data["name"] = name
-	return s.coll.Create(ctx, data)
+	err := s.coll.Create(ctx, data)
+	if gcerrors.Code(err) == gcerrors.AlreadyExists {
+		return ErrProjectExists
+	}
+	return err
+}
+
+func (s *projectDocStore) UpdateProject(ctx context.Context, id string, updates map[string]interface{}) error {
+	projectDoc := map[string]interface{}{
+		"projectID": id,
+	}
+
+	mods := docstore.Mods{}
+	for k, v := range updates {
+		mods[docstore.FieldPath(k)] = v
+	}
+	mods["updated"] = time.Now()
+
+	return s.coll.Actions().Update(projectDoc, mods).Do(ctx)
+}
+
+func (s *projectDocStore) DeleteProject(ctx context.Context, id string) error {
+	projectDoc := map[string]interface{}{
+		"projectID": id,
+	}
+	return s.coll.Delete(ctx, projectDoc)
+}
+
+func (s *projectDocStore) ListProjects(ctx context.Context) ([]*Project, error) {
+	iter := s.coll.
+		Query().
+		Get(ctx)
+	defer iter.Stop()
+
+	projects := make([]*Project, 0)
+	for {
+		projectDoc := make(map[string]interface{})
+		err := iter.Next(ctx, projectDoc)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		id, _ := projectDoc["projectID"].(string)
+		name, _ := projectDoc["name"].(string)
+		projects = append(projects, &Project{
+			ID:   id,
+			Name: name,
+			Data: projectDoc,
+		})
+	}
+	return projects, nil
 }
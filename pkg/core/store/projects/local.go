@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"strings"
 	"time"
 
+	"github.com/jeremywohl/flatten"
 	"github.com/nqd/flat"
 	bolt "go.etcd.io/bbolt"
 )
@@ -48,6 +50,9 @@ func (s *projectLocalStore) GetProjectByID(ctx context.Context, id string) (*Pro
 		device.Data = nestedData
 		device.ID = id
 		device.Name = id
+		if value, ok := nestedData["name"].(string); ok {
+			device.Name = value
+		}
 
 		return nil
 	})
@@ -72,14 +77,17 @@ func (s *projectLocalStore) CreateProject(ctx context.Context, name string) erro
 	}()
 
 	buck := tx.Bucket([]byte(projectBucketPrefix + name))
-	if buck == nil {
-		data["created"] = time.Now()
-		data["name"] = name
-		data["projectID"] = name
-		buck, err = tx.CreateBucketIfNotExists([]byte(projectBucketPrefix + name))
-		if err != nil {
-			return err
-		}
+	if buck != nil {
+		err = ErrProjectExists
+		return err
+	}
+
+	data["created"] = time.Now()
+	data["name"] = name
+	data["projectID"] = name
+	buck, err = tx.CreateBucket([]byte(projectBucketPrefix + name))
+	if err != nil {
+		return err
 	}
 
 	for k, v := range data {
@@ -98,3 +106,64 @@ func (s *projectLocalStore) CreateProject(ctx context.Context, name string) erro
 
 	return nil
 }
+
+func (s *projectLocalStore) UpdateProject(ctx context.Context, id string, updates map[string]interface{}) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(projectBucketPrefix + id))
+		if buck == nil {
+			return fmt.Errorf("project %s not found", id)
+		}
+
+		updates["updated"] = time.Now()
+		flattenData, err := flatten.Flatten(updates, "", flatten.PathStyle)
+		if err != nil {
+			return err
+		}
+
+		for k, v := range flattenData {
+			value := fmt.Sprintf("%v", v)
+			err = buck.Put([]byte(k), []byte(value))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *projectLocalStore) DeleteProject(ctx context.Context, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(projectBucketPrefix + id))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *projectLocalStore) ListProjects(ctx context.Context) ([]*Project, error) {
+	ids := make([]string, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, buck *bolt.Bucket) error {
+			if strings.HasPrefix(string(name), projectBucketPrefix) {
+				ids = append(ids, strings.TrimPrefix(string(name), projectBucketPrefix))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	projects := make([]*Project, 0, len(ids))
+	for _, id := range ids {
+		project, err := s.GetProjectByID(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if project != nil {
+			projects = append(projects, project)
+		}
+	}
+	return projects, nil
+}
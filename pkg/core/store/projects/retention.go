@@ -0,0 +1,94 @@
+package projects
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const retentionField = "retention"
+
+// RetentionPolicy bounds how long device history is kept at each resolution, in days.
+// Raw points older than RawDays are rolled up to 1 minute and 1 hour aggregates, minute
+// and hour rollups are then kept for MinuteDays and HourDays. Zero keeps data forever
+type RetentionPolicy struct {
+	RawDays    int `json:"rawDays"`
+	MinuteDays int `json:"minuteDays"`
+	HourDays   int `json:"hourDays"`
+}
+
+// Validate checks that each resolution is kept at least as long as the finer one
+func (p *RetentionPolicy) Validate() error {
+	if p.RawDays < 0 || p.MinuteDays < 0 || p.HourDays < 0 {
+		return fmt.Errorf("retention days can't be negative")
+	}
+	if p.MinuteDays > 0 && (p.RawDays == 0 || p.MinuteDays < p.RawDays) {
+		return fmt.Errorf("minuteDays must be greater or equal to a non zero rawDays")
+	}
+	if p.HourDays > 0 && (p.MinuteDays == 0 || p.HourDays < p.MinuteDays) {
+		return fmt.Errorf("hourDays must be greater or equal to a non zero minuteDays")
+	}
+	return nil
+}
+
+// Cutoff returns the time before which data kept for the given days expires,
+// aligned to the hour so rollup buckets are never split. Zero days never expire
+func (p *RetentionPolicy) Cutoff(now time.Time, days int) time.Time {
+	if days == 0 {
+		return time.Time{}
+	}
+	return now.Add(-time.Duration(days) * 24 * time.Hour).Truncate(time.Hour)
+}
+
+// ToMap converts the policy to the update stored under the project data
+func (p *RetentionPolicy) ToMap() map[string]interface{} {
+	return map[string]interface{}{
+		retentionField: map[string]interface{}{
+			"rawDays":    p.RawDays,
+			"minuteDays": p.MinuteDays,
+			"hourDays":   p.HourDays,
+		},
+	}
+}
+
+// Retention reads the retention policy of the project, nil when none is set
+func (p *Project) Retention() (*RetentionPolicy, error) {
+	value, ok := p.Data[retentionField].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	policy := &RetentionPolicy{}
+	fields := map[string]*int{
+		"rawDays":    &policy.RawDays,
+		"minuteDays": &policy.MinuteDays,
+		"hourDays":   &policy.HourDays,
+	}
+	for name, target := range fields {
+		days, err := intValue(value[name])
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention %s: %v", name, err)
+		}
+		*target = days
+	}
+	return policy, policy.Validate()
+}
+
+// intValue reads numbers stored natively by docstores or as text by the local store
+func intValue(v interface{}) (int, error) {
+	switch value := v.(type) {
+	case nil:
+		return 0, nil
+	case int:
+		return value, nil
+	case int32:
+		return int(value), nil
+	case int64:
+		return int(value), nil
+	case float64:
+		return int(value), nil
+	case string:
+		return strconv.Atoi(value)
+	}
+	return 0, fmt.Errorf("unexpected type %T", v)
+}
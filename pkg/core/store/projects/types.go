@@ -2,11 +2,17 @@ package projects
 
 import (
 	"context"
+	"errors"
 )
 
+var ErrProjectExists = errors.New("project already exists")
+
 type ProjectStore interface {
 	GetProjectByID(ctx context.Context, id string) (*Project, error)
 	CreateProject(ctx context.Context, name string) error
+	UpdateProject(ctx context.Context, id string, updates map[string]interface{}) error
+	DeleteProject(ctx context.Context, id string) error
+	ListProjects(ctx context.Context) ([]*Project, error)
 }
 
 type Project struct {
@@ -0,0 +1,182 @@
+package rules
+
+import (
+	"context"
+	"io"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+type ruleDocStore struct {
+	rulesColl  *docstore.Collection
+	statesColl *docstore.Collection
+	alertsColl *docstore.Collection
+}
+
+// NewRuleDocStore create a rule store using goacloud.dev/docstore collections
+func NewRuleDocStore(rulesColl, statesColl, alertsColl *docstore.Collection) RuleStore {
+	return &ruleDocStore{
+		rulesColl:  rulesColl,
+		statesColl: statesColl,
+		alertsColl: alertsColl,
+	}
+}
+
+func (s *ruleDocStore) GetRuleByID(ctx context.Context, projectID, id string) (*Rule, error) {
+	rule := &Rule{ID: id}
+	err := s.rulesColl.Get(ctx, rule)
+	if err != nil {
+		code := gcerrors.Code(err)
+		if code == gcerrors.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if rule.ProjectID != projectID {
+		return nil, nil
+	}
+
+	return rule, nil
+}
+
+func (s *ruleDocStore) CreateRule(ctx context.Context, rule *Rule) error {
+	return s.rulesColl.Create(ctx, rule)
+}
+
+func (s *ruleDocStore) UpdateRule(ctx context.Context, rule *Rule) error {
+	return s.rulesColl.Replace(ctx, rule)
+}
+
+func (s *ruleDocStore) DeleteRule(ctx context.Context, projectID, id string) error {
+	rule, err := s.GetRuleByID(ctx, projectID, id)
+	if err != nil || rule == nil {
+		return err
+	}
+
+	err = s.rulesColl.Delete(ctx, rule)
+	if err != nil {
+		return err
+	}
+
+	iter := s.statesColl.
+		Query().
+		Where("ruleID", "=", id).
+		Get(ctx, "id")
+	defer iter.Stop()
+
+	actions := s.statesColl.Actions()
+	for {
+		state := &RuleState{}
+		err := iter.Next(ctx, state)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		actions = actions.Delete(state)
+	}
+	return actions.Do(ctx)
+}
+
+func (s *ruleDocStore) ListRulesForProject(ctx context.Context, projectID string) ([]*Rule, error) {
+	iter := s.rulesColl.
+		Query().
+		Where("projectID", "=", projectID).
+		Get(ctx)
+	defer iter.Stop()
+
+	rules := make([]*Rule, 0)
+	for {
+		rule := &Rule{}
+		err := iter.Next(ctx, rule)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (s *ruleDocStore) GetRuleState(ctx context.Context, ruleID, deviceID string) (*RuleState, error) {
+	state := &RuleState{ID: RuleStateID(ruleID, deviceID)}
+	err := s.statesColl.Get(ctx, state)
+	if err != nil {
+		code := gcerrors.Code(err)
+		if code == gcerrors.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return state, nil
+}
+
+func (s *ruleDocStore) SaveRuleState(ctx context.Context, state *RuleState) error {
+	state.ID = RuleStateID(state.RuleID, state.DeviceID)
+	return s.statesColl.Put(ctx, state)
+}
+
+func (s *ruleDocStore) InsertAlert(ctx context.Context, alert *Alert) error {
+	return s.alertsColl.Create(ctx, alert)
+}
+
+func (s *ruleDocStore) ListAlerts(ctx context.Context, projectID string, query AlertQuery) ([]*Alert, error) {
+	q := s.alertsColl.
+		Query().
+		Where("projectID", "=", projectID)
+	if query.DeviceID != "" {
+		q = q.Where("deviceID", "=", query.DeviceID)
+	}
+	if query.RuleID != "" {
+		q = q.Where("ruleID", "=", query.RuleID)
+	}
+	if query.State != "" {
+		q = q.Where("state", "=", query.State)
+	}
+	if !query.Start.IsZero() {
+		q = q.Where("time", ">=", query.Start)
+	}
+	if !query.End.IsZero() {
+		q = q.Where("time", "<=", query.End)
+	}
+
+	iter := q.Get(ctx)
+	defer iter.Stop()
+
+	alerts := make([]*Alert, 0)
+	for {
+		alert := &Alert{}
+		err := iter.Next(ctx, alert)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, nil
+}
+
+func (s *ruleDocStore) DeleteAlertsForProject(ctx context.Context, projectID string) error {
+	iter := s.alertsColl.
+		Query().
+		Where("projectID", "=", projectID).
+		Get(ctx, "id")
+	defer iter.Stop()
+
+	actions := s.alertsColl.Actions()
+	for {
+		alert := &Alert{}
+		err := iter.Next(ctx, alert)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		actions = actions.Delete(alert)
+	}
+	return actions.Do(ctx)
+}
@@ -0,0 +1,220 @@
+package rules
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type ruleLocalStore struct {
+	db *bolt.DB
+}
+
+const (
+	ruleBucketPrefix      = "rules_"
+	ruleStateBucketPrefix = "rulestate_"
+	alertBucketPrefix     = "alerts_"
+)
+
+func NewRuleLocalStore(db *bolt.DB) RuleStore {
+	return &ruleLocalStore{
+		db: db,
+	}
+}
+
+func (s *ruleLocalStore) GetRuleByID(ctx context.Context, projectID, id string) (*Rule, error) {
+	var rule *Rule
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(ruleBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+
+		v := buck.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+
+		rule = &Rule{}
+		return json.Unmarshal(v, rule)
+	})
+
+	return rule, err
+}
+
+func (s *ruleLocalStore) CreateRule(ctx context.Context, rule *Rule) error {
+	return s.putRule(rule)
+}
+
+func (s *ruleLocalStore) UpdateRule(ctx context.Context, rule *Rule) error {
+	return s.putRule(rule)
+}
+
+func (s *ruleLocalStore) putRule(rule *Rule) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck, err := tx.CreateBucketIfNotExists([]byte(ruleBucketPrefix + rule.ProjectID))
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(rule)
+		if err != nil {
+			return err
+		}
+
+		return buck.Put([]byte(rule.ID), value)
+	})
+}
+
+func (s *ruleLocalStore) DeleteRule(ctx context.Context, projectID, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(ruleBucketPrefix + projectID))
+		if buck != nil {
+			err := buck.Delete([]byte(id))
+			if err != nil {
+				return err
+			}
+		}
+
+		err := tx.DeleteBucket([]byte(ruleStateBucketPrefix + id))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *ruleLocalStore) ListRulesForProject(ctx context.Context, projectID string) ([]*Rule, error) {
+	rules := make([]*Rule, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(ruleBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+
+		return buck.ForEach(func(k, v []byte) error {
+			rule := &Rule{}
+			err := json.Unmarshal(v, rule)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, rule)
+			return nil
+		})
+	})
+
+	return rules, err
+}
+
+func (s *ruleLocalStore) GetRuleState(ctx context.Context, ruleID, deviceID string) (*RuleState, error) {
+	var state *RuleState
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(ruleStateBucketPrefix + ruleID))
+		if buck == nil {
+			return nil
+		}
+
+		v := buck.Get([]byte(deviceID))
+		if v == nil {
+			return nil
+		}
+
+		state = &RuleState{}
+		return json.Unmarshal(v, state)
+	})
+
+	return state, err
+}
+
+func (s *ruleLocalStore) SaveRuleState(ctx context.Context, state *RuleState) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck, err := tx.CreateBucketIfNotExists([]byte(ruleStateBucketPrefix + state.RuleID))
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(state)
+		if err != nil {
+			return err
+		}
+
+		return buck.Put([]byte(state.DeviceID), value)
+	})
+}
+
+func (s *ruleLocalStore) InsertAlert(ctx context.Context, alert *Alert) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck, err := tx.CreateBucketIfNotExists([]byte(alertBucketPrefix + alert.ProjectID))
+		if err != nil {
+			return err
+		}
+
+		seq, err := buck.NextSequence()
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(alert)
+		if err != nil {
+			return err
+		}
+
+		return buck.Put(alertKey(alert.Time, seq), value)
+	})
+}
+
+func (s *ruleLocalStore) ListAlerts(ctx context.Context, projectID string, query AlertQuery) ([]*Alert, error) {
+	alerts := make([]*Alert, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(alertBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+
+		c := buck.Cursor()
+		var k, v []byte
+		if query.Start.IsZero() {
+			k, v = c.First()
+		} else {
+			k, v = c.Seek(alertKey(query.Start, 0))
+		}
+
+		for ; k != nil; k, v = c.Next() {
+			alert := &Alert{}
+			err := json.Unmarshal(v, alert)
+			if err != nil {
+				continue
+			}
+			if !query.End.IsZero() && alert.Time.After(query.End) {
+				break
+			}
+			if query.matches(alert) {
+				alerts = append(alerts, alert)
+			}
+		}
+		return nil
+	})
+
+	return alerts, err
+}
+
+// alertKey sorts alerts by time, the sequence keeps alerts at the same instant apart
+func alertKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func (s *ruleLocalStore) DeleteAlertsForProject(ctx context.Context, projectID string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket([]byte(alertBucketPrefix + projectID))
+		if err != nil && err != bolt.ErrBucketNotFound {
+			return err
+		}
+		return nil
+	})
+}
@@ -0,0 +1,113 @@
+package rules
+
+import (
+	"context"
+	"time"
+)
+
+type RuleStore interface {
+	GetRuleByID(ctx context.Context, projectID, id string) (*Rule, error)
+	CreateRule(ctx context.Context, rule *Rule) error
+	UpdateRule(ctx context.Context, rule *Rule) error
+	DeleteRule(ctx context.Context, projectID, id string) error
+	ListRulesForProject(ctx context.Context, projectID string) ([]*Rule, error)
+
+	GetRuleState(ctx context.Context, ruleID, deviceID string) (*RuleState, error)
+	SaveRuleState(ctx context.Context, state *RuleState) error
+
+	InsertAlert(ctx context.Context, alert *Alert) error
+	ListAlerts(ctx context.Context, projectID string, query AlertQuery) ([]*Alert, error)
+	DeleteAlertsForProject(ctx context.Context, projectID string) error
+}
+
+// Rule is a condition evaluated against every uplink of the devices in a project
+type Rule struct {
+	ID        string `json:"id" docstore:"id"`
+	ProjectID string `json:"projectID" docstore:"projectID"`
+	Name      string `json:"name" docstore:"name"`
+	// Expression fires the rule, e.g. `temp > 40`
+	Expression string `json:"expression" docstore:"expression"`
+	// For is how long the expression must hold before firing, e.g. "5m"
+	For string `json:"for,omitempty" docstore:"for"`
+	// ClearExpression resolves a firing rule, defaults to the negated expression.
+	// Use it for hysteresis, e.g. fire on `temp > 40` and clear on `temp < 38`.
+	ClearExpression string `json:"clearExpression,omitempty" docstore:"clearExpression"`
+	// ClearFor is how long the clear condition must hold before resolving
+	ClearFor string    `json:"clearFor,omitempty" docstore:"clearFor"`
+	Severity string    `json:"severity,omitempty" docstore:"severity"`
+	Enabled  bool      `json:"enabled" docstore:"enabled"`
+	Created  time.Time `json:"created" docstore:"created"`
+	Updated  time.Time `json:"updated" docstore:"updated"`
+}
+
+// Rule evaluation status for a single device
+const (
+	StatusInactive  = "inactive"
+	StatusPending   = "pending"
+	StatusFiring    = "firing"
+	StatusResolving = "resolving"
+)
+
+// RuleState keeps track of a rule for a single device between uplinks
+type RuleState struct {
+	ID            string    `json:"id" docstore:"id"`
+	RuleID        string    `json:"ruleID" docstore:"ruleID"`
+	DeviceID      string    `json:"deviceID" docstore:"deviceID"`
+	Status        string    `json:"status" docstore:"status"`
+	Since         time.Time `json:"since" docstore:"since"`
+	FiredAt       time.Time `json:"firedAt,omitempty" docstore:"firedAt"`
+	LastEvaluated time.Time `json:"lastEvaluated" docstore:"lastEvaluated"`
+}
+
+// RuleStateID builds the key of the state of a rule for a device
+func RuleStateID(ruleID, deviceID string) string {
+	return ruleID + "/" + deviceID
+}
+
+// Alert states
+const (
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert records a rule firing or resolving for a device
+type Alert struct {
+	ID         string                 `json:"id" docstore:"id"`
+	ProjectID  string                 `json:"projectID" docstore:"projectID"`
+	RuleID     string                 `json:"ruleID" docstore:"ruleID"`
+	RuleName   string                 `json:"ruleName" docstore:"ruleName"`
+	DeviceID   string                 `json:"deviceID" docstore:"deviceID"`
+	Severity   string                 `json:"severity,omitempty" docstore:"severity"`
+	State      string                 `json:"state" docstore:"state"`
+	Time       time.Time              `json:"time" docstore:"time"`
+	Expression string                 `json:"expression" docstore:"expression"`
+	Values     map[string]interface{} `json:"values,omitempty" docstore:"values"`
+}
+
+// AlertQuery filters alerts, zero values match everything
+type AlertQuery struct {
+	DeviceID string
+	RuleID   string
+	State    string
+	Start    time.Time
+	End      time.Time
+}
+
+func (q AlertQuery) matches(alert *Alert) bool {
+	if q.DeviceID != "" && alert.DeviceID != q.DeviceID {
+		return false
+	}
+	if q.RuleID != "" && alert.RuleID != q.RuleID {
+		return false
+	}
+	if q.State != "" && alert.State != q.State {
+		return false
+	}
+	if !q.Start.IsZero() && alert.Time.Before(q.Start) {
+		return false
+	}
+	if !q.End.IsZero() && alert.Time.After(q.End) {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,75 @@
+package templates
+
+import (
+	"context"
+	"io"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+type templateDocStore struct {
+	coll *docstore.Collection
+}
+
+// NewTemplateDocStore create a template store using a goacloud.dev/docstore collection
+func NewTemplateDocStore(coll *docstore.Collection) TemplateStore {
+	return &templateDocStore{
+		coll: coll,
+	}
+}
+
+func (s *templateDocStore) GetTemplateByID(ctx context.Context, projectID, id string) (*Template, error) {
+	template := &Template{ID: id}
+	err := s.coll.Get(ctx, template)
+	if err != nil {
+		code := gcerrors.Code(err)
+		if code == gcerrors.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if template.ProjectID != projectID {
+		return nil, nil
+	}
+
+	return template, nil
+}
+
+func (s *templateDocStore) CreateTemplate(ctx context.Context, template *Template) error {
+	return s.coll.Create(ctx, template)
+}
+
+func (s *templateDocStore) UpdateTemplate(ctx context.Context, template *Template) error {
+	return s.coll.Replace(ctx, template)
+}
+
+func (s *templateDocStore) DeleteTemplate(ctx context.Context, projectID, id string) error {
+	template, err := s.GetTemplateByID(ctx, projectID, id)
+	if err != nil || template == nil {
+		return err
+	}
+	return s.coll.Delete(ctx, template)
+}
+
+func (s *templateDocStore) ListTemplatesForProject(ctx context.Context, projectID string) ([]*Template, error) {
+	iter := s.coll.
+		Query().
+		Where("projectID", "=", projectID).
+		Get(ctx)
+	defer iter.Stop()
+
+	templates := make([]*Template, 0)
+	for {
+		template := &Template{}
+		err := iter.Next(ctx, template)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
@@ -0,0 +1,94 @@
+package templates
+
+import (
+	"context"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type templateLocalStore struct {
+	db *bolt.DB
+}
+
+const templateBucketPrefix = "templates_"
+
+func NewTemplateLocalStore(db *bolt.DB) TemplateStore {
+	return &templateLocalStore{
+		db: db,
+	}
+}
+
+func (s *templateLocalStore) GetTemplateByID(ctx context.Context, projectID, id string) (*Template, error) {
+	var template *Template
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(templateBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+
+		v := buck.Get([]byte(id))
+		if v == nil {
+			return nil
+		}
+
+		template = &Template{}
+		return json.Unmarshal(v, template)
+	})
+	return template, err
+}
+
+func (s *templateLocalStore) CreateTemplate(ctx context.Context, template *Template) error {
+	return s.putTemplate(template)
+}
+
+func (s *templateLocalStore) UpdateTemplate(ctx context.Context, template *Template) error {
+	return s.putTemplate(template)
+}
+
+func (s *templateLocalStore) putTemplate(template *Template) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck, err := tx.CreateBucketIfNotExists([]byte(templateBucketPrefix + template.ProjectID))
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(template)
+		if err != nil {
+			return err
+		}
+
+		return buck.Put([]byte(template.ID), value)
+	})
+}
+
+func (s *templateLocalStore) DeleteTemplate(ctx context.Context, projectID, id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(templateBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+		return buck.Delete([]byte(id))
+	})
+}
+
+func (s *templateLocalStore) ListTemplatesForProject(ctx context.Context, projectID string) ([]*Template, error) {
+	templates := make([]*Template, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(templateBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+
+		return buck.ForEach(func(k, v []byte) error {
+			template := &Template{}
+			err := json.Unmarshal(v, template)
+			if err != nil {
+				return err
+			}
+			templates = append(templates, template)
+			return nil
+		})
+	})
+	return templates, err
+}
@@ -0,0 +1,265 @@
+package templates
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type TemplateStore interface {
+	GetTemplateByID(ctx context.Context, projectID, id string) (*Template, error)
+	CreateTemplate(ctx context.Context, template *Template) error
+	UpdateTemplate(ctx context.Context, template *Template) error
+	DeleteTemplate(ctx context.Context, projectID, id string) error
+	ListTemplatesForProject(ctx context.Context, projectID string) ([]*Template, error)
+}
+
+// Field types, named after their JSON schema counterparts
+const (
+	TypeNumber  = "number"
+	TypeInteger = "integer"
+	TypeBoolean = "boolean"
+	TypeString  = "string"
+	TypeObject  = "object"
+	TypeArray   = "array"
+)
+
+var fieldTypes = map[string]bool{
+	TypeNumber:  true,
+	TypeInteger: true,
+	TypeBoolean: true,
+	TypeString:  true,
+	TypeObject:  true,
+	TypeArray:   true,
+}
+
+// Template describes every device of a product: the fields they report, the commands
+// they support and the config they start with
+type Template struct {
+	ID          string    `json:"id" docstore:"id"`
+	ProjectID   string    `json:"projectID" docstore:"projectID"`
+	Name        string    `json:"name" docstore:"name"`
+	Description string    `json:"description,omitempty" docstore:"description"`
+	Fields      []Field   `json:"fields" docstore:"fields"`
+	Commands    []Command `json:"commands" docstore:"commands"`
+	// DefaultConfig is the desired config of the devices, keyed by field path. It is published as a
+	// device.config event for the integrations whenever the template is assigned to a device
+	DefaultConfig map[string]interface{} `json:"defaultConfig,omitempty" docstore:"defaultConfig"`
+	Created       time.Time              `json:"created" docstore:"created"`
+	Updated       time.Time              `json:"updated" docstore:"updated"`
+}
+
+// Field is a value of the device state at a dotted path, like sensors.temperature
+type Field struct {
+	Path        string   `json:"path" docstore:"path"`
+	Type        string   `json:"type" docstore:"type"`
+	Unit        string   `json:"unit,omitempty" docstore:"unit"`
+	Minimum     *float64 `json:"minimum,omitempty" docstore:"minimum"`
+	Maximum     *float64 `json:"maximum,omitempty" docstore:"maximum"`
+	Description string   `json:"description,omitempty" docstore:"description"`
+}
+
+// Command is an action supported by the devices, Input lists its parameters
+type Command struct {
+	Name        string  `json:"name" docstore:"name"`
+	Description string  `json:"description,omitempty" docstore:"description"`
+	Input       []Field `json:"input,omitempty" docstore:"input"`
+}
+
+// ValidationError tells which field of a state doesn't conform to the template
+type ValidationError struct {
+	Path   string
+	Reason string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("field %s %s", e.Path, e.Reason)
+}
+
+// Validate checks the fields, the commands and that the default config conforms to the fields
+func (t *Template) Validate() error {
+	if t.Name == "" {
+		return fmt.Errorf("missing template name")
+	}
+	if err := validateFields(t.Fields); err != nil {
+		return err
+	}
+
+	names := make(map[string]bool, len(t.Commands))
+	for _, command := range t.Commands {
+		if command.Name == "" {
+			return fmt.Errorf("missing command name")
+		}
+		if names[command.Name] {
+			return fmt.Errorf("duplicate command %s", command.Name)
+		}
+		names[command.Name] = true
+		if err := validateFields(command.Input); err != nil {
+			return fmt.Errorf("command %s: %v", command.Name, err)
+		}
+	}
+
+	for path, value := range t.DefaultConfig {
+		field := t.Field(path)
+		if field == nil {
+			return fmt.Errorf("default config of unknown field %s", path)
+		}
+		if err := field.Check(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateFields(fields []Field) error {
+	paths := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		if field.Path == "" {
+			return fmt.Errorf("missing field path")
+		}
+		if paths[field.Path] {
+			return fmt.Errorf("duplicate field %s", field.Path)
+		}
+		paths[field.Path] = true
+		if !fieldTypes[field.Type] {
+			return fmt.Errorf("field %s has unknown type %q", field.Path, field.Type)
+		}
+		if (field.Minimum != nil || field.Maximum != nil) && field.Type != TypeNumber && field.Type != TypeInteger {
+			return fmt.Errorf("field %s of type %s can not have a range", field.Path, field.Type)
+		}
+		if field.Minimum != nil && field.Maximum != nil && *field.Minimum > *field.Maximum {
+			return fmt.Errorf("field %s has a minimum above its maximum", field.Path)
+		}
+	}
+	return nil
+}
+
+// Field returns the field at the path, nil when the template doesn't have it
+func (t *Template) Field(path string) *Field {
+	for i := range t.Fields {
+		if t.Fields[i].Path == path {
+			return &t.Fields[i]
+		}
+	}
+	return nil
+}
+
+// Command returns the command with the name, nil when the template doesn't support it
+func (t *Template) Command(name string) *Command {
+	for i := range t.Commands {
+		if t.Commands[i].Name == name {
+			return &t.Commands[i]
+		}
+	}
+	return nil
+}
+
+// ValidateInput checks the parameters present in the input of the command, like ValidateState
+func (c *Command) ValidateInput(input map[string]interface{}) error {
+	for _, field := range c.Input {
+		value, ok := lookupPath(input, field.Path)
+		if !ok || value == nil {
+			continue
+		}
+		if err := field.Check(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateState checks the fields present in a reported state, which may be partial.
+// Values missing from the template are accepted, devices may report more than their template
+func (t *Template) ValidateState(state map[string]interface{}) error {
+	for _, field := range t.Fields {
+		value, ok := lookupPath(state, field.Path)
+		if !ok || value == nil {
+			continue
+		}
+		if err := field.Check(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check validates a value against the type and range of the field. Text payloads carry every
+// value as a string, strings are accepted for numbers and booleans when they parse as one
+func (f *Field) Check(value interface{}) error {
+	switch f.Type {
+	case TypeNumber, TypeInteger:
+		number, ok := numberValue(value)
+		if !ok {
+			return &ValidationError{Path: f.Path, Reason: "must be a " + f.Type}
+		}
+		if f.Type == TypeInteger && number != math.Trunc(number) {
+			return &ValidationError{Path: f.Path, Reason: "must be an integer"}
+		}
+		if f.Minimum != nil && number < *f.Minimum {
+			return &ValidationError{Path: f.Path, Reason: fmt.Sprintf("is below the minimum %v", *f.Minimum)}
+		}
+		if f.Maximum != nil && number > *f.Maximum {
+			return &ValidationError{Path: f.Path, Reason: fmt.Sprintf("is above the maximum %v", *f.Maximum)}
+		}
+	case TypeBoolean:
+		switch v := value.(type) {
+		case bool:
+		case string:
+			if _, err := strconv.ParseBool(v); err != nil {
+				return &ValidationError{Path: f.Path, Reason: "must be a boolean"}
+			}
+		default:
+			return &ValidationError{Path: f.Path, Reason: "must be a boolean"}
+		}
+	case TypeString:
+		if _, ok := value.(string); !ok {
+			return &ValidationError{Path: f.Path, Reason: "must be a string"}
+		}
+	case TypeObject:
+		if _, ok := value.(map[string]interface{}); !ok {
+			return &ValidationError{Path: f.Path, Reason: "must be an object"}
+		}
+	case TypeArray:
+		if _, ok := value.([]interface{}); !ok {
+			return &ValidationError{Path: f.Path, Reason: "must be an array"}
+		}
+	}
+	return nil
+}
+
+func numberValue(v interface{}) (float64, bool) {
+	switch value := v.(type) {
+	case float64:
+		return value, true
+	case float32:
+		return float64(value), true
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case uint64:
+		return float64(value), true
+	case string:
+		number, err := strconv.ParseFloat(value, 64)
+		return number, err == nil
+	}
+	return 0, false
+}
+
+func lookupPath(data map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = m[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,57 @@
+package templates
+
+import (
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+)
+
+// DeviceView is a device described by its template: each field with its current value,
+// the supported commands and the default config
+type DeviceView struct {
+	*devices.Device
+	Template      *TemplateRef           `json:"template,omitempty"`
+	Fields        []FieldValue           `json:"fields"`
+	Commands      []Command              `json:"commands"`
+	DefaultConfig map[string]interface{} `json:"defaultConfig,omitempty"`
+}
+
+// TemplateRef names the template of a view
+type TemplateRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// FieldValue is a field of the template with the value reported by the device, nil when missing.
+// Error tells why the value doesn't conform to the field
+type FieldValue struct {
+	Field
+	Value interface{} `json:"value"`
+	Error string      `json:"error,omitempty"`
+}
+
+// NewView describes the device with its template, the template may be nil
+func NewView(device *devices.Device, template *Template) *DeviceView {
+	view := &DeviceView{
+		Device:   device,
+		Fields:   make([]FieldValue, 0),
+		Commands: make([]Command, 0),
+	}
+	if template == nil {
+		return view
+	}
+
+	view.Template = &TemplateRef{ID: template.ID, Name: template.Name}
+	for i := range template.Fields {
+		field := FieldValue{Field: template.Fields[i]}
+		value, ok := lookupPath(device.Data, field.Path)
+		if ok && value != nil {
+			field.Value = value
+			if err := field.Check(value); err != nil {
+				field.Error = err.Error()
+			}
+		}
+		view.Fields = append(view.Fields, field)
+	}
+	view.Commands = append(view.Commands, template.Commands...)
+	view.DefaultConfig = template.DefaultConfig
+	return view
+}
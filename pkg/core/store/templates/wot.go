@@ -0,0 +1,149 @@
+package templates
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// thingDescription holds the parts of a W3C WoT Thing Description a template is built from,
+// see https://www.w3.org/TR/wot-thing-description/
+type thingDescription struct {
+	Title       string                       `json:"title"`
+	Description string                       `json:"description"`
+	Properties  map[string]*dataSchema       `json:"properties"`
+	Actions     map[string]*actionAffordance `json:"actions"`
+}
+
+type dataSchema struct {
+	Description string                 `json:"description"`
+	Type        string                 `json:"type"`
+	Unit        string                 `json:"unit"`
+	Minimum     *float64               `json:"minimum"`
+	Maximum     *float64               `json:"maximum"`
+	ReadOnly    bool                   `json:"readOnly"`
+	Default     interface{}            `json:"default"`
+	Properties  map[string]*dataSchema `json:"properties"`
+}
+
+type actionAffordance struct {
+	Description string      `json:"description"`
+	Input       *dataSchema `json:"input"`
+}
+
+// inputValueField names the parameter of actions taking a single value instead of an object
+const inputValueField = "value"
+
+// FromThingDescription builds a template from a Thing Description. Properties become fields,
+// objects being flattened into dotted paths, and actions become commands. Defaults of writable
+// properties make the default config
+func FromThingDescription(data []byte) (*Template, error) {
+	td := &thingDescription{}
+	if err := json.Unmarshal(data, td); err != nil {
+		return nil, fmt.Errorf("invalid thing description: %v", err)
+	}
+	if td.Title == "" {
+		return nil, fmt.Errorf("thing description without title")
+	}
+
+	template := &Template{
+		Name:          td.Title,
+		Description:   td.Description,
+		Fields:        make([]Field, 0, len(td.Properties)),
+		Commands:      make([]Command, 0, len(td.Actions)),
+		DefaultConfig: make(map[string]interface{}),
+	}
+
+	for _, name := range sortedKeys(td.Properties) {
+		for _, field := range schemaFields(name, td.Properties[name]) {
+			template.Fields = append(template.Fields, field.Field)
+			if field.writable && field.defaultValue != nil {
+				template.DefaultConfig[field.Path] = field.defaultValue
+			}
+		}
+	}
+
+	for _, name := range sortedKeys(td.Actions) {
+		action := td.Actions[name]
+		command := Command{
+			Name:        name,
+			Description: action.Description,
+		}
+		if action.Input != nil {
+			if action.Input.Type == TypeObject && len(action.Input.Properties) > 0 {
+				for _, key := range sortedKeys(action.Input.Properties) {
+					for _, field := range schemaFields(key, action.Input.Properties[key]) {
+						command.Input = append(command.Input, field.Field)
+					}
+				}
+			} else {
+				for _, field := range schemaFields(inputValueField, action.Input) {
+					command.Input = append(command.Input, field.Field)
+				}
+			}
+		}
+		template.Commands = append(template.Commands, command)
+	}
+
+	return template, nil
+}
+
+type schemaField struct {
+	Field
+	writable     bool
+	defaultValue interface{}
+}
+
+// schemaFields flattens a schema into the fields at its path, objects with properties
+// into the fields of their properties
+func schemaFields(path string, schema *dataSchema) []schemaField {
+	if schema == nil {
+		return nil
+	}
+	if schema.Type == TypeObject && len(schema.Properties) > 0 {
+		fields := make([]schemaField, 0, len(schema.Properties))
+		for _, name := range sortedKeys(schema.Properties) {
+			for _, field := range schemaFields(path+"."+name, schema.Properties[name]) {
+				field.writable = field.writable && !schema.ReadOnly
+				fields = append(fields, field)
+			}
+		}
+		return fields
+	}
+
+	// Untyped and null schemas can't be checked, they are left out
+	if !fieldTypes[schema.Type] {
+		return nil
+	}
+	field := Field{
+		Path:        path,
+		Type:        schema.Type,
+		Unit:        schema.Unit,
+		Description: schema.Description,
+	}
+	if schema.Type == TypeNumber || schema.Type == TypeInteger {
+		field.Minimum = schema.Minimum
+		field.Maximum = schema.Maximum
+	}
+	return []schemaField{{
+		Field:        field,
+		writable:     !schema.ReadOnly,
+		defaultValue: schema.Default,
+	}}
+}
+
+func sortedKeys(m interface{}) []string {
+	keys := make([]string, 0)
+	switch v := m.(type) {
+	case map[string]*dataSchema:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	case map[string]*actionAffordance:
+		for key := range v {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
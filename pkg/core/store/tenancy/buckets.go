@@ -0,0 +1,34 @@
+package tenancy
+
+import (
+	"bytes"
+	"strings"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DeleteBuckets deletes the root buckets of the project namespace whose name starts with one
+// of the prefixes, returning the number of deleted buckets
+func DeleteBuckets(tx *bolt.Tx, projectID string, prefixes ...string) (int, error) {
+	// Buckets of a namespace sort together, they are collected first as deleting moves the cursor
+	namespace := []byte(Prefix(projectID))
+	names := make([][]byte, 0)
+	c := tx.Cursor()
+	for k, _ := c.Seek(namespace); k != nil && bytes.HasPrefix(k, namespace); k, _ = c.Next() {
+		name := string(k[len(namespace):])
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, append([]byte{}, k...))
+				break
+			}
+		}
+	}
+
+	for _, name := range names {
+		err := tx.DeleteBucket(name)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(names), nil
+}
@@ -0,0 +1,70 @@
+package tenancy
+
+import (
+	bolt "go.etcd.io/bbolt"
+)
+
+// Renamer returns the namespaced name of a root bucket, false to leave the bucket as is
+type Renamer func(name string) (string, bool)
+
+// RenameBuckets moves the root buckets selected by rename to their namespaced names,
+// one transaction per bucket so large databases don't need a single huge one.
+// Contents are merged into target buckets that already exist. It returns the number of moved buckets
+func RenameBuckets(db *bolt.DB, rename Renamer) (int, error) {
+	type move struct{ from, to string }
+	moves := make([]move, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, buck *bolt.Bucket) error {
+			if to, ok := rename(string(name)); ok && to != string(name) {
+				moves = append(moves, move{from: string(name), to: to})
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for i, m := range moves {
+		err = db.Update(func(tx *bolt.Tx) error {
+			src := tx.Bucket([]byte(m.from))
+			if src == nil {
+				return nil
+			}
+			dst, err := tx.CreateBucketIfNotExists([]byte(m.to))
+			if err != nil {
+				return err
+			}
+			err = copyBucket(src, dst)
+			if err != nil {
+				return err
+			}
+			return tx.DeleteBucket([]byte(m.from))
+		})
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(moves), nil
+}
+
+// copyBucket copies keys and nested buckets, keeping the sequence ahead of both
+func copyBucket(src, dst *bolt.Bucket) error {
+	if src.Sequence() > dst.Sequence() {
+		err := dst.SetSequence(src.Sequence())
+		if err != nil {
+			return err
+		}
+	}
+
+	return src.ForEach(func(k, v []byte) error {
+		if v != nil {
+			return dst.Put(append([]byte{}, k...), append([]byte{}, v...))
+		}
+		nested, err := dst.CreateBucketIfNotExists(k)
+		if err != nil {
+			return err
+		}
+		return copyBucket(src.Bucket(k), nested)
+	})
+}
@@ -0,0 +1,41 @@
+// Package tenancy namespaces store keys by project, so stores can't reach the data of
+// another project than the one they are given
+package tenancy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Unassigned is the namespace of devices not registered to any project yet
+const Unassigned = ""
+
+// Separator ends namespaces, project and device IDs can't contain it
+const Separator = "/"
+
+// Prefix is the start of every key of the project namespace
+func Prefix(projectID string) string {
+	return projectID + Separator
+}
+
+// Key namespaces an ID or bucket name by project
+func Key(projectID, name string) string {
+	return Prefix(projectID) + name
+}
+
+// SplitKey returns the project and the name of a namespaced key
+func SplitKey(key string) (projectID, name string, ok bool) {
+	i := strings.Index(key, Separator)
+	if i < 0 {
+		return "", "", false
+	}
+	return key[:i], key[i+len(Separator):], true
+}
+
+// ValidateID rejects project and device IDs which would break namespaces
+func ValidateID(id string) error {
+	if id == "" || strings.Contains(id, Separator) {
+		return fmt.Errorf("invalid id %q, ids can't be empty or contain %q", id, Separator)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package users
+
+import (
+	"context"
+	"io"
+
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+type userDocStore struct {
+	usersColl   *docstore.Collection
+	membersColl *docstore.Collection
+}
+
+// NewUserDocStore create a user store using goacloud.dev/docstore collections,
+// members are keyed by <projectID>/<email>
+func NewUserDocStore(usersColl, membersColl *docstore.Collection) UserStore {
+	return &userDocStore{
+		usersColl:   usersColl,
+		membersColl: membersColl,
+	}
+}
+
+func (s *userDocStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	user := &User{Email: email}
+	err := s.usersColl.Get(ctx, user)
+	if err != nil {
+		code := gcerrors.Code(err)
+		if code == gcerrors.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return user, nil
+}
+
+func (s *userDocStore) CreateUser(ctx context.Context, user *User) error {
+	err := s.usersColl.Create(ctx, user)
+	if gcerrors.Code(err) == gcerrors.AlreadyExists {
+		return ErrUserExists
+	}
+	return err
+}
+
+func (s *userDocStore) UpdateUser(ctx context.Context, user *User) error {
+	return s.usersColl.Replace(ctx, user)
+}
+
+func (s *userDocStore) GetMember(ctx context.Context, projectID, email string) (*Member, error) {
+	member := &Member{ID: projectID + "/" + email}
+	err := s.membersColl.Get(ctx, member)
+	if err != nil {
+		code := gcerrors.Code(err)
+		if code == gcerrors.NotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return member, nil
+}
+
+func (s *userDocStore) PutMember(ctx context.Context, member *Member) error {
+	return s.membersColl.Put(ctx, member)
+}
+
+func (s *userDocStore) DeleteMember(ctx context.Context, projectID, email string) error {
+	err := s.membersColl.Delete(ctx, &Member{ID: projectID + "/" + email})
+	if gcerrors.Code(err) == gcerrors.NotFound {
+		return nil
+	}
+	return err
+}
+
+func (s *userDocStore) ListMembersForProject(ctx context.Context, projectID string) ([]*Member, error) {
+	iter := s.membersColl.
+		Query().
+		Where("projectID", "=", projectID).
+		Get(ctx)
+	defer iter.Stop()
+
+	members := make([]*Member, 0)
+	for {
+		member := &Member{}
+		err := iter.Next(ctx, member)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
@@ -0,0 +1,136 @@
+package users
+
+import (
+	"context"
+	"encoding/json"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type userLocalStore struct {
+	db *bolt.DB
+}
+
+const (
+	userBucket         = "users"
+	memberBucketPrefix = "members_"
+)
+
+func NewUserLocalStore(db *bolt.DB) UserStore {
+	return &userLocalStore{
+		db: db,
+	}
+}
+
+func (s *userLocalStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	user := &User{}
+	found, err := s.get(userBucket, email, user)
+	if !found {
+		return nil, err
+	}
+	return user, err
+}
+
+func (s *userLocalStore) CreateUser(ctx context.Context, user *User) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck, err := tx.CreateBucketIfNotExists([]byte(userBucket))
+		if err != nil {
+			return err
+		}
+		if buck.Get([]byte(user.Email)) != nil {
+			return ErrUserExists
+		}
+
+		value, err := json.Marshal(user)
+		if err != nil {
+			return err
+		}
+
+		return buck.Put([]byte(user.Email), value)
+	})
+}
+
+func (s *userLocalStore) UpdateUser(ctx context.Context, user *User) error {
+	return s.put(userBucket, user.Email, user)
+}
+
+func (s *userLocalStore) GetMember(ctx context.Context, projectID, email string) (*Member, error) {
+	member := &Member{}
+	found, err := s.get(memberBucketPrefix+projectID, email, member)
+	if !found {
+		return nil, err
+	}
+	member.ID = projectID + "/" + email
+	return member, err
+}
+
+func (s *userLocalStore) PutMember(ctx context.Context, member *Member) error {
+	return s.put(memberBucketPrefix+member.ProjectID, member.Email, member)
+}
+
+func (s *userLocalStore) DeleteMember(ctx context.Context, projectID, email string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(memberBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+		return buck.Delete([]byte(email))
+	})
+}
+
+func (s *userLocalStore) ListMembersForProject(ctx context.Context, projectID string) ([]*Member, error) {
+	members := make([]*Member, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(memberBucketPrefix + projectID))
+		if buck == nil {
+			return nil
+		}
+
+		return buck.ForEach(func(k, v []byte) error {
+			member := &Member{}
+			err := json.Unmarshal(v, member)
+			if err != nil {
+				return err
+			}
+			member.ID = projectID + "/" + member.Email
+			members = append(members, member)
+			return nil
+		})
+	})
+	return members, err
+}
+
+func (s *userLocalStore) get(bucket, key string, v interface{}) (bool, error) {
+	found := false
+	err := s.db.View(func(tx *bolt.Tx) error {
+		buck := tx.Bucket([]byte(bucket))
+		if buck == nil {
+			return nil
+		}
+
+		value := buck.Get([]byte(key))
+		if value == nil {
+			return nil
+		}
+
+		found = true
+		return json.Unmarshal(value, v)
+	})
+	return found, err
+}
+
+func (s *userLocalStore) put(bucket, key string, v interface{}) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		buck, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return err
+		}
+
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+
+		return buck.Put([]byte(key), value)
+	})
+}
@@ -0,0 +1,48 @@
+package users
+
+import "fmt"
+
+// Roles of members in a project
+const (
+	RoleOwner    = "owner"
+	RoleOperator = "operator"
+	RoleViewer   = "viewer"
+)
+
+// Permissions checked by the API
+const (
+	// PermissionRead reads devices, their state and the project resources
+	PermissionRead = "read"
+	// PermissionReadHistory reads the history and change log of devices
+	PermissionReadHistory = "history:read"
+	// PermissionWrite registers and updates devices and the project resources
+	PermissionWrite = "write"
+	// PermissionSendCommands sends commands to devices
+	PermissionSendCommands = "commands:send"
+	// PermissionManage changes the project settings, integrations and members
+	PermissionManage = "manage"
+)
+
+var rolePermissions = map[string][]string{
+	RoleViewer:   {PermissionRead, PermissionReadHistory},
+	RoleOperator: {PermissionRead, PermissionReadHistory, PermissionWrite, PermissionSendCommands},
+	RoleOwner:    {PermissionRead, PermissionReadHistory, PermissionWrite, PermissionSendCommands, PermissionManage},
+}
+
+// ValidateRole rejects unknown roles
+func ValidateRole(role string) error {
+	if _, ok := rolePermissions[role]; !ok {
+		return fmt.Errorf("unknown role %q, expected %s, %s or %s", role, RoleOwner, RoleOperator, RoleViewer)
+	}
+	return nil
+}
+
+// Can tells if the role grants the permission
+func Can(role, permission string) bool {
+	for _, p := range rolePermissions[role] {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package users
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for tokens not signed with the key, malformed or expired
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// tokenHeader is the JOSE header of every token, only HS256 is issued and accepted
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// IssueToken signs a JWT for the user with HS256, valid for the ttl
+func IssueToken(key []byte, email string, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(ttl)
+	claims, err := json.Marshal(tokenClaims{
+		Subject:   email,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signed := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signed + "." + sign(key, signed), expires, nil
+}
+
+// VerifyToken checks the signature and the expiry of a JWT, returning the email of its user
+func VerifyToken(key []byte, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return "", ErrInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(sign(key, parts[0]+"."+parts[1]))) {
+		return "", ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	claims := &tokenClaims{}
+	if err := json.Unmarshal(payload, claims); err != nil || claims.Subject == "" {
+		return "", ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return "", ErrInvalidToken
+	}
+	return claims.Subject, nil
+}
+
+// IsToken tells JWTs apart from the other bearer tokens of the API
+func IsToken(token string) bool {
+	return strings.HasPrefix(token, tokenHeader+".")
+}
+
+func sign(key []byte, signed string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,138 @@
+package users
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUserExists is returned when creating a user whose email is taken
+var ErrUserExists = errors.New("user already exists")
+
+// UserStore keeps the users of the platform, identified by email, and their roles in projects
+type UserStore interface {
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	// CreateUser fails with ErrUserExists when the email is taken
+	CreateUser(ctx context.Context, user *User) error
+	UpdateUser(ctx context.Context, user *User) error
+	GetMember(ctx context.Context, projectID, email string) (*Member, error)
+	// PutMember adds the user to the project, or changes the role of a member
+	PutMember(ctx context.Context, member *Member) error
+	DeleteMember(ctx context.Context, projectID, email string) error
+	ListMembersForProject(ctx context.Context, projectID string) ([]*Member, error)
+}
+
+// User signs in with a password, users invited but not signed up yet have an invite instead
+type User struct {
+	Email         string    `json:"email" docstore:"email"`
+	Name          string    `json:"name,omitempty" docstore:"name"`
+	PasswordHash  string    `json:"passwordHash,omitempty" docstore:"passwordHash"`
+	InviteHash    string    `json:"inviteHash,omitempty" docstore:"inviteHash"`
+	InviteExpires time.Time `json:"inviteExpires,omitempty" docstore:"inviteExpires"`
+	Created       time.Time `json:"created" docstore:"created"`
+}
+
+// Member gives a user a role in a project
+type Member struct {
+	ID        string    `json:"-" docstore:"id"`
+	ProjectID string    `json:"projectID" docstore:"projectID"`
+	Email     string    `json:"email" docstore:"email"`
+	Role      string    `json:"role" docstore:"role"`
+	Updated   time.Time `json:"updated" docstore:"updated"`
+}
+
+// NewMember gives the user the role in the project
+func NewMember(projectID, email, role string) *Member {
+	return &Member{
+		ID:        projectID + "/" + email,
+		ProjectID: projectID,
+		Email:     email,
+		Role:      role,
+		Updated:   time.Now(),
+	}
+}
+
+// inviteTTL is how long an invite can be accepted
+const inviteTTL = 7 * 24 * time.Hour
+
+// minPasswordLength is the length of the shortest password accepted
+const minPasswordLength = 8
+
+// NormalizeEmail makes emails usable as IDs, they are compared case insensitively
+func NormalizeEmail(email string) (string, error) {
+	email = strings.ToLower(strings.TrimSpace(email))
+	at := strings.Index(email, "@")
+	if at <= 0 || at == len(email)-1 || strings.ContainsAny(email, "/ ") {
+		return "", fmt.Errorf("invalid email %q", email)
+	}
+	return email, nil
+}
+
+// Invite replaces the invite of the user, returning the token to accept it with
+func (u *User) Invite() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(raw)
+	u.InviteHash = hashInvite(token)
+	u.InviteExpires = time.Now().Add(inviteTTL)
+	return token, nil
+}
+
+// AcceptInvite sets the password of an invited user when the token matches a pending invite
+func (u *User) AcceptInvite(token, password string) error {
+	if u.InviteHash == "" || time.Now().After(u.InviteExpires) ||
+		subtle.ConstantTimeCompare([]byte(hashInvite(token)), []byte(u.InviteHash)) != 1 {
+		return fmt.Errorf("invalid or expired invite")
+	}
+	if err := u.SetPassword(password); err != nil {
+		return err
+	}
+	u.InviteHash = ""
+	u.InviteExpires = time.Time{}
+	return nil
+}
+
+// SetPassword keeps a bcrypt hash of the password
+func (u *User) SetPassword(password string) error {
+	if len(password) < minPasswordLength {
+		return fmt.Errorf("password must have at least %d characters", minPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	u.PasswordHash = string(hash)
+	return nil
+}
+
+// CheckPassword tells if the password is the one of the user, false for users not signed up yet
+func (u *User) CheckPassword(password string) bool {
+	if u.PasswordHash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// Redacted is a copy of the user without its secrets, to be listed
+func (u *User) Redacted() *User {
+	redacted := *u
+	redacted.PasswordHash = ""
+	redacted.InviteHash = ""
+	return &redacted
+}
+
+// Invite tokens are random, a fast hash is enough to keep them from being read back from the store
+func hashInvite(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
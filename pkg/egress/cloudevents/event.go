@@ -0,0 +1,75 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/envelope"
+	"com.aviebrantz.coap-demo/pkg/core/events"
+	"github.com/google/uuid"
+)
+
+// SpecVersion is the CloudEvents specification version produced
+const SpecVersion = "1.0"
+
+// Event types emitted by the platform
+const (
+	TypeStateReported = events.TypeDeviceStateReported
+)
+
+// Content modes defined by the CloudEvents protocol bindings
+const (
+	ModeBinary     = "binary"
+	ModeStructured = "structured"
+)
+
+const (
+	contentTypeJSON           = "application/json"
+	contentTypeCloudEventJSON = "application/cloudevents+json; charset=UTF-8"
+)
+
+// Event is a CloudEvents 1.0 event with JSON data
+type Event struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Subject         string          `json:"subject,omitempty"`
+	Time            time.Time       `json:"time"`
+	DataContentType string          `json:"datacontenttype,omitempty"`
+	ProjectID       string          `json:"projectid,omitempty"`
+	Data            json.RawMessage `json:"data,omitempty"`
+}
+
+// FromEnvelope maps a device uplink to a device.state.reported event
+func FromEnvelope(source string, env *envelope.Envelope) *Event {
+	return &Event{
+		SpecVersion:     SpecVersion,
+		ID:              uuid.New().String(),
+		Source:          source,
+		Type:            TypeStateReported,
+		Subject:         env.DeviceID,
+		Time:            env.ReportedAt,
+		DataContentType: contentTypeJSON,
+		ProjectID:       env.ProjectID,
+		Data:            env.Payload,
+	}
+}
+
+// Attributes returns the context attributes as strings, as used by binary mode headers
+func (e *Event) Attributes() map[string]string {
+	attrs := map[string]string{
+		"specversion": e.SpecVersion,
+		"id":          e.ID,
+		"source":      e.Source,
+		"type":        e.Type,
+		"time":        e.Time.UTC().Format(time.RFC3339Nano),
+	}
+	if e.Subject != "" {
+		attrs["subject"] = e.Subject
+	}
+	if e.ProjectID != "" {
+		attrs["projectid"] = e.ProjectID
+	}
+	return attrs
+}
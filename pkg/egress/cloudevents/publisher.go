@@ -0,0 +1,134 @@
+package cloudevents
+
+import (
+	"context"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/config"
+	"com.aviebrantz.coap-demo/pkg/core/envelope"
+	"github.com/apex/log"
+	"gocloud.dev/pubsub"
+)
+
+const defaultSource = "/coap-demo/gateway"
+
+// Events are sent up to maxAttempts times, waiting between attempts with exponential backoff.
+// Events still failing are dropped, as are events arriving while the queue is full
+const (
+	queueSize    = 256
+	maxAttempts  = 5
+	firstBackoff = 500 * time.Millisecond
+	maxBackoff   = 30 * time.Second
+)
+
+// job is a pending send, retried sends are queued again once their backoff elapsed
+type job struct {
+	event    *Event
+	attempts int
+	backoff  time.Duration
+}
+
+// CloudEventsPublisher republishes every uplink on the data topic as a CloudEvent.
+// Events are sent from a bounded queue, so a sink that is down never stalls the subscription
+type CloudEventsPublisher struct {
+	dataSub *pubsub.Subscription
+	sender  sender
+	source  string
+	queue   chan *job
+	logger  *log.Entry
+}
+
+func NewPublisher(ctx context.Context, dataSub *pubsub.Subscription, cfg config.EgressConfig) (*CloudEventsPublisher, error) {
+	mode := cfg.Mode
+	if mode == "" {
+		mode = ModeBinary
+	}
+
+	source := cfg.Source
+	if source == "" {
+		source = defaultSource
+	}
+
+	sender, err := newSender(ctx, cfg.URL, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := log.WithField("module", "cloudevents-egress")
+	return &CloudEventsPublisher{
+		dataSub: dataSub,
+		sender:  sender,
+		source:  source,
+		queue:   make(chan *job, queueSize),
+		logger:  logger,
+	}, nil
+}
+
+func (p *CloudEventsPublisher) Start() {
+	go p.work()
+	for {
+		ctx := context.Background()
+		msg, err := p.dataSub.Receive(ctx)
+		if err != nil {
+			p.logger.Infof("Receiving message: %v", err)
+			break
+		}
+
+		env, err := envelope.FromMessage(msg)
+		if err != nil {
+			p.logger.Warnf("Rejecting message: %v", err)
+			// Drop msg
+			msg.Ack()
+			continue
+		}
+
+		p.enqueue(&job{
+			event:   FromEnvelope(p.source, env),
+			backoff: firstBackoff,
+		})
+		msg.Ack()
+	}
+}
+
+// enqueue hands the job to the worker without blocking, jobs arriving while the queue is full are dropped
+func (p *CloudEventsPublisher) enqueue(j *job) {
+	select {
+	case p.queue <- j:
+	default:
+		p.logger.Errorf("Dropping cloud event %s, the send queue is full", j.event.ID)
+	}
+}
+
+func (p *CloudEventsPublisher) work() {
+	for j := range p.queue {
+		p.attempt(j)
+	}
+}
+
+// attempt sends the event once. Failed sends are retried with exponential backoff, scheduled
+// on a timer so the worker moves on to the next event meanwhile
+func (p *CloudEventsPublisher) attempt(j *job) {
+	j.attempts++
+	err := p.sender.Send(context.Background(), j.event)
+	if err == nil {
+		return
+	}
+	if j.attempts >= maxAttempts {
+		p.logger.Errorf("Dropping cloud event %s after %d attempts: %v", j.event.ID, j.attempts, err)
+		return
+	}
+
+	p.logger.Warnf("err sending cloud event %s, attempt %d :%v", j.event.ID, j.attempts, err)
+	backoff := j.backoff
+	j.backoff *= 2
+	if j.backoff > maxBackoff {
+		j.backoff = maxBackoff
+	}
+	time.AfterFunc(backoff, func() {
+		p.enqueue(j)
+	})
+}
+
+func (p *CloudEventsPublisher) Shutdown(ctx context.Context) {
+	p.sender.Shutdown(ctx)
+}
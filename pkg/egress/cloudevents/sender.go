@@ -0,0 +1,123 @@
+package cloudevents
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gocloud.dev/pubsub"
+)
+
+const attributePrefix = "ce-"
+
+type sender interface {
+	Send(ctx context.Context, event *Event) error
+	Shutdown(ctx context.Context)
+}
+
+func newSender(ctx context.Context, url, mode string) (sender, error) {
+	if mode != ModeBinary && mode != ModeStructured {
+		return nil, fmt.Errorf("unknown cloudevents mode %q", mode)
+	}
+
+	if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return &httpSender{
+			url:    url,
+			mode:   mode,
+			client: &http.Client{Timeout: 10 * time.Second},
+		}, nil
+	}
+
+	topic, err := pubsub.OpenTopic(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	return &topicSender{
+		topic: topic,
+		mode:  mode,
+	}, nil
+}
+
+// httpSender delivers events following the CloudEvents HTTP protocol binding
+type httpSender struct {
+	url    string
+	mode   string
+	client *http.Client
+}
+
+func (s *httpSender) Send(ctx context.Context, event *Event) error {
+	var body []byte
+	headers := make(http.Header)
+	if s.mode == ModeStructured {
+		var err error
+		body, err = json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		headers.Set("Content-Type", contentTypeCloudEventJSON)
+	} else {
+		body = event.Data
+		headers.Set("Content-Type", event.DataContentType)
+		for k, v := range event.Attributes() {
+			headers.Set(attributePrefix+k, v)
+		}
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header = headers
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *httpSender) Shutdown(ctx context.Context) {}
+
+// topicSender publishes events to a gocloud.dev/pubsub topic, mapping attributes to metadata
+type topicSender struct {
+	topic *pubsub.Topic
+	mode  string
+}
+
+func (s *topicSender) Send(ctx context.Context, event *Event) error {
+	msg := &pubsub.Message{
+		Metadata: make(map[string]string),
+	}
+
+	if s.mode == ModeStructured {
+		body, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		msg.Body = body
+		msg.Metadata["content-type"] = contentTypeCloudEventJSON
+	} else {
+		msg.Body = event.Data
+		msg.Metadata["content-type"] = event.DataContentType
+		for k, v := range event.Attributes() {
+			msg.Metadata[attributePrefix+k] = v
+		}
+	}
+
+	return s.topic.Send(ctx, msg)
+}
+
+func (s *topicSender) Shutdown(ctx context.Context) {
+	s.topic.Shutdown(ctx)
+}
@@ -0,0 +1,330 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/envelope"
+	"com.aviebrantz.coap-demo/pkg/core/events"
+	"com.aviebrantz.coap-demo/pkg/core/store/integrations"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"gocloud.dev/pubsub"
+)
+
+const (
+	queueSize    = 64
+	maxAttempts  = 5
+	firstBackoff = time.Second
+	maxBackoff   = time.Minute
+	timeout      = 10 * time.Second
+	idleTimeout  = 5 * time.Minute
+)
+
+// errQueueFull fails deliveries to webhooks whose endpoint can't keep up
+var errQueueFull = errors.New("delivery queue of the webhook is full")
+
+// payload is the body posted to webhook endpoints
+type payload struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	ProjectID string          `json:"projectID"`
+	DeviceID  string          `json:"deviceID,omitempty"`
+	Time      time.Time       `json:"time"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// job is a pending delivery, retried attempts are queued again once their backoff elapsed
+type job struct {
+	webhook  *integrations.Webhook
+	payload  *payload
+	body     []byte
+	delivery *integrations.Delivery
+	backoff  time.Duration
+}
+
+// WebhookDispatcher delivers uplinks and platform events to the webhooks registered by each project.
+// Every webhook has its own bounded queue and worker, so a slow or failing endpoint only delays its
+// own deliveries and never blocks the subscriptions
+type WebhookDispatcher struct {
+	dataSub      *pubsub.Subscription
+	eventsSub    *pubsub.Subscription
+	webhookStore integrations.WebhookStore
+	client       *http.Client
+	firstBackoff time.Duration
+	maxBackoff   time.Duration
+	mu           sync.Mutex
+	queues       map[string]chan *job
+	logger       *log.Entry
+}
+
+func NewDispatcher(
+	dataSub *pubsub.Subscription,
+	eventsSub *pubsub.Subscription,
+	webhookStore integrations.WebhookStore,
+) *WebhookDispatcher {
+	logger := log.WithField("module", "webhook-dispatcher")
+	return &WebhookDispatcher{
+		dataSub:      dataSub,
+		eventsSub:    eventsSub,
+		webhookStore: webhookStore,
+		client:       &http.Client{Timeout: timeout},
+		firstBackoff: firstBackoff,
+		maxBackoff:   maxBackoff,
+		queues:       make(map[string]chan *job),
+		logger:       logger,
+	}
+}
+
+func (wd *WebhookDispatcher) Start() {
+	go wd.receiveEvents()
+	wd.receiveData()
+}
+
+func (wd *WebhookDispatcher) receiveData() {
+	for {
+		ctx := context.Background()
+		msg, err := wd.dataSub.Receive(ctx)
+		if err != nil {
+			wd.logger.Infof("Receiving message: %v", err)
+			break
+		}
+
+		env, err := envelope.FromMessage(msg)
+		if err != nil {
+			wd.logger.Warnf("Rejecting message: %v", err)
+			// Drop msg
+			msg.Ack()
+			continue
+		}
+
+		// Gateways resolve the project, uplinks of unassigned devices have none
+		projectID := env.ProjectID
+		if projectID != "" {
+			err = wd.dispatch(ctx, &payload{
+				ID:        uuid.New().String(),
+				Type:      events.TypeDeviceStateReported,
+				ProjectID: projectID,
+				DeviceID:  env.DeviceID,
+				Time:      env.ReportedAt,
+				Data:      env.Payload,
+			})
+			if err != nil {
+				wd.logger.Errorf("err dispatching uplink :%v", err)
+				msg.Nack()
+				continue
+			}
+		}
+
+		msg.Ack()
+	}
+}
+
+func (wd *WebhookDispatcher) receiveEvents() {
+	for {
+		ctx := context.Background()
+		msg, err := wd.eventsSub.Receive(ctx)
+		if err != nil {
+			wd.logger.Infof("Receiving message: %v", err)
+			break
+		}
+
+		event, err := events.FromMessage(msg)
+		if err != nil {
+			wd.logger.Warnf("Invalid event format :%v", err)
+			// Drop msg
+			msg.Ack()
+			continue
+		}
+
+		err = wd.dispatch(ctx, &payload{
+			ID:        uuid.New().String(),
+			Type:      event.Type,
+			ProjectID: event.ProjectID,
+			DeviceID:  event.DeviceID,
+			Time:      event.Time,
+			Data:      event.Data,
+		})
+		if err != nil {
+			wd.logger.Errorf("err dispatching event :%v", err)
+			msg.Nack()
+			continue
+		}
+
+		msg.Ack()
+	}
+}
+
+// dispatch queues the payload for every matching webhook of its project
+func (wd *WebhookDispatcher) dispatch(ctx context.Context, p *payload) error {
+	webhooks, err := wd.webhookStore.ListWebhooksForProject(ctx, p.ProjectID)
+	if err != nil {
+		return err
+	}
+
+	// Payloads that can't be encoded fail every delivery, they won't encode on redelivery either
+	body, bodyErr := json.Marshal(p)
+
+	for _, webhook := range webhooks {
+		if !webhook.Accepts(p.Type, p.DeviceID) {
+			continue
+		}
+
+		j := &job{
+			webhook: webhook,
+			payload: p,
+			body:    body,
+			delivery: &integrations.Delivery{
+				ID:        p.ID + "/" + webhook.ID,
+				ProjectID: webhook.ProjectID,
+				WebhookID: webhook.ID,
+				EventType: p.Type,
+				Time:      time.Now(),
+			},
+			backoff: wd.firstBackoff,
+		}
+		if bodyErr != nil {
+			j.delivery.Error = bodyErr.Error()
+			wd.record(j)
+			continue
+		}
+		wd.enqueue(j)
+	}
+	return nil
+}
+
+// enqueue hands the job to the worker of its webhook, starting one when the webhook has none.
+// It never blocks, jobs of webhooks with a full queue are recorded as failed deliveries
+func (wd *WebhookDispatcher) enqueue(j *job) {
+	wd.mu.Lock()
+	queue, ok := wd.queues[j.webhook.ID]
+	if !ok {
+		queue = make(chan *job, queueSize)
+		wd.queues[j.webhook.ID] = queue
+		go wd.work(j.webhook.ID, queue)
+	}
+
+	select {
+	case queue <- j:
+		wd.mu.Unlock()
+	default:
+		wd.mu.Unlock()
+		j.delivery.Error = errQueueFull.Error()
+		wd.logger.Warnf("delivery %s to %s dropped: %v", j.delivery.ID, j.webhook.URL, errQueueFull)
+		wd.record(j)
+	}
+}
+
+// work attempts the jobs of a webhook one at a time, it stops once the webhook has been idle
+// for a while. Jobs waiting for a retry start a new worker when queued again
+func (wd *WebhookDispatcher) work(webhookID string, queue chan *job) {
+	idle := time.NewTimer(idleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case j := <-queue:
+			wd.attempt(j)
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(idleTimeout)
+		case <-idle.C:
+			wd.mu.Lock()
+			if len(queue) == 0 {
+				delete(wd.queues, webhookID)
+				wd.mu.Unlock()
+				return
+			}
+			wd.mu.Unlock()
+			idle.Reset(idleTimeout)
+		}
+	}
+}
+
+// attempt posts the payload once. Failed attempts are retried with exponential backoff,
+// scheduled on a timer so the worker moves on to the next job meanwhile
+func (wd *WebhookDispatcher) attempt(j *job) {
+	delivery := j.delivery
+	delivery.Attempts++
+
+	start := time.Now()
+	statusCode, err := wd.post(context.Background(), j)
+	delivery.Duration = time.Since(start)
+	delivery.StatusCode = statusCode
+	if err == nil {
+		delivery.Success = true
+		delivery.Error = ""
+		wd.record(j)
+		return
+	}
+
+	delivery.Error = err.Error()
+	wd.logger.Warnf("delivery %s to %s failed, attempt %d: %v", delivery.ID, j.webhook.URL, delivery.Attempts, err)
+
+	// Client errors won't succeed on retry
+	clientError := statusCode >= 400 && statusCode < 500 && statusCode != http.StatusTooManyRequests
+	if clientError || delivery.Attempts >= maxAttempts {
+		wd.record(j)
+		return
+	}
+
+	backoff := j.backoff
+	j.backoff *= 2
+	if j.backoff > wd.maxBackoff {
+		j.backoff = wd.maxBackoff
+	}
+	time.AfterFunc(backoff, func() {
+		wd.retry(j)
+	})
+}
+
+// retry queues the job again, unless its webhook was deleted during the backoff
+func (wd *WebhookDispatcher) retry(j *job) {
+	webhook, err := wd.webhookStore.GetWebhookByID(context.Background(), j.webhook.ProjectID, j.webhook.ID)
+	if err != nil {
+		wd.logger.Errorf("err loading webhook %s :%v", j.webhook.ID, err)
+	} else if webhook == nil {
+		return
+	}
+	wd.enqueue(j)
+}
+
+// record saves the outcome of a delivery in the delivery log of its webhook
+func (wd *WebhookDispatcher) record(j *job) {
+	err := wd.webhookStore.InsertDelivery(context.Background(), j.delivery)
+	if err != nil {
+		wd.logger.Errorf("err saving delivery log :%v", err)
+	}
+}
+
+func (wd *WebhookDispatcher) post(ctx context.Context, j *job) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, j.webhook.URL, bytes.NewReader(j.body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, j.payload.Type)
+	req.Header.Set(HeaderDelivery, j.payload.ID)
+	if j.webhook.Secret != "" {
+		req.Header.Set(HeaderSignature, Sign(j.webhook.Secret, j.body))
+	}
+
+	resp, err := wd.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,264 @@
+package webhooks
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/integrations"
+	bolt "go.etcd.io/bbolt"
+)
+
+func newTestDispatcher(t *testing.T) (*WebhookDispatcher, integrations.WebhookStore) {
+	f, err := ioutil.TempFile("", "webhooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	db, err := bolt.Open(f.Name(), 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		os.Remove(f.Name())
+	})
+
+	store := integrations.NewWebhookLocalStore(db)
+	wd := NewDispatcher(nil, nil, store)
+	wd.firstBackoff = 10 * time.Millisecond
+	wd.maxBackoff = 40 * time.Millisecond
+	return wd, store
+}
+
+func createWebhook(t *testing.T, store integrations.WebhookStore, url string) *integrations.Webhook {
+	webhook := &integrations.Webhook{
+		ID:        "hook",
+		ProjectID: "project",
+		URL:       url,
+		Secret:    "secret",
+		Enabled:   true,
+		Created:   time.Now(),
+	}
+	if err := store.CreateWebhook(context.Background(), webhook); err != nil {
+		t.Fatal(err)
+	}
+	return webhook
+}
+
+// waitDelivery waits for the outcome of the single delivery of the test
+func waitDelivery(t *testing.T, store integrations.WebhookStore) *integrations.Delivery {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		list, err := store.ListDeliveries(context.Background(), "project", "hook", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) > 0 {
+			return list[0]
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("no delivery recorded")
+	return nil
+}
+
+func dispatchTest(t *testing.T, wd *WebhookDispatcher) {
+	err := wd.dispatch(context.Background(), &payload{
+		ID:        "delivery",
+		Type:      "device.state.reported",
+		ProjectID: "project",
+		DeviceID:  "device",
+		Time:      time.Now(),
+		Data:      []byte(`{"temp":21}`),
+	})
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeliverySignature(t *testing.T) {
+	wd, store := newTestDispatcher(t)
+
+	var signed int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if Verify("secret", body, r.Header.Get(HeaderSignature)) && r.Header.Get(HeaderDelivery) == "delivery" {
+			atomic.StoreInt32(&signed, 1)
+		}
+	}))
+	defer srv.Close()
+	createWebhook(t, store, srv.URL)
+
+	dispatchTest(t, wd)
+	delivery := waitDelivery(t, store)
+	if !delivery.Success || delivery.Attempts != 1 {
+		t.Fatalf("expected a successful first attempt, got %+v", delivery)
+	}
+	if atomic.LoadInt32(&signed) != 1 {
+		t.Fatal("expected the body to be signed with the webhook secret")
+	}
+}
+
+func TestSign(t *testing.T) {
+	body := []byte(`{"a":1}`)
+
+	// echo -n '{"a":1}' | openssl dgst -sha256 -hmac secret
+	expected := "sha256=aa9e2e3575f5d7098b6caccd790888c36d5fdb63342a73bada2d6a51747a8494"
+	if got := Sign("secret", body); got != expected {
+		t.Fatalf("expected signature %s, got %s", expected, got)
+	}
+	if !Verify("secret", body, expected) {
+		t.Fatal("expected the signature to verify")
+	}
+	if Verify("other", body, expected) {
+		t.Fatal("signature verified with another secret")
+	}
+	if Verify("secret", []byte(`{"a":2}`), expected) {
+		t.Fatal("signature verified for another body")
+	}
+}
+
+func TestRetryServerErrors(t *testing.T) {
+	wd, store := newTestDispatcher(t)
+
+	var calls int32
+	var times [3]time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if n <= 3 {
+			times[n-1] = time.Now()
+		}
+		if n < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+	createWebhook(t, store, srv.URL)
+
+	dispatchTest(t, wd)
+	delivery := waitDelivery(t, store)
+	if !delivery.Success || delivery.Attempts != 3 || delivery.Error != "" {
+		t.Fatalf("expected success on the third attempt, got %+v", delivery)
+	}
+
+	// Backoff doubles between attempts
+	if first, second := times[1].Sub(times[0]), times[2].Sub(times[1]); first < wd.firstBackoff || second < 2*wd.firstBackoff {
+		t.Fatalf("expected exponential backoff, waited %v then %v", first, second)
+	}
+}
+
+func TestRetryNetworkErrors(t *testing.T) {
+	wd, store := newTestDispatcher(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := srv.URL
+	srv.Close()
+	createWebhook(t, store, url)
+
+	dispatchTest(t, wd)
+	delivery := waitDelivery(t, store)
+	if delivery.Success || delivery.Attempts != maxAttempts || delivery.StatusCode != 0 || delivery.Error == "" {
+		t.Fatalf("expected %d failed attempts, got %+v", maxAttempts, delivery)
+	}
+}
+
+func TestNoRetryClientErrors(t *testing.T) {
+	wd, store := newTestDispatcher(t)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusGone)
+	}))
+	defer srv.Close()
+	createWebhook(t, store, srv.URL)
+
+	dispatchTest(t, wd)
+	delivery := waitDelivery(t, store)
+	if delivery.Success || delivery.Attempts != 1 || delivery.StatusCode != http.StatusGone {
+		t.Fatalf("expected a single failed attempt, got %+v", delivery)
+	}
+
+	time.Sleep(5 * wd.firstBackoff)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected 1 request, got %d", n)
+	}
+}
+
+func TestNoRetryAfterDelete(t *testing.T) {
+	wd, store := newTestDispatcher(t)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	createWebhook(t, store, srv.URL)
+
+	dispatchTest(t, wd)
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := store.DeleteWebhook(context.Background(), "project", "hook"); err != nil {
+		t.Fatal(err)
+	}
+
+	time.Sleep(10 * wd.maxBackoff)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("expected 1 request, got %d", n)
+	}
+	list, err := store.ListDeliveries(context.Background(), "project", "hook", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("expected no deliveries of the deleted webhook, got %d", len(list))
+	}
+}
+
+func TestFullQueueDoesNotBlock(t *testing.T) {
+	wd, store := newTestDispatcher(t)
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+	createWebhook(t, store, srv.URL)
+
+	done := make(chan struct{})
+	go func() {
+		// One in flight, queueSize queued, the rest overflow
+		for i := 0; i < queueSize+10; i++ {
+			dispatchTest(t, wd)
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatch blocked on a slow endpoint")
+	}
+
+	list, err := store.ListDeliveries(context.Background(), "project", "hook", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) < 9 {
+		t.Fatalf("expected the overflow to be recorded, got %d deliveries", len(list))
+	}
+	for _, delivery := range list {
+		if delivery.Success || delivery.Error != errQueueFull.Error() {
+			t.Fatalf("expected a queue full failure, got %+v", delivery)
+		}
+	}
+}
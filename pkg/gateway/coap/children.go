@@ -0,0 +1,96 @@
+package coap
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// errNotChild rejects uplinks relayed for devices not registered as children of the gateway
+var errNotChild = errors.New("device is not a registered child of the gateway")
+
+// childPathPattern matches uplinks relayed for a single child, d/{gw}/c/{child}/s[/subpath],
+// and batches of several children posted to d/{gw}/c
+var childPathPattern = regexp.MustCompile(`^d/([^/]+)/c(?:/([^/]+)/s(?:/(.*))?)?$`)
+
+// getChildIDFromPath returns the gateway and child IDs of a relayed uplink path, hex encoded
+// like device IDs. The child is empty for batches
+func getChildIDFromPath(path string) (string, string, bool) {
+	m := childPathPattern.FindStringSubmatch(path)
+	if len(m) != 4 {
+		return "", "", false
+	}
+	childID := ""
+	if m[2] != "" {
+		childID = hex.EncodeToString([]byte(m[2]))
+	}
+	return hex.EncodeToString([]byte(m[1])), childID, true
+}
+
+// getChildSubpath returns the subpath of an uplink relayed for a single child
+func getChildSubpath(path string) string {
+	m := childPathPattern.FindStringSubmatch(path)
+	if len(m) != 4 {
+		return ""
+	}
+	return m[3]
+}
+
+// childUplinks splits a relayed uplink by child. Batches map raw child IDs to their state,
+// like {"sensor-1": {"temp": 21}, "sensor-2": {"temp": 19}}
+func childUplinks(childID string, data map[string]interface{}) (map[string]map[string]interface{}, error) {
+	if childID != "" {
+		return map[string]map[string]interface{}{childID: data}, nil
+	}
+
+	uplinks := make(map[string]map[string]interface{}, len(data))
+	for rawID, value := range data {
+		state, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("state of child %s is not a map", rawID)
+		}
+		uplinks[hex.EncodeToString([]byte(rawID))] = state
+	}
+	return uplinks, nil
+}
+
+// authorizeChildren checks every child is registered to the gateway in its project,
+// so a gateway can't write the state of devices it does not relay
+func (cg *CoAPGateway) authorizeChildren(ctx context.Context, projectID, gatewayID string, uplinks map[string]map[string]interface{}) error {
+	for childID := range uplinks {
+		child, err := cg.deviceStore.GetDeviceByID(ctx, projectID, childID)
+		if err != nil {
+			return err
+		}
+		if child == nil || child.ParentID() != gatewayID {
+			return errNotChild
+		}
+	}
+	return nil
+}
+
+// stringKeys turns the maps CBOR decodes with interface keys into maps with string keys,
+// which the state and the envelope payload require
+func stringKeys(v interface{}) interface{} {
+	switch value := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(value))
+		for k, item := range value {
+			m[fmt.Sprintf("%v", k)] = stringKeys(item)
+		}
+		return m
+	case map[string]interface{}:
+		for k, item := range value {
+			value[k] = stringKeys(item)
+		}
+		return value
+	case []interface{}:
+		for i, item := range value {
+			value[i] = stringKeys(item)
+		}
+		return value
+	}
+	return v
+}
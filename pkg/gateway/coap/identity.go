@@ -0,0 +1,117 @@
+package coap
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/tenancy"
+	"github.com/pion/dtls/v2"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+)
+
+// errAmbiguousProject rejects uplinks of device IDs registered to several projects without a
+// certificate telling which one they belong to
+var errAmbiguousProject = errors.New("device registered to several projects")
+
+// errCertMismatch rejects uplinks for another device than the one of the client certificate
+var errCertMismatch = errors.New("certificate common name is not the device ID")
+
+// errUnknownProject rejects uplinks of certificates issued for a project that doesn't exist
+var errUnknownProject = errors.New("certificate project doesn't exist")
+
+// identity is what the DTLS client certificate of a connection tells about the device,
+// the organization is the project and the common name is the raw device ID
+type identity struct {
+	projectID  string
+	commonName string
+}
+
+// certIdentity reads the identity of the leaf certificate of a chain
+func certIdentity(chain [][]byte) (identity, bool) {
+	if len(chain) == 0 {
+		return identity{}, false
+	}
+	cert, err := x509.ParseCertificate(chain[0])
+	if err != nil || len(cert.Subject.Organization) == 0 {
+		return identity{}, false
+	}
+	return identity{
+		projectID:  cert.Subject.Organization[0],
+		commonName: cert.Subject.CommonName,
+	}, true
+}
+
+// identityListener keeps the certificate identity of each DTLS connection by remote address,
+// as handlers only get to see the address of the client
+type identityListener struct {
+	*coapNet.DTLSListener
+	identities *sync.Map
+}
+
+func (l *identityListener) AcceptWithContext(ctx context.Context) (net.Conn, error) {
+	conn, err := l.DTLSListener.AcceptWithContext(ctx)
+	if err != nil || conn == nil {
+		return conn, err
+	}
+	dtlsConn, ok := conn.(*dtls.Conn)
+	if !ok {
+		return conn, nil
+	}
+	id, ok := certIdentity(dtlsConn.ConnectionState().PeerCertificates)
+	if !ok {
+		return conn, nil
+	}
+
+	addr := conn.RemoteAddr().String()
+	l.identities.Store(addr, id)
+	return &identityConn{Conn: conn, forget: func() { l.identities.Delete(addr) }}, nil
+}
+
+// identityConn forgets the identity of its address once closed
+type identityConn struct {
+	net.Conn
+	forget func()
+}
+
+func (c *identityConn) Close() error {
+	c.forget()
+	return c.Conn.Close()
+}
+
+// resolveProject picks the project of an uplink: the one of the client certificate when
+// given, otherwise the single project the device ID is registered to. Devices of no
+// project are unassigned until registered. Certificates only write the device of their
+// common name, in a project that exists
+func (cg *CoAPGateway) resolveProject(ctx context.Context, addr net.Addr, deviceID string) (string, string, error) {
+	if value, ok := cg.identities.Load(addr.String()); ok {
+		id := value.(identity)
+		rawID, err := hex.DecodeString(deviceID)
+		if err != nil || string(rawID) != id.commonName {
+			return "", "", errCertMismatch
+		}
+		project, err := cg.projectStore.GetProjectByID(ctx, id.projectID)
+		if err != nil {
+			return "", "", err
+		}
+		if project == nil {
+			return "", "", errUnknownProject
+		}
+		return id.projectID, id.commonName, nil
+	}
+
+	projects, err := cg.deviceStore.ListDeviceProjects(ctx, deviceID)
+	if err != nil {
+		return "", "", err
+	}
+	switch len(projects) {
+	case 0:
+		return tenancy.Unassigned, "", nil
+	case 1:
+		return projects[0], "", nil
+	}
+	return "", "", errAmbiguousProject
+}
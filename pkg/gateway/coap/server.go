@@ -6,7 +6,6 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"regexp"
@@ -16,14 +15,20 @@ import (
 	"time"
 
 	"com.aviebrantz.coap-demo/pkg/config"
+	"com.aviebrantz.coap-demo/pkg/core/envelope"
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store/projects"
+	"com.aviebrantz.coap-demo/pkg/core/store/templates"
 	"com.aviebrantz.coap-demo/pkg/util"
 	"github.com/jeremywohl/flatten"
 	"github.com/nqd/flat"
 	"github.com/pion/dtls/v2"
 	coap "github.com/plgd-dev/go-coap/v2"
+	coapDTLS "github.com/plgd-dev/go-coap/v2/dtls"
 	"github.com/plgd-dev/go-coap/v2/message"
 	"github.com/plgd-dev/go-coap/v2/message/codes"
 	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
 
 	"github.com/fxamacker/cbor/v2"
 	"gocloud.dev/pubsub"
@@ -34,23 +39,32 @@ import (
 )
 
 type CoAPGateway struct {
-	devices   sync.Map
-	router    *mux.Router
-	dataTopic *pubsub.Topic
-	logger    *log.Entry
-	port      int
-	tlsPort   int
+	devices       sync.Map
+	identities    sync.Map
+	router        *mux.Router
+	dataTopic     *pubsub.Topic
+	deviceStore   devices.DeviceStore
+	projectStore  projects.ProjectStore
+	templateStore templates.TemplateStore
+	logger        *log.Entry
+	port          int
+	tlsPort       int
+	encoding      string
 }
 
-func NewGateway(dataTopic *pubsub.Topic, config *config.GatewayConfig) *CoAPGateway {
+func NewGateway(dataTopic *pubsub.Topic, deviceStore devices.DeviceStore, projectStore projects.ProjectStore, templateStore templates.TemplateStore, config *config.GatewayConfig, messaging config.MessagingConfig) *CoAPGateway {
 	router := mux.NewRouter()
 	logger := log.WithField("module", "coap-gateway")
 	return &CoAPGateway{
-		logger:    logger,
-		port:      config.Port,
-		tlsPort:   config.SslPort,
-		router:    router,
-		dataTopic: dataTopic,
+		logger:        logger,
+		port:          config.Port,
+		tlsPort:       config.SslPort,
+		router:        router,
+		dataTopic:     dataTopic,
+		deviceStore:   deviceStore,
+		projectStore:  projectStore,
+		templateStore: templateStore,
+		encoding:      messaging.Encoding,
 	}
 }
 
@@ -86,6 +100,9 @@ func (cg *CoAPGateway) registerClient(next mux.Handler) mux.Handler {
 			return
 		}
 		deviceID, err := getDeviceIDFromPath(path)
+		if gatewayID, _, ok := getChildIDFromPath(path); ok {
+			deviceID, err = gatewayID, nil
+		}
 		if err != nil {
 			next.ServeCOAP(w, r)
 			return
@@ -123,6 +140,11 @@ func getStateSubpath(path string) string {
 func (cg *CoAPGateway) handlePostState(ctx context.Context, w mux.ResponseWriter, req *mux.Message) {
 	path, _ := req.Options.Path()
 	deviceID, err := getDeviceIDFromPath(path)
+	// Gateways relaying uplinks of their children authenticate as themselves
+	gatewayID, childID, relayed := getChildIDFromPath(path)
+	if relayed {
+		deviceID, err = gatewayID, nil
+	}
 	if err != nil {
 		err = w.SetResponse(codes.BadRequest, message.TextPlain, nil)
 		if err != nil {
@@ -131,6 +153,9 @@ func (cg *CoAPGateway) handlePostState(ctx context.Context, w mux.ResponseWriter
 	}
 
 	subpath := getStateSubpath(path)
+	if relayed {
+		subpath = getChildSubpath(path)
+	}
 
 	if req.Body == nil {
 		err = w.SetResponse(codes.BadRequest, message.TextPlain, nil)
@@ -167,6 +192,7 @@ func (cg *CoAPGateway) handlePostState(ctx context.Context, w mux.ResponseWriter
 	if format == message.AppCBOR {
 		v := make(map[string]interface{})
 		err = cbor.Unmarshal(data, &v)
+		stringKeys(v)
 		cg.logger.Infof("Parsed Cbor %v", v)
 		if subpath != "" {
 			parsedData[subpath] = v
@@ -195,19 +221,47 @@ func (cg *CoAPGateway) handlePostState(ctx context.Context, w mux.ResponseWriter
 		return
 	}
 
-	updatesBody, err := json.Marshal(fullUpdate)
-	if err == nil {
-		err = cg.dataTopic.Send(req.Context, &pubsub.Message{
-			Body: updatesBody,
-			Metadata: map[string]string{
-				"deviceID": deviceID,
-				"time":     time.Now().String(),
-			},
-		})
+	projectID, authIdentity, err := cg.resolveProject(ctx, w.Client().RemoteAddr(), deviceID)
+	if err != nil {
+		cg.logger.Warnf("Rejecting payload for devID %s: %v", deviceID, err)
+		err = w.SetResponse(codes.Unauthorized, message.TextPlain, nil)
+		return
+	}
+
+	uplinks := map[string]map[string]interface{}{deviceID: fullUpdate}
+	if relayed {
+		uplinks, err = childUplinks(childID, fullUpdate)
 		if err != nil {
-			cg.logger.Errorf("Err publishing to message router: %v\n", err)
-		} else {
-			cg.logger.Infof("Message sent to router \n")
+			cg.logger.Warnf("Invalid batch from gateway %s: %v", gatewayID, err)
+			err = w.SetResponse(codes.BadRequest, message.TextPlain, nil)
+			return
+		}
+		err = cg.authorizeChildren(ctx, projectID, gatewayID, uplinks)
+		if err != nil {
+			cg.logger.Warnf("Rejecting payload relayed by gateway %s: %v", gatewayID, err)
+			err = w.SetResponse(codes.Forbidden, message.TextPlain, nil)
+			return
+		}
+	}
+
+	err = cg.validateUplinks(ctx, projectID, uplinks)
+	if _, invalid := err.(*templates.ValidationError); invalid {
+		cg.logger.Warnf("Rejecting payload for devID %s: %v", deviceID, err)
+		err = w.SetResponse(codes.BadRequest, message.TextPlain, bytes.NewReader([]byte(err.Error())))
+		return
+	}
+	if err != nil {
+		cg.logger.Errorf("cannot validate payload for devID %s: %v", deviceID, err)
+		err = w.SetResponse(codes.InternalServerError, message.TextPlain, nil)
+		return
+	}
+
+	for id, state := range uplinks {
+		err = cg.publishState(req.Context, id, projectID, authIdentity, gatewayID, format, state)
+		if err != nil {
+			cg.logger.Errorf("cannot build envelope: %v", err)
+			err = w.SetResponse(codes.BadGateway, message.TextPlain, nil)
+			return
 		}
 	}
 
@@ -218,6 +272,35 @@ func (cg *CoAPGateway) handlePostState(ctx context.Context, w mux.ResponseWriter
 	}
 }
 
+// publishState sends the state reported for a device to the data topic, gatewayID is set
+// when a gateway relayed it. Only envelopes that can't be built are reported, publishing is best effort
+func (cg *CoAPGateway) publishState(ctx context.Context, deviceID, projectID, authIdentity, gatewayID string, format message.MediaType, state map[string]interface{}) error {
+	// the parent and the template are assigned through the API, devices can't change them
+	delete(state, devices.ParentField)
+	delete(state, devices.TemplateField)
+	env, err := envelope.New(deviceID, "coap", state)
+	if err != nil {
+		return err
+	}
+	env.ProjectID = projectID
+	env.AuthIdentity = authIdentity
+	env.GatewayID = gatewayID
+	env.ContentFormat = format.String()
+
+	msg, err := envelope.ToMessage(env, cg.encoding)
+	if err != nil {
+		cg.logger.Errorf("cannot encode envelope: %v", err)
+		return nil
+	}
+	err = cg.dataTopic.Send(ctx, msg)
+	if err != nil {
+		cg.logger.Errorf("Err publishing to message router: %v\n", err)
+	} else {
+		cg.logger.Infof("Message sent to router \n")
+	}
+	return nil
+}
+
 func (cg *CoAPGateway) Start() {
 	cg.router.Use(cg.routerMiddleware)
 	cg.router.Use(cg.registerClient)
@@ -251,19 +334,26 @@ func (cg *CoAPGateway) Start() {
 		}
 		certPool.AddCert(cert)
 
+		listener, err := coapNet.NewDTLSListener(
+			"udp",
+			":"+strconv.Itoa(cg.tlsPort),
+			&dtls.Config{
+				Certificates:         []tls.Certificate{*certificate},
+				ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+				ClientAuth:           dtls.RequireAndVerifyClientCert,
+				ClientCAs:            certPool,
+			},
+		)
+		if err != nil {
+			cg.logger.Fatalf("Error starting dtls listener : %v", err)
+		}
+
+		// Client certificates bind devices to the project of their organization
+		server := coapDTLS.NewServer(coapDTLS.WithMux(cg.router))
 		go func() {
+			defer listener.Close()
 			cg.logger.Fatalf("Error starting dtls listener : %v",
-				coap.ListenAndServeDTLS(
-					"udp",
-					":"+strconv.Itoa(cg.tlsPort),
-					&dtls.Config{
-						Certificates:         []tls.Certificate{*certificate},
-						ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
-						ClientAuth:           dtls.RequireAndVerifyClientCert,
-						ClientCAs:            certPool,
-					},
-					cg.router,
-				))
+				server.Serve(&identityListener{DTLSListener: listener, identities: &cg.identities}))
 		}()
 	}
 }
@@ -0,0 +1,33 @@
+package coap
+
+import (
+	"context"
+)
+
+// validateUplinks checks the state reported for devices assigned a template against it,
+// a *templates.ValidationError is returned for the first field not conforming
+func (cg *CoAPGateway) validateUplinks(ctx context.Context, projectID string, uplinks map[string]map[string]interface{}) error {
+	for id, state := range uplinks {
+		device, err := cg.deviceStore.GetDeviceByID(ctx, projectID, id)
+		if err != nil {
+			return err
+		}
+		if device == nil || device.TemplateID() == "" {
+			continue
+		}
+
+		template, err := cg.templateStore.GetTemplateByID(ctx, projectID, device.TemplateID())
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			continue
+		}
+
+		err = template.ValidateState(state)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,10 +2,9 @@ package realtime
 
 import (
 	"context"
-	"encoding/json"
-	"strconv"
 	"time"
 
+	"com.aviebrantz.coap-demo/pkg/core/envelope"
 	"com.aviebrantz.coap-demo/pkg/core/store/devices"
 	"github.com/apex/log"
 	"gocloud.dev/pubsub"
@@ -36,32 +35,40 @@ func (rti RealtimeDataIngestor) Start() {
 			break
 		}
 
-		deviceID := msg.Metadata["deviceID"]
-		var reportedTime time.Time
-		timeInt, err := strconv.ParseInt(msg.Metadata["time"], 10, 64)
+		env, err := envelope.FromMessage(msg)
 		if err != nil {
+			rti.logger.Warnf("Rejecting message: %v", err)
+			// Drop msg
+			msg.Ack()
+			continue
+		}
+
+		deviceID := env.DeviceID
+		reportedTime := env.ReportedAt
+		if reportedTime.IsZero() {
 			reportedTime = time.Now()
-		} else {
-			reportedTime = time.Unix(timeInt, 0)
 		}
 
-		rti.logger.Infof("Got message: %s - %v - %q\n", deviceID, reportedTime, msg.Body)
+		rti.logger.Infof("Got message: %s - %v - %q\n", deviceID, reportedTime, env.Payload)
 
-		var updates map[string]interface{}
-		err = json.Unmarshal(msg.Body, &updates)
+		updates, err := env.Data()
 		if err != nil {
 			rti.logger.Warnf("Invalid msg format :%v", err)
 			// Drop msg
 			msg.Ack()
-			return
+			continue
 		}
 
-		err = rti.deviceStore.UpsertDevice(ctx, deviceID, reportedTime, updates)
+		source := devices.SourceGateway
+		if env.Protocol != "" {
+			source += ":" + env.Protocol
+		}
+		err = rti.deviceStore.UpsertDevice(devices.WithSource(ctx, source), env.ProjectID, deviceID, reportedTime, updates)
 
 		if err != nil {
 			rti.logger.Errorf("err update device :%v", err)
 			msg.Nack()
-			return
+			continue
 		}
 
 		// Messages must always be acknowledged with Ack.
@@ -2,10 +2,9 @@ package timeseries
 
 import (
 	"context"
-	"encoding/json"
-	"strconv"
 	"time"
 
+	"com.aviebrantz.coap-demo/pkg/core/envelope"
 	"com.aviebrantz.coap-demo/pkg/core/store/historical"
 	"github.com/apex/log"
 	"gocloud.dev/pubsub"
@@ -35,32 +34,36 @@ func (tsi TimeseriesDataIngestor) Start() {
 			break
 		}
 
-		deviceID := msg.Metadata["deviceID"]
-		var reportedTime time.Time
-		timeInt, err := strconv.ParseInt(msg.Metadata["time"], 10, 64)
+		env, err := envelope.FromMessage(msg)
 		if err != nil {
+			tsi.logger.Warnf("Rejecting message: %v", err)
+			// Drop msg
+			msg.Ack()
+			continue
+		}
+
+		deviceID := env.DeviceID
+		reportedTime := env.ReportedAt
+		if reportedTime.IsZero() {
 			reportedTime = time.Now()
-		} else {
-			reportedTime = time.Unix(timeInt, 0)
 		}
 
-		tsi.logger.Infof("Got message: %s - %v - %q\n", deviceID, reportedTime, msg.Body)
+		tsi.logger.Infof("Got message: %s - %v - %q\n", deviceID, reportedTime, env.Payload)
 
-		var datapoint map[string]interface{}
-		err = json.Unmarshal(msg.Body, &datapoint)
+		datapoint, err := env.Data()
 		if err != nil {
 			tsi.logger.Warnf("Invalid msg format :%v", err)
 			// Drop msg
 			msg.Ack()
-			return
+			continue
 		}
 
-		err = tsi.tsStore.InsertDataPoint(ctx, "device", deviceID, reportedTime, datapoint)
+		err = tsi.tsStore.InsertDataPoint(ctx, env.ProjectID, "device", deviceID, reportedTime, datapoint)
 
 		if err != nil {
 			tsi.logger.Errorf("err insert device history :%v", err)
 			msg.Nack()
-			return
+			continue
 		}
 
 		// Messages must always be acknowledged with Ack.
@@ -0,0 +1,147 @@
+package alarming
+
+import (
+	"context"
+	"encoding/json"
+
+	"com.aviebrantz.coap-demo/pkg/core/events"
+	"com.aviebrantz.coap-demo/pkg/core/store/alarms"
+	"com.aviebrantz.coap-demo/pkg/core/store/rules"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"gocloud.dev/pubsub"
+)
+
+// clearedBySystem is recorded when an alarm clears because its rule resolved
+const clearedBySystem = "system"
+
+// AlarmManager turns rule alerts from the events topic into alarms
+type AlarmManager struct {
+	eventsSub   *pubsub.Subscription
+	eventsTopic *pubsub.Topic
+	alarmStore  alarms.AlarmStore
+	logger      *log.Entry
+}
+
+func NewManager(eventsSub *pubsub.Subscription, eventsTopic *pubsub.Topic, alarmStore alarms.AlarmStore) *AlarmManager {
+	logger := log.WithField("module", "alarm-manager")
+	return &AlarmManager{
+		eventsSub:   eventsSub,
+		eventsTopic: eventsTopic,
+		alarmStore:  alarmStore,
+		logger:      logger,
+	}
+}
+
+func (am *AlarmManager) Start() {
+	for {
+		ctx := context.Background()
+		msg, err := am.eventsSub.Receive(ctx)
+		if err != nil {
+			am.logger.Infof("Receiving message: %v", err)
+			break
+		}
+
+		event, err := events.FromMessage(msg)
+		if err != nil {
+			am.logger.Warnf("Invalid event format :%v", err)
+			// Drop msg
+			msg.Ack()
+			continue
+		}
+
+		if event.Type != events.TypeAlertFiring && event.Type != events.TypeAlertResolved {
+			msg.Ack()
+			continue
+		}
+
+		alert := &rules.Alert{}
+		err = json.Unmarshal(event.Data, alert)
+		if err != nil {
+			am.logger.Warnf("Invalid alert format :%v", err)
+			msg.Ack()
+			continue
+		}
+
+		if event.Type == events.TypeAlertFiring {
+			err = am.raise(ctx, alert)
+		} else {
+			err = am.resolve(ctx, alert)
+		}
+		if err != nil {
+			am.logger.Errorf("err updating alarm :%v", err)
+			msg.Nack()
+			continue
+		}
+
+		msg.Ack()
+	}
+}
+
+func (am *AlarmManager) raise(ctx context.Context, alert *rules.Alert) error {
+	severity, err := alarms.NormalizeSeverity(alert.Severity)
+	if err != nil {
+		severity = alarms.SeverityWarning
+	}
+
+	alarm, err := am.alarmStore.FindActiveAlarm(ctx, alert.ProjectID, alert.DeviceID, alert.RuleID)
+	if err != nil {
+		return err
+	}
+
+	if alarm != nil {
+		alarm.Trigger(severity, alert.Values, alert.Time)
+		return am.alarmStore.UpdateAlarm(ctx, alarm)
+	}
+
+	alarm = &alarms.Alarm{
+		ID:            uuid.New().String(),
+		ProjectID:     alert.ProjectID,
+		DeviceID:      alert.DeviceID,
+		Type:          alert.RuleID,
+		RuleID:        alert.RuleID,
+		Message:       alert.RuleName,
+		Severity:      severity,
+		Status:        alarms.StatusActive,
+		Count:         1,
+		StartTime:     alert.Time,
+		LastTriggered: alert.Time,
+		Values:        alert.Values,
+	}
+	err = am.alarmStore.CreateAlarm(ctx, alarm)
+	if err != nil {
+		return err
+	}
+
+	am.logger.Infof("Alarm %s raised for device %s", alarm.Message, alarm.DeviceID)
+	am.publish(ctx, events.TypeAlarmRaised, alarm)
+	return nil
+}
+
+func (am *AlarmManager) resolve(ctx context.Context, alert *rules.Alert) error {
+	alarm, err := am.alarmStore.FindActiveAlarm(ctx, alert.ProjectID, alert.DeviceID, alert.RuleID)
+	if err != nil || alarm == nil {
+		return err
+	}
+
+	err = alarm.Clear(clearedBySystem, "", alert.Time)
+	if err != nil {
+		return nil
+	}
+
+	err = am.alarmStore.UpdateAlarm(ctx, alarm)
+	if err != nil {
+		return err
+	}
+
+	am.logger.Infof("Alarm %s cleared for device %s", alarm.Message, alarm.DeviceID)
+	am.publish(ctx, events.TypeAlarmCleared, alarm)
+	return nil
+}
+
+func (am *AlarmManager) publish(ctx context.Context, eventType string, alarm *alarms.Alarm) {
+	err := events.Publish(ctx, am.eventsTopic, eventType, alarm.ProjectID, alarm.DeviceID, alarm)
+	if err != nil {
+		am.logger.Errorf("err publishing alarm event: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package engine
+
+import (
+	"context"
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/envelope"
+	"com.aviebrantz.coap-demo/pkg/core/events"
+	"com.aviebrantz.coap-demo/pkg/core/store/devices"
+	"com.aviebrantz.coap-demo/pkg/core/store/rules"
+	"github.com/apex/log"
+	"github.com/google/uuid"
+	"github.com/jeremywohl/flatten"
+	"gocloud.dev/pubsub"
+)
+
+// RulesEngine evaluates project rules against every uplink on the data topic
+type RulesEngine struct {
+	dataSub     *pubsub.Subscription
+	eventsTopic *pubsub.Topic
+	deviceStore devices.DeviceStore
+	ruleStore   rules.RuleStore
+	logger      *log.Entry
+}
+
+func NewEngine(
+	dataSub *pubsub.Subscription,
+	eventsTopic *pubsub.Topic,
+	deviceStore devices.DeviceStore,
+	ruleStore rules.RuleStore,
+) *RulesEngine {
+	logger := log.WithField("module", "rules-engine")
+	return &RulesEngine{
+		dataSub:     dataSub,
+		eventsTopic: eventsTopic,
+		deviceStore: deviceStore,
+		ruleStore:   ruleStore,
+		logger:      logger,
+	}
+}
+
+func (re *RulesEngine) Start() {
+	for {
+		ctx := context.Background()
+		msg, err := re.dataSub.Receive(ctx)
+		if err != nil {
+			re.logger.Infof("Receiving message: %v", err)
+			break
+		}
+
+		env, err := envelope.FromMessage(msg)
+		if err != nil {
+			re.logger.Warnf("Rejecting message: %v", err)
+			// Drop msg
+			msg.Ack()
+			continue
+		}
+
+		err = re.process(ctx, env)
+		if err != nil {
+			re.logger.Errorf("err evaluating rules :%v", err)
+			msg.Nack()
+			continue
+		}
+
+		msg.Ack()
+	}
+}
+
+func (re *RulesEngine) process(ctx context.Context, env *envelope.Envelope) error {
+	// Rules belong to projects, nothing to evaluate for unassigned devices
+	projectID := env.ProjectID
+	if projectID == "" {
+		return nil
+	}
+
+	device, err := re.deviceStore.GetDeviceByID(ctx, projectID, env.DeviceID)
+	if err != nil {
+		return err
+	}
+
+	projectRules, err := re.ruleStore.ListRulesForProject(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	if len(projectRules) == 0 {
+		return nil
+	}
+
+	fields, err := mergedFields(device, env)
+	if err != nil {
+		re.logger.Warnf("Invalid msg format :%v", err)
+		return nil
+	}
+
+	now := env.ReportedAt
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	for _, rule := range projectRules {
+		if !rule.Enabled {
+			continue
+		}
+
+		compiled, err := compile(rule)
+		if err != nil {
+			re.logger.Warnf("skipping invalid rule %s: %v", rule.ID, err)
+			continue
+		}
+
+		err = re.evaluate(ctx, compiled, env.DeviceID, fields, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (re *RulesEngine) evaluate(ctx context.Context, cr *compiledRule, deviceID string, fields map[string]interface{}, now time.Time) error {
+	state, err := re.ruleStore.GetRuleState(ctx, cr.rule.ID, deviceID)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &rules.RuleState{
+			ID:       rules.RuleStateID(cr.rule.ID, deviceID),
+			RuleID:   cr.rule.ID,
+			DeviceID: deviceID,
+			Status:   rules.StatusInactive,
+			Since:    now,
+		}
+	}
+
+	alertState := cr.transition(state, fields, now)
+
+	err = re.ruleStore.SaveRuleState(ctx, state)
+	if err != nil {
+		return err
+	}
+
+	if alertState == "" {
+		return nil
+	}
+
+	alert := &rules.Alert{
+		ID:         uuid.New().String(),
+		ProjectID:  cr.rule.ProjectID,
+		RuleID:     cr.rule.ID,
+		RuleName:   cr.rule.Name,
+		DeviceID:   deviceID,
+		Severity:   cr.rule.Severity,
+		State:      alertState,
+		Time:       now,
+		Expression: cr.rule.Expression,
+		Values:     cr.values(fields),
+	}
+
+	re.logger.Infof("Rule %s %s for device %s", cr.rule.Name, alertState, deviceID)
+	err = re.ruleStore.InsertAlert(ctx, alert)
+	if err != nil {
+		return err
+	}
+
+	eventType := events.TypeAlertFiring
+	if alertState == rules.AlertResolved {
+		eventType = events.TypeAlertResolved
+	}
+	err = events.Publish(ctx, re.eventsTopic, eventType, alert.ProjectID, deviceID, alert)
+	if err != nil {
+		re.logger.Errorf("err publishing alert event: %v", err)
+	}
+	return nil
+}
+
+// mergedFields overlays the uplink on the last known device state, flattened with dots
+func mergedFields(device *devices.Device, env *envelope.Envelope) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	if device != nil {
+		stored, err := flatten.Flatten(device.Data, "", flatten.DotStyle)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range stored {
+			fields[k] = v
+		}
+	}
+
+	data, err := env.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	updates, err := flatten.Flatten(data, "", flatten.DotStyle)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range updates {
+		fields[k] = v
+	}
+
+	return fields, nil
+}
@@ -0,0 +1,132 @@
+package engine
+
+import (
+	"time"
+
+	"com.aviebrantz.coap-demo/pkg/core/store/rules"
+	"com.aviebrantz.coap-demo/pkg/rules/expr"
+)
+
+// compiledRule keeps the parsed expressions of a rule
+type compiledRule struct {
+	rule     *rules.Rule
+	fire     *expr.Expression
+	clear    *expr.Expression
+	forDur   time.Duration
+	clearDur time.Duration
+}
+
+// Validate checks the expressions and durations of a rule
+func Validate(rule *rules.Rule) error {
+	_, err := compile(rule)
+	return err
+}
+
+func compile(rule *rules.Rule) (*compiledRule, error) {
+	fire, err := expr.Parse(rule.Expression)
+	if err != nil {
+		return nil, err
+	}
+
+	var clear *expr.Expression
+	if rule.ClearExpression != "" {
+		clear, err = expr.Parse(rule.ClearExpression)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	forDur, err := parseDuration(rule.For)
+	if err != nil {
+		return nil, err
+	}
+
+	clearDur, err := parseDuration(rule.ClearFor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &compiledRule{
+		rule:     rule,
+		fire:     fire,
+		clear:    clear,
+		forDur:   forDur,
+		clearDur: clearDur,
+	}, nil
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return time.ParseDuration(value)
+}
+
+func (cr *compiledRule) shouldClear(fields map[string]interface{}) bool {
+	if cr.clear != nil {
+		return cr.clear.Matches(fields)
+	}
+	return !cr.fire.Matches(fields)
+}
+
+// transition moves the rule state for one evaluation at time now.
+// It returns the alert state to emit, or an empty string when nothing changed.
+func (cr *compiledRule) transition(state *rules.RuleState, fields map[string]interface{}, now time.Time) string {
+	state.LastEvaluated = now
+
+	switch state.Status {
+	case rules.StatusFiring, rules.StatusResolving:
+		if !cr.shouldClear(fields) {
+			if state.Status == rules.StatusResolving {
+				state.Status = rules.StatusFiring
+				state.Since = state.FiredAt
+			}
+			return ""
+		}
+		if state.Status == rules.StatusFiring {
+			state.Status = rules.StatusResolving
+			state.Since = now
+		}
+		if now.Sub(state.Since) >= cr.clearDur {
+			state.Status = rules.StatusInactive
+			state.Since = now
+			state.FiredAt = time.Time{}
+			return rules.AlertResolved
+		}
+		return ""
+	default:
+		if !cr.fire.Matches(fields) {
+			if state.Status != rules.StatusInactive {
+				state.Status = rules.StatusInactive
+				state.Since = now
+			}
+			return ""
+		}
+		if state.Status != rules.StatusPending {
+			state.Status = rules.StatusPending
+			state.Since = now
+		}
+		if now.Sub(state.Since) >= cr.forDur {
+			state.Status = rules.StatusFiring
+			state.Since = now
+			state.FiredAt = now
+			return rules.AlertFiring
+		}
+		return ""
+	}
+}
+
+// values collects the fields referenced by the rule, for the alert record
+func (cr *compiledRule) values(fields map[string]interface{}) map[string]interface{} {
+	values := make(map[string]interface{})
+	names := cr.fire.Fields()
+	if cr.clear != nil {
+		names = append(names, cr.clear.Fields()...)
+	}
+	for _, name := range names {
+		if v, ok := fields[name]; ok {
+			values[name] = v
+		}
+	}
+	return values
+}
@@ -0,0 +1,286 @@
+package expr
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Evaluate runs the expression against flattened fields and returns its value
+func (e *Expression) Evaluate(fields map[string]interface{}) interface{} {
+	return e.root.eval(fields)
+}
+
+// Matches evaluates the expression as a condition. Missing fields make comparisons false.
+func (e *Expression) Matches(fields map[string]interface{}) bool {
+	return truthy(e.Evaluate(fields))
+}
+
+type node interface {
+	eval(fields map[string]interface{}) interface{}
+}
+
+type literalNode struct {
+	value interface{}
+}
+
+func (n *literalNode) eval(fields map[string]interface{}) interface{} {
+	return n.value
+}
+
+type fieldNode struct {
+	path string
+}
+
+func (n *fieldNode) eval(fields map[string]interface{}) interface{} {
+	return fields[n.path]
+}
+
+type unaryNode struct {
+	op      string
+	operand node
+}
+
+func (n *unaryNode) eval(fields map[string]interface{}) interface{} {
+	v := n.operand.eval(fields)
+	if n.op == "!" {
+		return !truthy(v)
+	}
+	f, ok := toNumber(v)
+	if !ok {
+		return nil
+	}
+	return -f
+}
+
+type binaryNode struct {
+	op          string
+	left, right node
+}
+
+func (n *binaryNode) eval(fields map[string]interface{}) interface{} {
+	switch n.op {
+	case "&&":
+		return truthy(n.left.eval(fields)) && truthy(n.right.eval(fields))
+	case "||":
+		return truthy(n.left.eval(fields)) || truthy(n.right.eval(fields))
+	}
+
+	l := n.left.eval(fields)
+	r := n.right.eval(fields)
+	switch n.op {
+	case "==":
+		return equal(l, r)
+	case "!=":
+		return !equal(l, r)
+	case "<", "<=", ">", ">=":
+		c, ok := compare(l, r)
+		if !ok {
+			return false
+		}
+		switch n.op {
+		case "<":
+			return c < 0
+		case "<=":
+			return c <= 0
+		case ">":
+			return c > 0
+		default:
+			return c >= 0
+		}
+	}
+
+	if n.op == "+" {
+		if ls, ok := l.(string); ok {
+			if _, isNum := toNumber(ls); !isNum {
+				return ls + toString(r)
+			}
+		}
+	}
+
+	a, okA := toNumber(l)
+	b, okB := toNumber(r)
+	if !okA || !okB {
+		return nil
+	}
+	switch n.op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	case "/":
+		if b == 0 {
+			return nil
+		}
+		return a / b
+	case "%":
+		if b == 0 {
+			return nil
+		}
+		return math.Mod(a, b)
+	}
+	return nil
+}
+
+type function struct {
+	arity int
+	call  func(args []interface{}) interface{}
+}
+
+var functions = map[string]function{
+	"abs": {arity: 1, call: func(args []interface{}) interface{} {
+		v, ok := toNumber(args[0])
+		if !ok {
+			return nil
+		}
+		return math.Abs(v)
+	}},
+	"min": {arity: -1, call: func(args []interface{}) interface{} {
+		return reduceNumbers(args, math.Min)
+	}},
+	"max": {arity: -1, call: func(args []interface{}) interface{} {
+		return reduceNumbers(args, math.Max)
+	}},
+	"exists": {arity: 1, call: func(args []interface{}) interface{} {
+		return args[0] != nil
+	}},
+}
+
+type callNode struct {
+	name string
+	fn   function
+	args []node
+}
+
+func (n *callNode) eval(fields map[string]interface{}) interface{} {
+	values := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		values[i] = arg.eval(fields)
+	}
+	return n.fn.call(values)
+}
+
+func walk(n node, visit func(node)) {
+	visit(n)
+	switch v := n.(type) {
+	case *unaryNode:
+		walk(v.operand, visit)
+	case *binaryNode:
+		walk(v.left, visit)
+		walk(v.right, visit)
+	case *callNode:
+		for _, arg := range v.args {
+			walk(arg, visit)
+		}
+	}
+}
+
+func reduceNumbers(args []interface{}, f func(a, b float64) float64) interface{} {
+	var result interface{}
+	for _, arg := range args {
+		v, ok := toNumber(arg)
+		if !ok {
+			return nil
+		}
+		if result == nil {
+			result = v
+		} else {
+			result = f(result.(float64), v)
+		}
+	}
+	return result
+}
+
+// toNumber coerces values to float64. The local store keeps every field as a string,
+// so numeric strings count as numbers.
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, false
+		}
+		return f, true
+	}
+	return 0, false
+}
+
+func toString(v interface{}) string {
+	switch s := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return s
+	case float64:
+		return strconv.FormatFloat(s, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(s)
+	}
+	return ""
+}
+
+func truthy(v interface{}) bool {
+	switch b := v.(type) {
+	case bool:
+		return b
+	case string:
+		return b == "true"
+	case nil:
+		return false
+	}
+	f, ok := toNumber(v)
+	return ok && f != 0
+}
+
+func equal(l, r interface{}) bool {
+	if l == nil || r == nil {
+		return l == nil && r == nil
+	}
+	a, okA := toNumber(l)
+	b, okB := toNumber(r)
+	if okA && okB {
+		return a == b
+	}
+	if lb, ok := l.(bool); ok {
+		return lb == truthy(r)
+	}
+	if rb, ok := r.(bool); ok {
+		return rb == truthy(l)
+	}
+	return toString(l) == toString(r)
+}
+
+func compare(l, r interface{}) (int, bool) {
+	if l == nil || r == nil {
+		return 0, false
+	}
+	a, okA := toNumber(l)
+	b, okB := toNumber(r)
+	if okA && okB {
+		switch {
+		case a < b:
+			return -1, true
+		case a > b:
+			return 1, true
+		}
+		return 0, true
+	}
+	ls, okL := l.(string)
+	rs, okR := r.(string)
+	if okL && okR {
+		return strings.Compare(ls, rs), true
+	}
+	return 0, false
+}
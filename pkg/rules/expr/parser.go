@@ -0,0 +1,182 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Expression is a parsed rule expression, safe for concurrent evaluation
+type Expression struct {
+	source string
+	root   node
+}
+
+// Parse compiles an expression such as `temp > 40 && battery.level < 20`.
+// Identifiers refer to flattened device fields joined by dots, backquotes allow any field name.
+func Parse(source string) (*Expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", p.peek().text, p.peek().pos)
+	}
+
+	return &Expression{source: source, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Fields returns the field paths referenced by the expression
+func (e *Expression) Fields() []string {
+	fields := make([]string, 0)
+	seen := make(map[string]bool)
+	walk(e.root, func(n node) {
+		if f, ok := n.(*fieldNode); ok && !seen[f.path] {
+			seen[f.path] = true
+			fields = append(fields, f.path)
+		}
+	})
+	return fields
+}
+
+var precedence = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) parseBinary(minPrec int) (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.text]
+		if t.kind != tokenOperator || !ok || prec <= minPrec {
+			return left, nil
+		}
+		p.next()
+
+		right, err := p.parseBinary(prec)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryNode{op: t.text, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	t := p.peek()
+	if t.kind == tokenOperator && (t.text == "!" || t.text == "-") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &unaryNode{op: t.text, operand: operand}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenNumber:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q at %d", t.text, t.pos)
+		}
+		return &literalNode{value: v}, nil
+	case tokenString:
+		return &literalNode{value: t.text}, nil
+	case tokenLParen:
+		inner, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if p.next().kind != tokenRParen {
+			return nil, fmt.Errorf("missing ) for ( at %d", t.pos)
+		}
+		return inner, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return &literalNode{value: true}, nil
+		case "false":
+			return &literalNode{value: false}, nil
+		case "null":
+			return &literalNode{value: nil}, nil
+		}
+		if p.peek().kind == tokenLParen {
+			return p.parseCall(t)
+		}
+		return &fieldNode{path: t.text}, nil
+	}
+	if t.kind == tokenEOF {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.text, t.pos)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %q at %d", name.text, name.pos)
+	}
+	p.next()
+
+	args := make([]node, 0)
+	if p.peek().kind != tokenRParen {
+		for {
+			arg, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.peek().kind != tokenComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if p.next().kind != tokenRParen {
+		return nil, fmt.Errorf("missing ) for %s at %d", name.text, name.pos)
+	}
+	if fn.arity >= 0 && len(args) != fn.arity {
+		return nil, fmt.Errorf("%s expects %d arguments, got %d", name.text, fn.arity, len(args))
+	}
+	return &callNode{name: name.text, fn: fn, args: args}, nil
+}